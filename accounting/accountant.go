@@ -3,7 +3,9 @@ package accounting
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/FactomWyomingEntity/prosper-pool/config"
 	"github.com/FactomWyomingEntity/prosper-pool/difficulty"
@@ -16,6 +18,10 @@ import (
 
 var (
 	acctLog = log.WithField("mod", "acct")
+
+	// RewardRetryPeriod is how long a reward waits to be processed again,
+	// if it could not be processed.
+	RewardRetryPeriod = time.Minute
 )
 
 const AccountingPrecision = 8
@@ -23,21 +29,52 @@ const AccountingPrecision = 8
 type Accountant struct {
 	DB *gorm.DB
 
-	// Jobs are indexed by job id
+	// Jobs are indexed by job id. JobsByMiner is keyed by WorkerKey.
 	jobLock     sync.RWMutex
 	JobsByMiner map[int32]*ShareMap
 	JobsByUser  map[int32]*ShareMap
 
 	newJobs     chan int32
 	rewards     chan *Reward
+	retry       chan struct{}
 	submissions <-chan *stratum.ShareSubmission
 
 	// shares is mainly used for debug/testing. Most submissions come from
 	// Stratum.
 	shares chan *Share
 
+	// pending are the rewards whose payouts are not yet written, by job.
+	pendingLock sync.Mutex
+	pending     map[int32]*Reward
+	retrying    bool
+
 	// Pool Configuration
 	PoolFeeRate decimal.Decimal
+	// MinimumPayout is the smallest balance paid out, in PEG
+	MinimumPayout int64
+	// RetainJobs is how many jobs a share map is kept after it's payout
+	// is written.
+	RetainJobs int32
+	// Scoring is how shares are weighed in a job
+	Scoring string
+
+	// Referrers earn a share of the pool fee, and a bonus of the rewards
+	// of the users they referred for the referral period.
+	ReferralFeeShare decimal.Decimal
+	ReferralBonus    decimal.Decimal
+	ReferralPeriod   time.Duration
+
+	// FinderBonus is the part of a winning position's reward paid from the
+	// pool fee to the user whose share won it. Submitted finds the shares of
+	// the winning entries.
+	FinderBonus decimal.Decimal
+	Submitted   SubmissionFinder
+
+	// Users are checked for withholding their best shares over the last
+	// window of jobs.
+	WithholdingWindow    int32
+	WithholdingMinJobs   int
+	WithholdingThreshold float64
 }
 
 func NewAccountant(conf *viper.Viper, db *gorm.DB) (*Accountant, error) {
@@ -46,12 +83,33 @@ func NewAccountant(conf *viper.Viper, db *gorm.DB) (*Accountant, error) {
 	a.shares = make(chan *Share, 100)
 	a.rewards = make(chan *Reward, 1000)
 	a.newJobs = make(chan int32, 100)
+	a.retry = make(chan struct{}, 1)
+	a.pending = make(map[int32]*Reward)
 	a.JobsByMiner = make(map[int32]*ShareMap)
 	a.JobsByUser = make(map[int32]*ShareMap)
 
 	a.DB.AutoMigrate(&UserOwedPayouts{})
 	a.DB.AutoMigrate(&OwedPayouts{})
 	a.DB.AutoMigrate(&Paid{})
+	a.DB.AutoMigrate(&UserFeeRate{})
+	a.DB.AutoMigrate(&UserPayoutThreshold{})
+	a.DB.AutoMigrate(&PayoutRun{})
+	a.DB.AutoMigrate(&WorkerJobStats{})
+	a.DB.AutoMigrate(&ReferralOwedPayouts{})
+	a.DB.AutoMigrate(&UserPayoutSplit{})
+	a.DB.AutoMigrate(&FinderOwedPayouts{})
+	a.DB.AutoMigrate(&UserBalance{})
+	a.DB.AutoMigrate(&WithholdingStats{})
+	a.DB.AutoMigrate(&PayoutProposal{})
+	a.DB.AutoMigrate(&PayoutAction{})
+	a.DB.AutoMigrate(&ProposalReceipt{})
+
+	// Payments are computed from the balances, so they must exist
+	if n, err := MigrateUserBalances(a.DB); err != nil {
+		return nil, fmt.Errorf("user balances: %s", err.Error())
+	} else if n > 0 {
+		acctLog.WithField("users", n).Infof("user balances built from the payout history")
+	}
 
 	cut := conf.GetString(config.ConfigPoolCut)
 
@@ -61,17 +119,49 @@ func NewAccountant(conf *viper.Viper, db *gorm.DB) (*Accountant, error) {
 		a.PoolFeeRate = decimal.New(0, 0)
 	} else {
 		var err error
-		a.PoolFeeRate, err = decimal.NewFromString(cut)
+		a.PoolFeeRate, err = ParseFeeRate(cut)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("pool fee: %s", err.Error())
 		}
 	}
 
-	if a.PoolFeeRate.IntPart() > 1 || a.PoolFeeRate.IntPart() < 0 {
-		return nil, fmt.Errorf("pool fee must be between 0 and 1")
+	minimum, err := decimal.NewFromString(conf.GetString(config.ConfigPayoutMinimum))
+	if err != nil {
+		return nil, fmt.Errorf("minimum payout: %s", err.Error())
+	}
+	a.MinimumPayout = minimum.Mul(decimal.New(1e8, 0)).IntPart()
+	if a.MinimumPayout < 0 {
+		return nil, fmt.Errorf("minimum payout cannot be negative")
+	}
+
+	a.RetainJobs = conf.GetInt32(config.ConfigPoolRetainJobs)
+	if a.RetainJobs < 0 {
+		return nil, fmt.Errorf("retain jobs cannot be negative")
+	}
+
+	a.Scoring = conf.GetString(config.ConfigPoolScoring)
+	if err := ValidScoring(a.Scoring); err != nil {
+		return nil, err
+	}
+
+	a.ReferralFeeShare, err = ParseFeeRate(conf.GetString(config.ConfigReferralFeeShare))
+	if err != nil {
+		return nil, fmt.Errorf("referral fee share: %s", err.Error())
+	}
+	a.ReferralBonus, err = ParseFeeRate(conf.GetString(config.ConfigReferralBonus))
+	if err != nil {
+		return nil, fmt.Errorf("referral bonus: %s", err.Error())
+	}
+	a.ReferralPeriod = conf.GetDuration(config.ConfigReferralPeriod)
+
+	a.FinderBonus, err = ParseFeeRate(conf.GetString(config.ConfigPoolFinderBonus))
+	if err != nil {
+		return nil, fmt.Errorf("finder bonus: %s", err.Error())
 	}
 
-	a.PoolFeeRate = a.PoolFeeRate.Truncate(AccountingPrecision)
+	a.WithholdingWindow = conf.GetInt32(config.ConfigWithholdingWindow)
+	a.WithholdingMinJobs = conf.GetInt(config.ConfigWithholdingMinJobs)
+	a.WithholdingThreshold = conf.GetFloat64(config.ConfigWithholdingThreshold)
 
 	return a, nil
 }
@@ -88,6 +178,12 @@ func (a *Accountant) ShareChannel() chan<- *Share {
 	return a.shares
 }
 
+// SetSubmissionFinder sets where the submitted shares are found, to pay the
+// finder bonus
+func (a *Accountant) SetSubmissionFinder(finder SubmissionFinder) {
+	a.Submitted = finder
+}
+
 func (a *Accountant) SetSubmissions(subs <-chan *stratum.ShareSubmission) {
 	a.submissions = subs
 }
@@ -143,56 +239,175 @@ func (a *Accountant) Listen(ctx context.Context) {
 			}
 			a.NewJob(newJob)
 		case reward := <-a.rewards:
-			rLog := acctLog.WithFields(log.Fields{
-				"job": reward.JobID,
-				"peg": reward.PoolReward / 1e8,
-			})
-			// Indication of a block being completed and us earning rewards
-			if !a.JobExists(reward.JobID) {
-				// TODO: We will still do the accounting so our numbers add up.
-				// 		But we should really see if we can do something to
-				//		payout our users if this happens. Like if we reboot
-				//		the pool, and didn't keep the user's pow. We could
-				//		just use the last blocks proportions or something.
-				rLog.Warnf("reward for job that does not exist")
-				a.JobsByMiner[reward.JobID] = NewShareMap()
-				a.JobsByUser[reward.JobID] = NewShareMap()
-			}
+			a.HandleReward(reward)
+		case <-a.retry:
+			a.RetryRewards()
+		}
+	}
+}
 
-			a.jobLock.Lock()
-			us := a.JobsByUser[reward.JobID]
-			ms := a.JobsByMiner[reward.JobID]
+// HandleReward queues the reward and writes the payouts of every pending
+// reward it can.
+func (a *Accountant) HandleReward(reward *Reward) {
+	a.pendingLock.Lock()
+	defer a.pendingLock.Unlock()
+	a.pending[reward.JobID] = reward
+	a.processPending()
+}
 
-			if us.TotalDiff != ms.TotalDiff {
-				rLog.Error("miner job sum and user job sum differ")
-			}
-			us.Seal()
-			ms.Seal()
-
-			// Setup the payout struct with all the proportional payouts.
-			// This will also calculate the pool cut
-			pays := NewPayout(*reward, a.PoolFeeRate, *us)
-
-			dbErr := a.DB.FirstOrCreate(pays)
-			if dbErr.Error != nil {
-				// TODO: This is pretty bad. This means payments failed.
-				// 		We don't want to just panic and kill the pool.
-				//		Maybe, we can just write everything to a file,
-				//		and try to notify someone?
-
-				// TODO: Write to a file all the details so we can recover the payments
-				rLog.WithError(dbErr.Error).Error("failed to write payouts to database")
-			}
+// RetryRewards tries to write the payouts of the pending rewards again
+func (a *Accountant) RetryRewards() {
+	a.pendingLock.Lock()
+	defer a.pendingLock.Unlock()
+	a.retrying = false
+	a.processPending()
+}
+
+// HeldFrom returns the first job whose reward is waiting to be written, if
+// there is one. No later job is written before it.
+func (a *Accountant) HeldFrom() (int32, bool) {
+	a.pendingLock.Lock()
+	defer a.pendingLock.Unlock()
+	var first int32
+	for jobid := range a.pending {
+		if first == 0 || jobid < first {
+			first = jobid
+		}
+	}
+	return first, len(a.pending) > 0
+}
 
-			rLog.WithFields(log.Fields{"pool-diff": us.TotalDiff}).Infof("pool stats")
-			a.jobLock.Unlock()
+// processPending writes the payouts of the pending rewards in job order. Each
+// payout carries the dust of the payout before it, so a reward that cannot be
+// written holds back every later reward, and they are all tried again after
+// the retry period. The caller holds the pending lock.
+func (a *Accountant) processPending() {
+	jobs := make([]int, 0, len(a.pending))
+	for jobid := range a.pending {
+		jobs = append(jobs, int(jobid))
+	}
+	sort.Ints(jobs)
+
+	for i, jobid := range jobs {
+		reward := a.pending[int32(jobid)]
+		if err := a.processReward(reward); err != nil {
+			acctLog.WithFields(log.Fields{
+				"job":  reward.JobID,
+				"peg":  reward.PoolReward / 1e8,
+				"held": len(jobs) - i - 1,
+			}).WithError(err).Error("payouts not written, the reward will be tried again")
+			a.scheduleRetry()
+			return
 		}
+		delete(a.pending, int32(jobid))
 	}
 }
 
+// scheduleRetry retries the pending rewards after the retry period, if a
+// retry is not already scheduled.
+func (a *Accountant) scheduleRetry() {
+	if a.retrying {
+		return
+	}
+	a.retrying = true
+	time.AfterFunc(RewardRetryPeriod, func() {
+		select {
+		case a.retry <- struct{}{}:
+		default: // A retry is already waiting
+		}
+	})
+}
+
+// processReward writes the payouts of a completed block. If an error is
+// returned, nothing is written and the reward must be processed again.
+func (a *Accountant) processReward(reward *Reward) error {
+	rLog := acctLog.WithFields(log.Fields{
+		"job": reward.JobID,
+		"peg": reward.PoolReward / 1e8,
+	})
+
+	a.jobLock.Lock()
+	defer a.jobLock.Unlock()
+
+	// Indication of a block being completed and us earning rewards
+	if _, ok := a.JobsByMiner[reward.JobID]; !ok {
+		// TODO: We will still do the accounting so our numbers add up.
+		// 		But we should really see if we can do something to
+		//		payout our users if this happens. Like if we reboot
+		//		the pool, and didn't keep the user's pow. We could
+		//		just use the last blocks proportions or something.
+		rLog.Warnf("reward for job that does not exist")
+		a.JobsByMiner[reward.JobID] = NewScoredShareMap(a.Scoring)
+		a.JobsByUser[reward.JobID] = NewScoredShareMap(a.Scoring)
+	}
+
+	us := a.JobsByUser[reward.JobID]
+	ms := a.JobsByMiner[reward.JobID]
+
+	if us.TotalDiff != ms.TotalDiff {
+		rLog.Error("miner job sum and user job sum differ")
+	}
+	us.Seal()
+	ms.Seal()
+
+	// Setup the payout struct with all the proportional payouts.
+	// This will also calculate the pool cut. Without the carried dust
+	// the ledger would not balance.
+	carried, err := a.CarriedDust(reward.JobID)
+	if err != nil {
+		return fmt.Errorf("carried dust: %s", err.Error())
+	}
+
+	pays := NewCarriedPayout(*reward, carried, a.PoolFeeRate, *us)
+
+	// Some users have a negotiated fee. Without them the payout
+	// would be wrong.
+	rates, err := ActiveUserFeeRates(a.DB, reward.At())
+	if err != nil {
+		return fmt.Errorf("user fee rates: %s", err.Error())
+	}
+	pays.ApplyUserFeeRates(rates)
+
+	// Referrers get some of the fee from their referred users
+	referrals, err := ActiveReferrals(a.DB, reward.At(), a.ReferralPeriod)
+	if err != nil {
+		return fmt.Errorf("referrals: %s", err.Error())
+	}
+	pays.ApplyReferrals(referrals, a.ReferralFeeShare, a.ReferralBonus)
+
+	// The users whose shares won get a bonus
+	if !a.FinderBonus.IsZero() {
+		winners, err := WinningShares(a.DB, a.Submitted, reward.JobID)
+		if err != nil {
+			return fmt.Errorf("winning shares: %s", err.Error())
+		}
+		pays.ApplyFinderBonus(winners, a.FinderBonus)
+	}
+
+	if err := WriteOwedPayouts(a.DB, pays); err != nil {
+		return fmt.Errorf("write payouts: %s", err.Error())
+	}
+	// The shares are no longer needed once the payout is written
+	us.Written = true
+	ms.Written = true
+
+	if err := a.WriteWorkerStats(NewWorkerStats(reward.JobID, *ms)); err != nil {
+		rLog.WithError(err).Error("failed to write worker stats to database")
+	}
+
+	if err := a.WriteWithholdingStats(NewWithholdingStats(reward.JobID, *us)); err != nil {
+		rLog.WithError(err).Error("failed to write withholding stats to database")
+	} else {
+		a.checkWithholding(reward.JobID)
+	}
+
+	rLog.WithFields(log.Fields{"pool-diff": us.TotalDiff, "dust": pays.Dust}).Infof("pool stats")
+	return nil
+}
+
 func (a *Accountant) AddShare(share Share) {
 	a.jobLock.Lock()
-	a.JobsByMiner[share.JobID].AddShare(share.MinerID, share)
+	a.JobsByMiner[share.JobID].AddShare(WorkerKey(share.UserID, share.MinerID), share)
 	a.JobsByUser[share.JobID].AddShare(share.UserID, share)
 	a.jobLock.Unlock()
 }
@@ -201,8 +416,39 @@ func (a *Accountant) AddShare(share Share) {
 func (a *Accountant) NewJob(jobid int32) {
 	a.jobLock.Lock()
 	defer a.jobLock.Unlock()
-	a.JobsByMiner[jobid] = NewShareMap()
-	a.JobsByUser[jobid] = NewShareMap()
+	a.JobsByMiner[jobid] = NewScoredShareMap(a.Scoring)
+	a.JobsByUser[jobid] = NewScoredShareMap(a.Scoring)
+	a.collect(jobid)
+}
+
+// collect drops the share maps of any jobs with a written payout, that are
+// more than RetainJobs before the current job. Must be called with the job
+// lock.
+func (a *Accountant) collect(current int32) {
+	for jobid, us := range a.JobsByUser {
+		if jobid > current-a.RetainJobs || !us.Sealed || !us.Written {
+			continue
+		}
+		ms, ok := a.JobsByMiner[jobid]
+		if ok && !(ms.Sealed && ms.Written) {
+			continue
+		}
+
+		delete(a.JobsByUser, jobid)
+		delete(a.JobsByMiner, jobid)
+		shareMapsCollected.Inc()
+	}
+
+	shareMapJobs.Set(float64(len(a.JobsByUser)))
+	var users, miners int
+	for _, m := range a.JobsByUser {
+		users += len(m.Sums)
+	}
+	for _, m := range a.JobsByMiner {
+		miners += len(m.Sums)
+	}
+	shareMapUserSums.Set(float64(users))
+	shareMapMinerSums.Set(float64(miners))
 }
 
 func (a *Accountant) JobExists(jobid int32) bool {
@@ -211,3 +457,26 @@ func (a *Accountant) JobExists(jobid int32) bool {
 	_, ok := a.JobsByMiner[jobid]
 	return ok
 }
+
+// checkWithholding logs any users flagged for withholding their best shares
+func (a *Accountant) checkWithholding(jobid int32) {
+	reports, err := DetectWithholding(a.DB, jobid-a.WithholdingWindow+1, a.WithholdingMinJobs, a.WithholdingThreshold)
+	if err != nil {
+		acctLog.WithError(err).Error("failed to check for share withholding")
+		return
+	}
+
+	for _, r := range reports {
+		if !r.Flagged {
+			continue
+		}
+		acctLog.WithFields(log.Fields{
+			"job":      jobid,
+			"userid":   r.UserID,
+			"jobs":     r.Jobs,
+			"score":    r.Score,
+			"expected": r.Expected,
+			"observed": r.Observed,
+		}).Warn("user's best shares are below expectation, they may be withholding shares")
+	}
+}
@@ -0,0 +1,135 @@
+package accounting_test
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountant_Collect(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+	a.RetainJobs = 2
+
+	a.NewJob(1)
+	// Job 1 is paid
+	a.JobsByUser[1].Seal()
+	a.JobsByMiner[1].Seal()
+	a.JobsByUser[1].Written = true
+	a.JobsByMiner[1].Written = true
+
+	a.NewJob(2)
+	require.True(a.JobExists(1), "job 1 is within the retained jobs")
+	// Job 2 is sealed, but the payout failed to write
+	a.JobsByUser[2].Seal()
+	a.JobsByMiner[2].Seal()
+
+	a.NewJob(3)
+	require.False(a.JobExists(1), "job 1 should be collected")
+
+	a.NewJob(4)
+	require.True(a.JobExists(2), "job 2 payout is not written")
+	require.True(a.JobExists(3))
+	require.True(a.JobExists(4))
+}
+
+func TestNewAccountant_MinimumPayout(t *testing.T) {
+	require := require.New(t)
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(err)
+	defer db.Close()
+
+	conf := viper.New()
+	config.SetDefaults(conf)
+	conf.Set(config.ConfigPayoutMinimum, "-1")
+	_, err = NewAccountant(conf, db)
+	require.Error(err)
+
+	conf.Set(config.ConfigPayoutMinimum, "2.5")
+	a, err := NewAccountant(conf, db)
+	require.NoError(err)
+	require.Equal(int64(2.5e8), a.MinimumPayout)
+}
+
+func TestAccountant_RewardRetry(t *testing.T) {
+	// A payout is never written without everything it pays
+	for _, table := range []string{"owed_payouts", "user_fee_rates", "invite_codes", "pegnet_payouts"} {
+		t.Run(table, func(t *testing.T) {
+			require := require.New(t)
+			a := AccountantForTests(t)
+			defer a.DB.Close()
+			a.FinderBonus = a.PoolFeeRate
+			a.SetSubmissionFinder(testFinder{})
+
+			for job := int32(1); job <= 3; job++ {
+				a.NewJob(job)
+				a.AddShare(Share{JobID: job, UserID: "a", MinerID: "a_1", Difficulty: 1})
+			}
+			a.HandleReward(&Reward{JobID: 1, PoolReward: 100e8 + 1})
+			requirePayouts(t, a.DB, 1)
+
+			// Job 3 is held back while job 2 cannot be written, as it
+			// carries job 2's dust
+			fail := failQueries(a.DB, table)
+			a.HandleReward(&Reward{JobID: 2, PoolReward: 100e8 + 1})
+			a.HandleReward(&Reward{JobID: 3, PoolReward: 100e8 + 1})
+			*fail = false
+			requirePayouts(t, a.DB, 1)
+
+			a.RetryRewards()
+			requirePayouts(t, a.DB, 1, 2, 3)
+			report, err := CheckDustLedger(a.DB, 0, 0)
+			require.NoError(err)
+			require.True(report.Balanced(), report.Discrepancies)
+			require.Equal(int64(300e8+3), report.TotalRewards)
+		})
+	}
+}
+
+// requirePayouts checks the jobs with written payouts
+func requirePayouts(t *testing.T, db *gorm.DB, jobs ...int32) {
+	var payouts []OwedPayouts
+	require.NoError(t, db.Order("job_id asc").Find(&payouts).Error)
+	written := make([]int32, len(payouts))
+	for i := range payouts {
+		written[i] = payouts[i].JobID
+	}
+	require.Equal(t, jobs, written)
+}
+
+// failQueries fails every query of the table while the returned flag is set
+func failQueries(db *gorm.DB, table string) *bool {
+	fail := true
+	db.Callback().Query().Before("gorm:query").Register("test:fail_"+table, func(scope *gorm.Scope) {
+		if fail && scope.TableName() == table {
+			scope.Err(fmt.Errorf("%s is unavailable", table))
+		}
+	})
+	return &fail
+}
+
+func AccountantForTests(t *testing.T) *Accountant {
+	require := require.New(t)
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(err)
+	// The in memory db is per connection
+	db.DB().SetMaxOpenConns(1)
+	// Payouts read the referrals and the graded blocks
+	db.AutoMigrate(&authentication.InviteCode{})
+	db.AutoMigrate(&database.PegnetPayout{})
+
+	conf := viper.New()
+	config.SetDefaults(conf)
+	a, err := NewAccountant(conf, db)
+	require.NoError(err)
+	return a
+}
@@ -0,0 +1,199 @@
+package accounting
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/jinzhu/gorm"
+)
+
+// AuditReport is the balance sheet of the pool. The rewards, fees, dust and
+// user payouts are for the job range, and the paid amounts and user balances
+// are for all time, as payments are not tied to jobs.
+type AuditReport struct {
+	DustReport
+
+	// ChainRewards is the rewards of our oprs in the graded blocks. It
+	// is only checked if the identity or coinbase is given.
+	ChainRewards int64 `json:"chainrewards"`
+
+	TotalOwedAllTime int64         `json:"totalowedalltime"`
+	TotalPaid        int64         `json:"totalpaid"`
+	UserOutstanding  int64         `json:"useroutstanding"`
+	Users            []UserBalance `json:"users"`
+}
+
+// Balanced is true if there are no discrepancies, and the dust ledger
+// balances.
+func (r AuditReport) Balanced() bool {
+	return r.DustReport.Balanced()
+}
+
+// Audit checks the books balance. On top of the dust ledger, every user
+// payout must belong to a reward, the rewards must match the graded blocks,
+// and no user can be paid more than they are owed. A 'to' of 0 audits to the
+// latest job.
+func Audit(db *gorm.DB, from, to int32, identity, coinbase string) (*AuditReport, error) {
+	dust, err := CheckDustLedger(db, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &AuditReport{DustReport: *dust}
+	if err := r.orphanPayouts(db, from, to); err != nil {
+		return nil, err
+	}
+
+	if identity != "" || coinbase != "" {
+		if err := r.chainRewards(db, from, to, identity, coinbase); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.userBalances(db); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(r.Discrepancies, func(i, j int) bool {
+		return r.Discrepancies[i].JobID < r.Discrepancies[j].JobID
+	})
+	return r, nil
+}
+
+// orphanPayouts finds user payouts with no reward for the job
+func (r *AuditReport) orphanPayouts(db *gorm.DB, from, to int32) error {
+	q := db.Table("user_owed_payouts").
+		Select("DISTINCT user_owed_payouts.job_id").
+		Joins("LEFT JOIN owed_payouts ON owed_payouts.job_id = user_owed_payouts.job_id").
+		Where("owed_payouts.job_id IS NULL").
+		Where("user_owed_payouts.job_id >= ?", from)
+	if to > 0 {
+		q = q.Where("user_owed_payouts.job_id <= ?", to)
+	}
+
+	rows, err := q.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var job int32
+		if err := rows.Scan(&job); err != nil {
+			return err
+		}
+		r.Discrepancies = append(r.Discrepancies, LedgerDiscrepancy{
+			JobID:  job,
+			Reason: "user payouts exist, but there is no reward for the job",
+		})
+	}
+	return rows.Err()
+}
+
+// chainRewards compares the rewards to the graded payouts to our oprs
+func (r *AuditReport) chainRewards(db *gorm.DB, from, to int32, identity, coinbase string) error {
+	q := db.Model(&database.PegnetPayout{}).
+		Select("height, sum(reward)").
+		Where("identity = ? OR coinbase_address = ?", identity, coinbase).
+		Where("height >= ?", from)
+	if to > 0 {
+		q = q.Where("height <= ?", to)
+	}
+
+	rows, err := q.Group("height").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	chain := make(map[int32]int64)
+	for rows.Next() {
+		var height int32
+		var sum int64
+		if err := rows.Scan(&height, &sum); err != nil {
+			return err
+		}
+		chain[height] = sum
+		r.ChainRewards += sum
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var rewards []OwedPayouts
+	q = db.Select("job_id, pool_reward").Where("job_id >= ?", from)
+	if to > 0 {
+		q = q.Where("job_id <= ?", to)
+	}
+	if err := q.Find(&rewards).Error; err != nil {
+		return err
+	}
+
+	recorded := make(map[int32]bool)
+	for _, p := range rewards {
+		recorded[p.JobID] = true
+		if p.PoolReward != chain[p.JobID] {
+			r.Discrepancies = append(r.Discrepancies, LedgerDiscrepancy{
+				JobID:  p.JobID,
+				Reason: fmt.Sprintf("reward of %d, but the graded block paid %d", p.PoolReward, chain[p.JobID]),
+			})
+		}
+	}
+
+	for height, sum := range chain {
+		if !recorded[height] && sum > 0 {
+			r.Discrepancies = append(r.Discrepancies, LedgerDiscrepancy{
+				JobID:  height,
+				Reason: fmt.Sprintf("the graded block paid %d, but no reward was recorded", sum),
+			})
+		}
+	}
+	return nil
+}
+
+// userBalances totals what every user is owed and paid for all time, and
+// checks the user balance table matches.
+func (r *AuditReport) userBalances(db *gorm.DB) error {
+	balances, err := historicBalances(db)
+	if err != nil {
+		return err
+	}
+
+	table, err := UserBalances(db)
+	if err != nil {
+		return err
+	}
+
+	r.Users = []UserBalance{}
+	for _, b := range balances {
+		r.TotalOwedAllTime += b.Owed
+		r.TotalPaid += b.Paid
+		r.UserOutstanding += b.Outstanding
+		if b.Outstanding < 0 {
+			r.Discrepancies = append(r.Discrepancies, LedgerDiscrepancy{
+				UserID: b.UserID,
+				Reason: fmt.Sprintf("paid %d, but only owed %d", b.Paid, b.Owed),
+			})
+		}
+		r.Users = append(r.Users, *b)
+	}
+
+	for user := range table {
+		if _, ok := balances[user]; !ok {
+			balances[user] = &UserBalance{UserID: user}
+		}
+	}
+	for user, b := range balances {
+		if t := table[user]; t.Owed != b.Owed || t.Paid != b.Paid || t.Outstanding != b.Outstanding {
+			r.Discrepancies = append(r.Discrepancies, LedgerDiscrepancy{
+				UserID: user,
+				Reason: fmt.Sprintf("balance table has owed %d and paid %d, but the history has owed %d and paid %d",
+					t.Owed, t.Paid, b.Owed, b.Paid),
+			})
+		}
+	}
+
+	sort.Slice(r.Users, func(i, j int) bool { return r.Users[i].UserID < r.Users[j].UserID })
+	return nil
+}
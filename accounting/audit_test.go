@@ -0,0 +1,57 @@
+package accounting_test
+
+import (
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudit(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+	a.DB.AutoMigrate(&database.PegnetPayout{})
+
+	for job := int32(1); job <= 10; job++ {
+		carried, err := a.CarriedDust(job)
+		require.NoError(err)
+
+		r := Reward{JobID: job, PoolReward: 800e8}
+		pays := NewCarriedPayout(r, carried, a.PoolFeeRate, *randomShareMap(job, 5))
+		require.NoError(WriteOwedPayouts(a.DB, pays))
+		require.NoError(a.DB.Create(&database.PegnetPayout{Height: job, Reward: 800e8, Identity: "prosper"}).Error)
+	}
+
+	report, err := Audit(a.DB, 0, 0, "prosper", "")
+	require.NoError(err)
+	require.True(report.Balanced(), "%v", report.Discrepancies)
+	require.Equal(report.TotalRewards, report.ChainRewards)
+	require.Equal(report.TotalOwed, report.UserOutstanding)
+
+	// Overpay a user
+	user := report.Users[0]
+	require.NoError(a.DB.Create(&Paid{UserID: user.UserID, EntryHash: "aa", PaymentAmount: user.Owed + 10}).Error)
+	// A reward the pool missed
+	require.NoError(a.DB.Create(&database.PegnetPayout{Height: 11, Reward: 200e8, Identity: "prosper"}).Error)
+	// User payouts for a job with no reward
+	require.NoError(a.DB.Create(&UserOwedPayouts{JobID: 12, UserID: user.UserID, Payout: 1}).Error)
+
+	report, err = Audit(a.DB, 0, 0, "prosper", "")
+	require.NoError(err)
+	require.False(report.Balanced())
+	// Overpaid, and the balance table missed the payment and payout
+	require.Len(report.Discrepancies, 4)
+	require.Equal(user.UserID, report.Discrepancies[0].UserID)
+	require.Equal(user.UserID, report.Discrepancies[1].UserID)
+	require.Equal(int32(11), report.Discrepancies[2].JobID)
+	require.Equal(int32(12), report.Discrepancies[3].JobID)
+
+	// Rebuilding the balances fixes the table, but not the overpay
+	_, err = RebuildUserBalances(a.DB)
+	require.NoError(err)
+	report, err = Audit(a.DB, 0, 0, "prosper", "")
+	require.NoError(err)
+	require.Len(report.Discrepancies, 3)
+}
@@ -0,0 +1,198 @@
+package accounting
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// UserBalance is everything owed to and paid to a user. Owed includes
+// referral income and finder bonuses. The user_balances table is kept in
+// step with the owed payouts and payments as they are written, so balances
+// never need to be summed from the history.
+type UserBalance struct {
+	UserID      string `gorm:"primary_key" json:"userid"`
+	Owed        int64  `json:"owed"`
+	Paid        int64  `json:"paid"`
+	Outstanding int64  `json:"outstanding"`
+}
+
+// WriteOwedPayouts writes the payouts of a job, and adds them to the user
+// balances in the same transaction. If the job's payouts are already
+// written, nothing is changed.
+func WriteOwedPayouts(db *gorm.DB, pays *OwedPayouts) error {
+	tx := db.Begin()
+	var exists OwedPayouts
+	err := tx.Where("job_id = ?", pays.JobID).First(&exists).Error
+	if err == nil {
+		tx.Rollback()
+		return nil // Already written
+	}
+	if err != gorm.ErrRecordNotFound {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Create(pays).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, p := range pays.UserPayouts {
+		if err := addBalance(tx, p.UserID, p.Payout, 0); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, p := range pays.ReferralPayouts {
+		if err := addBalance(tx, p.UserID, p.Payout, 0); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, p := range pays.FinderPayouts {
+		if err := addBalance(tx, p.UserID, p.Payout, 0); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// addBalance adds to what the user is owed and paid. It should be called
+// within a transaction.
+func addBalance(tx *gorm.DB, userid string, owed, paid int64) error {
+	res := tx.Model(&UserBalance{}).Where("user_id = ?", userid).Updates(map[string]interface{}{
+		"owed":        gorm.Expr("owed + ?", owed),
+		"paid":        gorm.Expr("paid + ?", paid),
+		"outstanding": gorm.Expr("outstanding + ?", owed-paid),
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+
+	return tx.Create(&UserBalance{
+		UserID:      userid,
+		Owed:        owed,
+		Paid:        paid,
+		Outstanding: owed - paid,
+	}).Error
+}
+
+// UserBalances returns the balance of every user, indexed by userid
+func UserBalances(db *gorm.DB) (map[string]UserBalance, error) {
+	var balances []UserBalance
+	err := db.Find(&balances).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	m := make(map[string]UserBalance)
+	for _, b := range balances {
+		m[b.UserID] = b
+	}
+	return m, nil
+}
+
+// GetUserBalance returns the balance of the user. A user with no balance
+// has a zero balance.
+func GetUserBalance(db *gorm.DB, userid string) (UserBalance, error) {
+	b := UserBalance{UserID: userid}
+	err := db.Where("user_id = ?", userid).First(&b).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return b, err
+	}
+	return b, nil
+}
+
+// MigrateUserBalances builds the user balances from the history if the table
+// is empty, as it is when upgrading a pool from before the table was kept.
+// It returns the number of balances built.
+func MigrateUserBalances(db *gorm.DB) (int, error) {
+	var balances, owed, paid int
+	if err := db.Model(&UserBalance{}).Count(&balances).Error; err != nil {
+		return 0, err
+	}
+	if balances > 0 {
+		return 0, nil
+	}
+	if err := db.Model(&UserOwedPayouts{}).Count(&owed).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Model(&Paid{}).Count(&paid).Error; err != nil {
+		return 0, err
+	}
+	if owed == 0 && paid == 0 {
+		return 0, nil
+	}
+	return RebuildUserBalances(db)
+}
+
+// RebuildUserBalances recomputes the user balances from the owed payouts and
+// payments.
+func RebuildUserBalances(db *gorm.DB) (int, error) {
+	balances, err := historicBalances(db)
+	if err != nil {
+		return 0, err
+	}
+
+	tx := db.Begin()
+	if err := tx.Delete(&UserBalance{}).Error; err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	for _, b := range balances {
+		if err := tx.Create(b).Error; err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return len(balances), tx.Commit().Error
+}
+
+// historicBalances sums every user's balance from the owed payouts and
+// payments.
+func historicBalances(db *gorm.DB) (map[string]*UserBalance, error) {
+	balances := make(map[string]*UserBalance)
+	get := func(user string) *UserBalance {
+		if _, ok := balances[user]; !ok {
+			balances[user] = &UserBalance{UserID: user}
+		}
+		return balances[user]
+	}
+
+	sum := func(q *gorm.DB, column string, set func(b *UserBalance, amt int64)) error {
+		rows, err := q.Select(fmt.Sprintf("user_id, sum(%s)", column)).Group("user_id").Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var user string
+			var amt sql.NullInt64
+			if err := rows.Scan(&user, &amt); err != nil {
+				return err
+			}
+			set(get(user), amt.Int64)
+		}
+		return rows.Err()
+	}
+
+	for _, table := range []string{"user_owed_payouts", "referral_owed_payouts", "finder_owed_payouts"} {
+		if err := sum(db.Table(table), "payout", func(b *UserBalance, amt int64) { b.Owed += amt }); err != nil {
+			return nil, err
+		}
+	}
+	if err := sum(db.Model(&Paid{}), "payment_amount", func(b *UserBalance, amt int64) { b.Paid = amt }); err != nil {
+		return nil, err
+	}
+
+	for _, b := range balances {
+		b.Outstanding = b.Owed - b.Paid
+	}
+	return balances, nil
+}
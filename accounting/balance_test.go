@@ -0,0 +1,67 @@
+package accounting_test
+
+import (
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteOwedPayouts(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+
+	pays := NewPayout(Reward{JobID: 1, PoolReward: 800e8}, a.PoolFeeRate, *randomShareMap(1, 5))
+	require.NoError(WriteOwedPayouts(a.DB, pays))
+	// Writing a job twice does not count it twice
+	require.NoError(WriteOwedPayouts(a.DB, pays))
+
+	user := pays.UserPayouts[0]
+	b, err := GetUserBalance(a.DB, user.UserID)
+	require.NoError(err)
+	require.Equal(user.Payout, b.Owed)
+	require.Equal(user.Payout, b.Outstanding)
+
+	require.NoError(a.WritePayments([]Paid{{UserID: user.UserID, EntryHash: "aa", PaymentAmount: user.Payout - 1}}))
+	b, err = GetUserBalance(a.DB, user.UserID)
+	require.NoError(err)
+	require.Equal(user.Payout-1, b.Paid)
+	require.Equal(int64(1), b.Outstanding)
+
+	// The rebuilt balances match
+	before, err := UserBalances(a.DB)
+	require.NoError(err)
+	n, err := RebuildUserBalances(a.DB)
+	require.NoError(err)
+	require.Equal(len(pays.UserPayouts), n)
+	after, err := UserBalances(a.DB)
+	require.NoError(err)
+	require.Equal(before, after)
+
+	b, err = GetUserBalance(a.DB, "unknown@gmail.com")
+	require.NoError(err)
+	require.Zero(b.Owed)
+}
+
+func TestMigrateUserBalances(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+
+	// A pool upgraded from before the balances were kept
+	require.NoError(a.DB.Create(&UserOwedPayouts{JobID: 1, UserID: "a@gmail.com", Payout: 10e8}).Error)
+	require.NoError(a.DB.Create(&Paid{UserID: "a@gmail.com", PaymentAmount: 4e8}).Error)
+
+	n, err := MigrateUserBalances(a.DB)
+	require.NoError(err)
+	require.Equal(1, n)
+	b, err := GetUserBalance(a.DB, "a@gmail.com")
+	require.NoError(err)
+	require.Equal(int64(6e8), b.Outstanding)
+
+	// Only once
+	n, err = MigrateUserBalances(a.DB)
+	require.NoError(err)
+	require.Zero(n)
+}
@@ -0,0 +1,56 @@
+package accounting
+
+import (
+	"strconv"
+)
+
+// MaxBatchSize is the most data a factom entry can hold, which limits the
+// size of a FAT-2 transaction batch.
+const MaxBatchSize = 10240
+
+// The sizes of the parts of a signed FAT-2 batch entry. A batch has a single
+// input, so it is signed once, with a timestamp, RCD, and signature extid,
+// each with a 2 byte length.
+const (
+	faAddressLen  = 52
+	batchOverhead = len(`{"version":1,"transactions":[]}`) +
+		(2 + 10) + (2 + 33) + (2 + 64)
+	txOverhead = len(`{"input":{"address":"","amount":,"type":"PEG"},"transfers":[]},`) +
+		faAddressLen + 20
+	transferOverhead = len(`{"address":"","amount":},`) + faAddressLen
+)
+
+// AssignBatches splits the payments into FAT-2 batches that fit in an entry,
+// by setting the batch of each payment. A user is paid in a single
+// transaction, so all their payments are in the same batch. The sizes are an
+// upper bound of the signed entry. It returns the number of batches.
+func AssignBatches(payments []Paid) int {
+	if len(payments) == 0 {
+		return 0
+	}
+
+	// The size of each user's transaction
+	userSize := make(map[string]int)
+	for _, p := range payments {
+		if _, ok := userSize[p.UserID]; !ok {
+			userSize[p.UserID] = txOverhead
+		}
+		userSize[p.UserID] += transferOverhead + len(strconv.FormatInt(p.PaymentAmount, 10))
+	}
+
+	userBatch := make(map[string]int)
+	batch, size := 0, batchOverhead
+	for i, p := range payments {
+		b, ok := userBatch[p.UserID]
+		if !ok {
+			if size+userSize[p.UserID] > MaxBatchSize && size > batchOverhead {
+				batch, size = batch+1, batchOverhead
+			}
+			b = batch
+			size += userSize[p.UserID]
+			userBatch[p.UserID] = b
+		}
+		payments[i].Batch = b
+	}
+	return batch + 1
+}
@@ -0,0 +1,160 @@
+package accounting
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The OwedPayouts form the dust ledger. The dust of every payout is carried
+// into the next payout, so the sum of all user payouts, pool fees and the
+// latest dust is exactly the sum of all pool rewards.
+
+// CarriedDust returns the dust left over from the last payout before the job.
+func (a *Accountant) CarriedDust(jobid int32) (int64, error) {
+	var last OwedPayouts
+	err := a.DB.Where("job_id < ?", jobid).Order("job_id desc").First(&last).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil // Nothing to carry
+	}
+	if err != nil {
+		return 0, err
+	}
+	return last.Dust, nil
+}
+
+// LedgerDiscrepancy is a job or user where the books do not balance
+type LedgerDiscrepancy struct {
+	JobID  int32  `json:"jobid,omitempty"`
+	UserID string `json:"userid,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// DustReport is the result of checking the dust ledger over a range of jobs
+type DustReport struct {
+	FirstJob int32 `json:"firstjob"`
+	LastJob  int32 `json:"lastjob"`
+	Jobs     int   `json:"jobs"`
+
+	TotalRewards int64 `json:"totalrewards"`
+	TotalOwed    int64 `json:"totalowed"` // Sum of all user payouts
+	TotalFees    int64 `json:"totalfees"`
+	// TotalReferrals is the sum of all referral payouts, paid from the fees
+	TotalReferrals int64 `json:"totalreferrals"`
+	// TotalFinderBonuses is the sum of all finder bonuses, paid from the fees
+	TotalFinderBonuses int64 `json:"totalfinderbonuses"`
+	// CarriedIn is the dust carried into the first job
+	CarriedIn int64 `json:"carriedin"`
+	// Outstanding is the dust of the last job, to be carried to the next
+	Outstanding int64 `json:"outstanding"`
+
+	Discrepancies []LedgerDiscrepancy `json:"discrepancies"`
+}
+
+// Balanced is true if every job balanced, and the totals balance.
+func (r DustReport) Balanced() bool {
+	return len(r.Discrepancies) == 0 &&
+		r.TotalRewards+r.CarriedIn == r.TotalOwed+r.TotalFees+r.TotalReferrals+r.TotalFinderBonuses+r.Outstanding
+}
+
+// CheckDustLedger verifies every payout in the job range distributes exactly
+// its reward plus the carried dust, and the carried dust is the last
+// payout's dust. A 'to' of 0 checks to the latest job.
+func CheckDustLedger(db *gorm.DB, from, to int32) (*DustReport, error) {
+	q := db.Where("job_id >= ?", from)
+	if to > 0 {
+		q = q.Where("job_id <= ?", to)
+	}
+
+	var payouts []OwedPayouts
+	if err := q.Order("job_id asc").Find(&payouts).Error; err != nil {
+		return nil, err
+	}
+
+	owed, err := sumPayouts(db, "user_owed_payouts", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	referred, err := sumPayouts(db, "referral_owed_payouts", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := sumPayouts(db, "finder_owed_payouts", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(DustReport)
+	r.Discrepancies = []LedgerDiscrepancy{}
+	if len(payouts) == 0 {
+		return r, nil
+	}
+
+	var prev OwedPayouts
+	err = db.Where("job_id < ?", payouts[0].JobID).Order("job_id desc").First(&prev).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	prevDust := prev.Dust
+
+	r.FirstJob = payouts[0].JobID
+	r.LastJob = payouts[len(payouts)-1].JobID
+	r.Jobs = len(payouts)
+	r.CarriedIn = payouts[0].CarriedDust
+	for _, p := range payouts {
+		if p.CarriedDust != prevDust {
+			r.Discrepancies = append(r.Discrepancies, LedgerDiscrepancy{
+				JobID:  p.JobID,
+				Reason: fmt.Sprintf("carried %d dust, but the last payout had %d dust", p.CarriedDust, prevDust),
+			})
+		}
+
+		distributed := owed[p.JobID] + p.PoolFee + referred[p.JobID] + found[p.JobID] + p.Dust
+		if distributed != p.Distributable() {
+			r.Discrepancies = append(r.Discrepancies, LedgerDiscrepancy{
+				JobID: p.JobID,
+				Reason: fmt.Sprintf("distributed %d (owed %d, fee %d, referrals %d, finder bonuses %d, dust %d), but had %d (reward %d, carried %d)",
+					distributed, owed[p.JobID], p.PoolFee, referred[p.JobID], found[p.JobID], p.Dust, p.Distributable(), p.PoolReward, p.CarriedDust),
+			})
+		}
+
+		r.TotalRewards += p.PoolReward
+		r.TotalOwed += owed[p.JobID]
+		r.TotalFees += p.PoolFee
+		r.TotalReferrals += referred[p.JobID]
+		r.TotalFinderBonuses += found[p.JobID]
+		prevDust = p.Dust
+	}
+	r.Outstanding = prevDust
+
+	return r, nil
+}
+
+// sumPayouts returns the sum of all payouts in the table indexed by job
+func sumPayouts(db *gorm.DB, table string, from, to int32) (map[int32]int64, error) {
+	q := db.Table(table).
+		Select("job_id, sum(payout)").
+		Where("job_id >= ?", from)
+	if to > 0 {
+		q = q.Where("job_id <= ?", to)
+	}
+
+	rows, err := q.Group("job_id").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sums := make(map[int32]int64)
+	for rows.Next() {
+		var job int32
+		var sum int64
+		if err := rows.Scan(&job, &sum); err != nil {
+			return nil, err
+		}
+		sums[job] = sum
+	}
+	return sums, rows.Err()
+}
@@ -0,0 +1,48 @@
+package accounting_test
+
+import (
+	"math/rand"
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDustLedger(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+
+	var totalRewards int64
+	for job := int32(1); job <= 50; job++ {
+		carried, err := a.CarriedDust(job)
+		require.NoError(err)
+
+		r := Reward{JobID: job, PoolReward: rand.Int63() % (1e4 * 1e8)}
+		totalRewards += r.PoolReward
+		pays := NewCarriedPayout(r, carried, a.PoolFeeRate, *randomShareMap(job, rand.Int()%20))
+		if len(pays.UserPayouts) > 0 {
+			referrals := map[string]string{pays.UserPayouts[0].UserID: "referrer"}
+			pays.ApplyReferrals(referrals, decimal.RequireFromString("0.5"), decimal.Zero)
+		}
+		require.NoError(a.DB.Create(pays).Error)
+	}
+
+	report, err := CheckDustLedger(a.DB, 0, 0)
+	require.NoError(err)
+	require.Empty(report.Discrepancies)
+	require.True(report.Balanced())
+	require.Equal(50, report.Jobs)
+	require.NotZero(report.TotalReferrals)
+	require.Equal(totalRewards, report.TotalOwed+report.TotalFees+report.TotalReferrals+report.Outstanding)
+
+	// Lose some dust
+	require.NoError(a.DB.Model(&OwedPayouts{}).Where("job_id = ?", 20).Update("carried_dust", gorm.Expr("carried_dust + 1")).Error)
+	report, err = CheckDustLedger(a.DB, 0, 0)
+	require.NoError(err)
+	require.False(report.Balanced())
+	require.Equal(int32(20), report.Discrepancies[0].JobID)
+}
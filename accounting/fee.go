@@ -0,0 +1,109 @@
+package accounting
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/jinzhu/gorm"
+	"github.com/shopspring/decimal"
+)
+
+// UserFeeRate overrides the pool fee rate for a single user. A rate applies
+// to the rewards of blocks made at or after EffectiveFrom, and until
+// EffectiveUntil if it is set. If a user has more than 1 active rate, the one with the
+// latest EffectiveFrom wins.
+type UserFeeRate struct {
+	gorm.Model     `json:"-"`
+	UserID         string          `gorm:"index:fee_user_id" json:"userid"`
+	FeeRate        decimal.Decimal `sql:"type:decimal(20,8);" json:"feerate"`
+	EffectiveFrom  time.Time       `json:"effectivefrom"`
+	EffectiveUntil *time.Time      `json:"effectiveuntil,omitempty"`
+	// Note is for the admins, like "early supporter"
+	Note string `json:"note"`
+}
+
+// ParseFeeRate parses a fee rate, and ensures it is between 0 and 1.
+func ParseFeeRate(rate string) (decimal.Decimal, error) {
+	r, err := decimal.NewFromString(rate)
+	if err != nil {
+		return r, err
+	}
+
+	if r.LessThan(decimal.Zero) || r.GreaterThan(decimal.New(1, 0)) {
+		return r, fmt.Errorf("fee rate must be between 0 and 1")
+	}
+
+	return r.Truncate(AccountingPrecision), nil
+}
+
+// ParseDate accepts a date as '2006-01-02' in UTC, or a full RFC3339 timestamp.
+func ParseDate(date string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", date); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, date)
+}
+
+// AddUserFeeRate validates and inserts a new fee rate for a user.
+func AddUserFeeRate(db *gorm.DB, fee *UserFeeRate) error {
+	if fee.FeeRate.LessThan(decimal.Zero) || fee.FeeRate.GreaterThan(decimal.New(1, 0)) {
+		return fmt.Errorf("fee rate must be between 0 and 1")
+	}
+
+	if fee.EffectiveUntil != nil && !fee.EffectiveUntil.After(fee.EffectiveFrom) {
+		return fmt.Errorf("fee rate must end after it begins")
+	}
+
+	var u authentication.User
+	if err := db.Where("uid = ?", fee.UserID).First(&u).Error; err != nil {
+		return fmt.Errorf("user %s: %s", fee.UserID, err.Error())
+	}
+
+	fee.FeeRate = fee.FeeRate.Truncate(AccountingPrecision)
+	return db.Create(fee).Error
+}
+
+// EndUserFeeRate ends the fee rate at the given time. Only a rate in effect
+// can be ended, so the fees of past rewards are not changed.
+func EndUserFeeRate(db *gorm.DB, id uint, at time.Time) error {
+	res := db.Model(&UserFeeRate{}).
+		Where("id = ? AND effective_from <= ?", id, at).
+		Where("effective_until IS NULL OR effective_until > ?", at).
+		Update("effective_until", at)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+
+	var fee UserFeeRate
+	if err := db.First(&fee, id).Error; err != nil {
+		return fmt.Errorf("fee rate %d: %s", id, err.Error())
+	}
+	if fee.EffectiveFrom.After(at) {
+		return fmt.Errorf("fee rate %d has not started, it begins %s", id, fee.EffectiveFrom.UTC())
+	}
+	return fmt.Errorf("fee rate %d already ended %s", id, fee.EffectiveUntil.UTC())
+}
+
+// ActiveUserFeeRates returns the fee rate overrides in effect at the given time
+// indexed by userid. Users not in the map pay the pool fee rate.
+func ActiveUserFeeRates(db *gorm.DB, at time.Time) (map[string]decimal.Decimal, error) {
+	var fees []UserFeeRate
+	err := db.Where("effective_from <= ?", at).
+		Where("effective_until IS NULL OR effective_until > ?", at).
+		Order("effective_from asc").
+		Find(&fees).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	rates := make(map[string]decimal.Decimal)
+	for _, f := range fees {
+		// Sorted ascending, so the latest rate overwrites
+		rates[f.UserID] = f.FeeRate
+	}
+	return rates, nil
+}
@@ -0,0 +1,72 @@
+package accounting_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountant_RewardFeeRates(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+
+	// The user had no fee when the block was made, but does now
+	block := time.Now().Add(-48 * time.Hour)
+	until := block.Add(time.Hour)
+	free := UserFeeRate{UserID: "a", FeeRate: decimal.Zero, EffectiveFrom: block.Add(-time.Hour), EffectiveUntil: &until}
+	require.NoError(a.DB.Create(&free).Error)
+	require.NoError(a.DB.Create(&UserFeeRate{UserID: "a", FeeRate: decimal.RequireFromString("0.1"), EffectiveFrom: until}).Error)
+
+	a.NewJob(1)
+	a.AddShare(Share{JobID: 1, UserID: "a", MinerID: "a_1", Difficulty: 1})
+
+	// Without the fee rates the payout is not written
+	fail := failQueries(a.DB, "user_fee_rates")
+	a.HandleReward(&Reward{JobID: 1, PoolReward: 100e8, BlockTime: block})
+	var count int
+	require.NoError(a.DB.Model(&UserOwedPayouts{}).Where("job_id = ?", 1).Count(&count).Error)
+	require.Zero(count)
+
+	// It is tried again once they can be loaded
+	*fail = false
+	a.RetryRewards()
+	var owed UserOwedPayouts
+	require.NoError(a.DB.Where("job_id = ?", 1).First(&owed).Error)
+	require.Equal("a", owed.UserID)
+	require.True(owed.FeeRate.IsZero(), "the fee rate at the time of the block applies")
+	require.Equal(int64(100e8), owed.Payout)
+}
+
+func TestEndUserFeeRate(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+
+	now := time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)
+	ended := now.Add(-time.Hour)
+	rates := []UserFeeRate{
+		{UserID: "a", FeeRate: decimal.Zero, EffectiveFrom: now.Add(-48 * time.Hour)},
+		{UserID: "a", FeeRate: decimal.Zero, EffectiveFrom: now.Add(-48 * time.Hour), EffectiveUntil: &ended},
+		{UserID: "a", FeeRate: decimal.Zero, EffectiveFrom: now.Add(time.Hour)},
+	}
+	for i := range rates {
+		require.NoError(a.DB.Create(&rates[i]).Error)
+	}
+
+	require.NoError(EndUserFeeRate(a.DB, rates[0].ID, now))
+	// Ended rates keep their end, and future rates cannot end before they begin
+	require.Error(EndUserFeeRate(a.DB, rates[0].ID, now.Add(time.Hour)))
+	require.Error(EndUserFeeRate(a.DB, rates[1].ID, now))
+	require.Error(EndUserFeeRate(a.DB, rates[2].ID, now))
+	require.Error(EndUserFeeRate(a.DB, 100, now))
+
+	var fees []UserFeeRate
+	require.NoError(a.DB.Order("id asc").Find(&fees).Error)
+	require.True(fees[0].EffectiveUntil.Equal(now))
+	require.True(fees[1].EffectiveUntil.Equal(ended))
+	require.Nil(fees[2].EffectiveUntil)
+}
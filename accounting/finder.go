@@ -0,0 +1,97 @@
+package accounting
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/FactomWyomingEntity/prosper-pool/stratum"
+	"github.com/jinzhu/gorm"
+	"github.com/shopspring/decimal"
+)
+
+// FinderOwedPayouts is the bonus for the user whose share was submitted as
+// an opr that placed in a paying position. It is paid from the pool fee.
+type FinderOwedPayouts struct {
+	JobID     int32  `gorm:"primary_key;auto_increment:false" json:"jobid"`
+	Position  int32  `gorm:"primary_key;auto_increment:false" json:"position"`
+	UserID    string `gorm:"index:finder_user_id" json:"userid"`
+	MinerID   string `json:"minerid"`
+	EntryHash string `json:"entryhash"`
+	Reward    int64  `json:"reward"` // The reward of the position, in PEG
+	Payout    int64  `json:"payout"` // In PEG
+}
+
+// SubmissionFinder finds the shares we submitted to factomd by their entry
+// hashes, so the user whose share won can be paid.
+type SubmissionFinder interface {
+	SubmittedShares(jobid int32, entryhashes []string) (map[string]stratum.ShareSubmission, error)
+}
+
+// WinningShares finds the submissions of ours that placed in a paying
+// position of the graded block, by matching the entry hashes.
+func WinningShares(db *gorm.DB, finder SubmissionFinder, jobid int32) ([]FinderOwedPayouts, error) {
+	if finder == nil {
+		return nil, fmt.Errorf("no submission finder")
+	}
+
+	var payouts []database.PegnetPayout
+	err := db.Where("height = ? AND reward > 0", jobid).Order("position asc").Find(&payouts).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	if len(payouts) == 0 {
+		return nil, nil
+	}
+
+	hashes := make([]string, len(payouts))
+	for i := range payouts {
+		hashes[i] = hex.EncodeToString(payouts[i].EntryHash)
+	}
+
+	submitted, err := finder.SubmittedShares(jobid, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	var winners []FinderOwedPayouts
+	for i, p := range payouts {
+		s, ok := submitted[hashes[i]]
+		if !ok {
+			continue // Not one of ours
+		}
+		winners = append(winners, FinderOwedPayouts{
+			JobID:     jobid,
+			Position:  p.Position,
+			UserID:    s.Username,
+			MinerID:   s.MinerID,
+			EntryHash: hashes[i],
+			Reward:    p.Reward,
+		})
+	}
+	return winners, nil
+}
+
+// ApplyFinderBonus moves the bonus of each winning position's reward from
+// the pool fee to the user whose share won it. The bonuses are never more
+// than the pool fee.
+func (p *OwedPayouts) ApplyFinderBonus(winners []FinderOwedPayouts, bonus decimal.Decimal) {
+	if bonus.IsZero() {
+		return
+	}
+
+	for _, w := range winners {
+		amt := cut(w.Reward, bonus)
+		if amt > p.PoolFee {
+			amt = p.PoolFee
+		}
+		if amt <= 0 {
+			continue
+		}
+
+		p.PoolFee -= amt
+		w.JobID = p.JobID
+		w.Payout = amt
+		p.FinderPayouts = append(p.FinderPayouts, w)
+	}
+}
@@ -0,0 +1,82 @@
+package accounting_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/FactomWyomingEntity/prosper-pool/stratum"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWinningShares(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+	a.DB.AutoMigrate(&database.PegnetPayout{})
+
+	hash := func(b byte) []byte {
+		h := make([]byte, 32)
+		h[0] = b
+		return h
+	}
+
+	// Two of our oprs placed, one with no reward. Another pool placed too.
+	payouts := []database.PegnetPayout{
+		{Height: 10, Position: 0, Reward: 800e8, EntryHash: hash(1)},
+		{Height: 10, Position: 1, Reward: 600e8, EntryHash: hash(2)},
+		{Height: 10, Position: 30, Reward: 0, EntryHash: hash(3)},
+	}
+	for i := range payouts {
+		require.NoError(a.DB.Create(&payouts[i]).Error)
+	}
+	submitted := testFinder{}
+	for _, b := range []byte{1, 3} {
+		submitted[hex.EncodeToString(hash(b))] = stratum.ShareSubmission{Username: "a@gmail.com", MinerID: "rig1", JobID: 10}
+	}
+
+	_, err := WinningShares(a.DB, nil, 10)
+	require.Error(err)
+	winners, err := WinningShares(a.DB, submitted, 10)
+	require.NoError(err)
+	require.Len(winners, 1)
+	require.Equal(int32(0), winners[0].Position)
+	require.Equal("a@gmail.com", winners[0].UserID)
+	require.Equal("rig1", winners[0].MinerID)
+
+	p := NewPayout(Reward{JobID: 10, PoolReward: 800e8}, decimal.RequireFromString("0.05"), *randomShareMap(10, 5))
+	fee := p.PoolFee
+	p.ApplyFinderBonus(winners, decimal.RequireFromString("0.01"))
+	require.Len(p.FinderPayouts, 1)
+	require.Equal(int64(8e8), p.FinderPayouts[0].Payout)
+	require.Equal(fee-8e8, p.PoolFee)
+
+	// The bonus never exceeds the pool fee
+	p = NewPayout(Reward{JobID: 10, PoolReward: 800e8}, decimal.RequireFromString("0.05"), *randomShareMap(10, 5))
+	fee = p.PoolFee
+	p.ApplyFinderBonus(winners, decimal.RequireFromString("0.5"))
+	require.Equal(fee, p.FinderPayouts[0].Payout)
+	require.Zero(p.PoolFee)
+
+	// The ledger still balances
+	require.NoError(a.DB.Create(p).Error)
+	report, err := CheckDustLedger(a.DB, 0, 0)
+	require.NoError(err)
+	require.True(report.Balanced(), report.Discrepancies)
+	require.Equal(fee, report.TotalFinderBonuses)
+}
+
+// testFinder is the shares we submitted, by entry hash
+type testFinder map[string]stratum.ShareSubmission
+
+func (f testFinder) SubmittedShares(jobid int32, entryhashes []string) (map[string]stratum.ShareSubmission, error) {
+	shares := make(map[string]stratum.ShareSubmission)
+	for _, h := range entryhashes {
+		if s, ok := f[h]; ok && s.JobID == jobid {
+			shares[h] = s
+		}
+	}
+	return shares, nil
+}
@@ -0,0 +1,37 @@
+package accounting
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	shareMapJobs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pool_accounting_sharemap_jobs",
+		Help: "Number of jobs with share maps in memory",
+	})
+	shareMapUserSums = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pool_accounting_sharemap_user_sums",
+		Help: "Number of user share sums across all jobs in memory",
+	})
+	shareMapMinerSums = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pool_accounting_sharemap_miner_sums",
+		Help: "Number of miner share sums across all jobs in memory",
+	})
+	shareMapsCollected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pool_accounting_sharemap_collected_total",
+		Help: "Number of jobs with share maps dropped from memory",
+	})
+)
+
+var prom sync.Once
+
+func RegisterPrometheus() {
+	prom.Do(func() {
+		prometheus.MustRegister(shareMapJobs)
+		prometheus.MustRegister(shareMapUserSums)
+		prometheus.MustRegister(shareMapMinerSums)
+		prometheus.MustRegister(shareMapsCollected)
+	})
+}
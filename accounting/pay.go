@@ -1,7 +1,7 @@
 package accounting
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 
 	"github.com/FactomWyomingEntity/prosper-pool/authentication"
@@ -14,14 +14,55 @@ type Paid struct {
 	UserID        string `gorm:"index:user_id"`
 	PayoutAddress string
 	PaymentAmount int64
+	// Batch is the FAT-2 batch the payment is sent in
+	Batch int `gorm:"-"`
 
 	// tmp fields for debugging
 	TotalOwed int64 `gorm:"-"`
 	TotalPaid int64 `gorm:"-"`
 }
 
+// UserPayoutThreshold overrides the pool's minimum payout for a user
+type UserPayoutThreshold struct {
+	UserID        string `gorm:"primary_key" json:"userid"`
+	MinimumPayout int64  `json:"minimumpayout"` // In PEG
+}
+
+// SetUserPayoutThreshold sets the minimum payout for the user
+func SetUserPayoutThreshold(db *gorm.DB, userid string, minimum int64) error {
+	if minimum < 0 {
+		return fmt.Errorf("minimum payout cannot be negative")
+	}
+
+	var u authentication.User
+	if err := db.Where("uid = ?", userid).First(&u).Error; err != nil {
+		return fmt.Errorf("user %s: %s", userid, err.Error())
+	}
+
+	t := UserPayoutThreshold{UserID: userid}
+	return db.Where(t).Assign(UserPayoutThreshold{MinimumPayout: minimum}).FirstOrCreate(&t).Error
+}
+
+// PayoutThresholds returns the minimum payout overrides indexed by userid
+func PayoutThresholds(db *gorm.DB) (map[string]int64, error) {
+	var thresholds []UserPayoutThreshold
+	err := db.Find(&thresholds).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	m := make(map[string]int64)
+	for _, t := range thresholds {
+		m[t.UserID] = t.MinimumPayout
+	}
+	return m, nil
+}
+
 // CalculatePayments does not insert the payments. It just preps them for
-// insert
+// insert. The balances come from the user balance table. Balances under the
+// user's minimum payout are left for a later payout. Users with payout splits
+// have a payment per split. The payments are split into batches that each
+// fit in an entry.
 func (a *Accountant) CalculatePayments() ([]Paid, error) {
 	var users []authentication.User
 	err := a.DB.Find(&users).Error
@@ -29,6 +70,21 @@ func (a *Accountant) CalculatePayments() ([]Paid, error) {
 		return nil, err
 	}
 
+	thresholds, err := PayoutThresholds(a.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	splits, err := PayoutSplits(a.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	balances, err := UserBalances(a.DB)
+	if err != nil {
+		return nil, err
+	}
+
 	// Entryhash will not be filled out, since we don't know it yet
 	var payments []Paid
 
@@ -36,57 +92,94 @@ func (a *Accountant) CalculatePayments() ([]Paid, error) {
 		var p Paid
 		p.UserID = u.UID
 		p.PayoutAddress = u.PayoutAddress
-		// Sum up what we paid
-		var paid sql.NullInt64
-		paidRow := a.DB.Table("paids").
-			Where("user_id = ?", u.UID).Select("sum(payment_amount)").Row()
-		err = paidRow.Scan(&paid)
-		if err != nil {
-			return nil, err
-		}
-		p.TotalPaid = paid.Int64
-
-		// Sum up what we owe
-		var owed sql.NullInt64
-		owedRow := a.DB.Table("user_owed_payouts").
-			Where("user_id = ?", u.UID).Select("sum(payout)").Row()
-		err = owedRow.Scan(&owed)
-		if err != nil {
-			return nil, err
-		}
-		p.TotalOwed = owed.Int64
+		b := balances[u.UID]
+		p.TotalOwed = b.Owed
+		p.TotalPaid = b.Paid
 
 		p.PaymentAmount = p.TotalOwed - p.TotalPaid
-		if p.PaymentAmount != 0 { // Don't include 0 payments
-			payments = append(payments, p)
+		if p.PaymentAmount == 0 { // Don't include 0 payments
+			continue
+		}
+
+		minimum := a.MinimumPayout
+		if t, ok := thresholds[u.UID]; ok {
+			minimum = t
 		}
+		if p.PaymentAmount > 0 && p.PaymentAmount < minimum {
+			continue // Too small to pay this time
+		}
+		payments = append(payments, SplitPayment(p, splits[u.UID])...)
 	}
 
+	AssignBatches(payments)
 	return payments, nil
 }
 
+// ReceiptVerifier checks the payments of a receipt were applied on chain
+type ReceiptVerifier interface {
+	VerifyReceipt(ctx context.Context, payments []Paid) error
+}
+
+// RecordPayments verifies the receipt before recording it. Receipts that are
+// not confirmed on chain are refused.
+func (a *Accountant) RecordPayments(ctx context.Context, v ReceiptVerifier, payments []Paid) error {
+	if len(payments) == 0 {
+		return fmt.Errorf("no payments to record")
+	}
+
+	if err := v.VerifyReceipt(ctx, payments); err != nil {
+		return fmt.Errorf("receipt not verified: %s", err.Error())
+	}
+	return a.WritePayments(payments)
+}
+
+// WritePayments records the payments of a receipt. A receipt can have many
+// entries, if the payout was split into batches. None of the entries can be
+// recorded already.
 func (a *Accountant) WritePayments(payments []Paid) error {
+	tx := a.DB.Begin()
+	if err := writePayments(tx, payments); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// writePayments records the payments in the transaction
+func writePayments(tx *gorm.DB, payments []Paid) error {
 	if len(payments) == 0 {
 		return fmt.Errorf("no payments to record")
 	}
 
-	if payments[0].EntryHash == "" {
-		return fmt.Errorf("this is not a receipt, no entryhash")
+	var hashes []string
+	seen := make(map[string]bool)
+	for _, p := range payments {
+		if p.EntryHash == "" {
+			return fmt.Errorf("this is not a receipt, no entryhash")
+		}
+		if !seen[p.EntryHash] {
+			seen[p.EntryHash] = true
+			hashes = append(hashes, p.EntryHash)
+		}
 	}
+
 	var f Paid
-	res := a.DB.Model(&Paid{}).Where("entry_hash = ?", payments[0].EntryHash).First(&f)
+	res := tx.Model(&Paid{}).Where("entry_hash IN (?)", hashes).First(&f)
 	if res.RowsAffected > 0 {
-		return fmt.Errorf("this tx is already recorded")
+		return fmt.Errorf("tx %s is already recorded", f.EntryHash)
 	}
 
-	tx := a.DB.Begin()
 	for _, payment := range payments {
-		err := tx.Create(&payment).Error
-		if err != nil {
-			tx.Rollback()
+		if err := tx.Create(&payment).Error; err != nil {
+			return err
+		}
+		if err := addBalance(tx, payment.UserID, 0, payment.PaymentAmount); err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit().Error
+	// A scheduled run is confirmed once it's receipt is recorded
+	return tx.Model(&PayoutRun{}).
+		Where("entry_hash IN (?) AND state = ?", hashes, RunSubmitted).
+		Update("state", RunConfirmed).Error
 }
@@ -0,0 +1,76 @@
+package accounting
+
+import (
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/jinzhu/gorm"
+	"github.com/shopspring/decimal"
+)
+
+// ReferralOwedPayouts is what a referrer earned from the work of a user they
+// referred. It is paid from the pool fee.
+type ReferralOwedPayouts struct {
+	JobID      int32  `gorm:"primary_key;auto_increment:false" json:"jobid"`
+	UserID     string `gorm:"primary_key" json:"userid"` // The referrer
+	ReferredID string `gorm:"primary_key" json:"referredid"`
+	Payout     int64  `json:"payout"` // In PEG
+}
+
+// ActiveReferrals returns the referrer of every user still within the
+// referral period, indexed by the referred user. A period of 0 never ends.
+func ActiveReferrals(db *gorm.DB, at time.Time, period time.Duration) (map[string]string, error) {
+	q := db.Where("claimed = ? AND referrer <> ''", true)
+	if period > 0 {
+		q = q.Where("claimed_time > ?", at.Add(-period))
+	}
+
+	var codes []authentication.InviteCode
+	err := q.Find(&codes).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	referrals := make(map[string]string)
+	for _, c := range codes {
+		if c.Referrer != c.ClaimedBy {
+			referrals[c.ClaimedBy] = c.Referrer
+		}
+	}
+	return referrals, nil
+}
+
+// ApplyReferrals moves part of the pool fee to the referrer of any referred
+// user. The referrer earns the fee share of the fee taken from the user,
+// plus the bonus of the user's rewards. The bonus is paid from the pool's fee
+// as a whole, so a user with no fee still earns it for their referrer. It is
+// never more than the pool fee left.
+func (p *OwedPayouts) ApplyReferrals(referrals map[string]string, feeShare, bonus decimal.Decimal) {
+	if feeShare.IsZero() && bonus.IsZero() {
+		return
+	}
+
+	for _, pay := range p.UserPayouts {
+		referrer, ok := referrals[pay.UserID]
+		if !ok {
+			continue
+		}
+
+		gross := cut(p.Distributable(), pay.Proportion)
+		amt := cut(pay.PoolFee, feeShare) + cut(gross, bonus)
+		if amt > p.PoolFee {
+			amt = p.PoolFee
+		}
+		if amt <= 0 {
+			continue
+		}
+
+		p.PoolFee -= amt
+		p.ReferralPayouts = append(p.ReferralPayouts, ReferralOwedPayouts{
+			JobID:      p.JobID,
+			UserID:     referrer,
+			ReferredID: pay.UserID,
+			Payout:     amt,
+		})
+	}
+}
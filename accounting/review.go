@@ -0,0 +1,422 @@
+package accounting
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// PayoutProposal states
+const (
+	// ProposalProposed is waiting on a second admin
+	ProposalProposed = "proposed"
+	// ProposalApproved can be submitted or exported
+	ProposalApproved = "approved"
+	// ProposalRejected will never be paid
+	ProposalRejected = "rejected"
+	// ProposalExecuted was submitted or exported to be signed
+	ProposalExecuted = "executed"
+)
+
+// PayoutProposal is a payout an admin proposed. A different admin must
+// approve it before it can be signed and submitted.
+type PayoutProposal struct {
+	gorm.Model
+	State      string `gorm:"index:proposal_state"`
+	ProposedBy string
+	ApprovedBy string
+	// RunID is the payout run, if submitted by the pool
+	RunID uint
+
+	Count int
+	Total int64 // In PEG
+	// Digest is the sha256 of the payments json
+	Digest string
+	// Payments is the json of the payments
+	Payments string `gorm:"type:text"`
+}
+
+// GetPayments returns the payments of the proposal
+func (p PayoutProposal) GetPayments() ([]Paid, error) {
+	var payments []Paid
+	err := json.Unmarshal([]byte(p.Payments), &payments)
+	return payments, err
+}
+
+// PayoutAction is an audit record of an admin action on a proposal
+type PayoutAction struct {
+	gorm.Model
+	ProposalID uint   `gorm:"index:action_proposal_id"`
+	Admin      string `gorm:"index:action_admin"`
+	Action     string
+	Detail     string
+}
+
+// PaymentAnomaly is a payment an admin should look at before approving
+type PaymentAnomaly struct {
+	UserID        string
+	PayoutAddress string
+	Amount        int64 // In PEG
+	Reason        string
+}
+
+// ReviewPayments finds payments to addresses that were never paid before,
+// and payments more than the large factor times the user's average payment.
+func ReviewPayments(db *gorm.DB, payments []Paid, largeFactor int64) ([]PaymentAnomaly, error) {
+	type past struct {
+		UserID string
+		Count  int64
+		Total  int64
+	}
+	var history []past
+	err := db.Model(&Paid{}).Select("user_id, count(*) as count, sum(payment_amount) as total").
+		Group("user_id").Scan(&history).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	averages := make(map[string]int64)
+	for _, h := range history {
+		if h.Count > 0 {
+			averages[h.UserID] = h.Total / h.Count
+		}
+	}
+
+	var addresses []string
+	err = db.Model(&Paid{}).Group("payout_address").Pluck("payout_address", &addresses).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for _, a := range addresses {
+		known[a] = true
+	}
+
+	var anomalies []PaymentAnomaly
+	for _, p := range payments {
+		anomaly := PaymentAnomaly{UserID: p.UserID, PayoutAddress: p.PayoutAddress, Amount: p.PaymentAmount}
+		if !known[p.PayoutAddress] {
+			anomaly.Reason = "new address"
+			anomalies = append(anomalies, anomaly)
+		}
+		if avg, ok := averages[p.UserID]; ok && largeFactor > 0 && p.PaymentAmount > largeFactor*avg {
+			anomaly.Reason = fmt.Sprintf("more than %dx the average payment of %d", largeFactor, avg)
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+	return anomalies, nil
+}
+
+// PaymentsDigest is the sha256 of the payments json, so admins can check
+// they are looking at the same payments.
+func PaymentsDigest(payments []Paid) (string, error) {
+	data, err := json.Marshal(payments)
+	if err != nil {
+		return "", err
+	}
+	return digest(data), nil
+}
+
+func digest(data []byte) string {
+	d := sha256.Sum256(data)
+	return hex.EncodeToString(d[:])
+}
+
+// ProposePayout records the payments as a proposal. Only one proposal can be
+// open at a time.
+func ProposePayout(db *gorm.DB, admin string, payments []Paid) (*PayoutProposal, error) {
+	if len(payments) == 0 {
+		return nil, fmt.Errorf("nothing to payout")
+	}
+
+	var open PayoutProposal
+	err := db.Where("state IN (?)", []string{ProposalProposed, ProposalApproved}).First(&open).Error
+	if err == nil {
+		return nil, fmt.Errorf("proposal %d is still %s", open.ID, open.State)
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	data, err := json.Marshal(payments)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PayoutProposal{
+		State:      ProposalProposed,
+		ProposedBy: admin,
+		Count:      len(payments),
+		Digest:     digest(data),
+		Payments:   string(data),
+	}
+	for _, pay := range payments {
+		p.Total += pay.PaymentAmount
+	}
+
+	tx := db.Begin()
+	if err := tx.Create(p).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := LogPayoutAction(tx, p.ID, admin, "propose", fmt.Sprintf("%d payments, %d total, digest %s", p.Count, p.Total, p.Digest)); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return p, tx.Commit().Error
+}
+
+// ApprovePayout approves a proposal. The approving admin cannot be the
+// proposer, and no payment can be more than the user is still owed.
+func ApprovePayout(db *gorm.DB, id uint, admin string) (*PayoutProposal, error) {
+	p, err := openProposal(db, id, ProposalProposed)
+	if err != nil {
+		return nil, err
+	}
+	if p.ProposedBy == admin {
+		return nil, fmt.Errorf("the proposal must be approved by a different admin")
+	}
+	if err := checkOutstanding(db, p); err != nil {
+		return nil, err
+	}
+
+	return p, setProposalState(db, p, admin, ProposalApproved, "approve", "")
+}
+
+// RejectPayout rejects a proposed or approved proposal
+func RejectPayout(db *gorm.DB, id uint, admin, reason string) (*PayoutProposal, error) {
+	p, err := openProposal(db, id, ProposalProposed, ProposalApproved)
+	if err != nil {
+		return nil, err
+	}
+	return p, setProposalState(db, p, admin, ProposalRejected, "reject", reason)
+}
+
+// ExecutePayout marks an approved proposal as executed, and returns it's
+// payments to sign and submit. The detail says how it was executed.
+func ExecutePayout(db *gorm.DB, id uint, admin, detail string) (*PayoutProposal, []Paid, error) {
+	p, err := openProposal(db, id, ProposalApproved)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkOutstanding(db, p); err != nil {
+		return nil, nil, err
+	}
+
+	payments, err := p.GetPayments()
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, payments, setProposalState(db, p, admin, ProposalExecuted, "execute", detail)
+}
+
+// LogPayoutAction adds an audit record for the proposal
+func LogPayoutAction(db *gorm.DB, id uint, admin, action, detail string) error {
+	return db.Create(&PayoutAction{ProposalID: id, Admin: admin, Action: action, Detail: detail}).Error
+}
+
+// MatchProposal returns the executed proposal the receipt pays. A proposal
+// split into batches can be recorded a batch at a time, so the receipt must
+// pay some of the proposal's payments that are not recorded yet, in any
+// order.
+func MatchProposal(db *gorm.DB, receipt []Paid) (*PayoutProposal, error) {
+	if len(receipt) == 0 {
+		return nil, fmt.Errorf("no payments to record")
+	}
+
+	var executed []PayoutProposal
+	err := db.Where("state = ? AND count >= ?", ProposalExecuted, len(receipt)).Order("id desc").Find(&executed).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range executed {
+		left, err := unrecordedPayments(db, &executed[i])
+		if err != nil {
+			return nil, err
+		}
+		match := true
+		for _, p := range receipt {
+			if left[paymentKey(p)] == 0 {
+				match = false
+				break
+			}
+			left[paymentKey(p)]--
+		}
+		if match {
+			return &executed[i], nil
+		}
+	}
+	return nil, fmt.Errorf("the receipt does not match the unrecorded payments of any executed payout proposal")
+}
+
+// ProposalReceipt is a batch of a proposal that was recorded as paid
+type ProposalReceipt struct {
+	gorm.Model
+	ProposalID uint   `gorm:"index:receipt_proposal_id"`
+	EntryHash  string `gorm:"unique_index:receipt_entry_hash"`
+}
+
+// RecordProposalPayments records the payments of a receipt for the proposal,
+// and marks it's batches as recorded so they cannot be matched again. Both
+// are written in one transaction. The receipt is verified first, if there is
+// a verifier.
+func (a *Accountant) RecordProposalPayments(ctx context.Context, v ReceiptVerifier, id uint, admin string, receipt []Paid) error {
+	if v != nil {
+		if err := v.VerifyReceipt(ctx, receipt); err != nil {
+			return fmt.Errorf("receipt not verified: %s", err.Error())
+		}
+	}
+
+	tx := a.DB.Begin()
+	if err := writePayments(tx, receipt); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range receipt {
+		if seen[p.EntryHash] {
+			continue
+		}
+		seen[p.EntryHash] = true
+		if err := tx.Create(&ProposalReceipt{ProposalID: id, EntryHash: p.EntryHash}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := LogPayoutAction(tx, id, admin, "record", fmt.Sprintf("%d payments in %d batches", len(receipt), len(seen))); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func paymentKey(p Paid) string {
+	return fmt.Sprintf("%s %s %d", p.UserID, p.PayoutAddress, p.PaymentAmount)
+}
+
+// unrecordedPayments counts the payments of the proposal that are not in a
+// recorded batch yet
+func unrecordedPayments(db *gorm.DB, p *PayoutProposal) (map[string]int, error) {
+	payments, err := p.GetPayments()
+	if err != nil {
+		return nil, err
+	}
+	left := make(map[string]int)
+	for _, payment := range payments {
+		left[paymentKey(payment)]++
+	}
+
+	var hashes []string
+	err = db.Model(&ProposalReceipt{}).Where("proposal_id = ?", p.ID).Pluck("entry_hash", &hashes).Error
+	if err != nil || len(hashes) == 0 {
+		return left, err
+	}
+
+	var recorded []Paid
+	if err := db.Where("entry_hash IN (?)", hashes).Find(&recorded).Error; err != nil {
+		return nil, err
+	}
+	for _, payment := range recorded {
+		if left[paymentKey(payment)] > 0 {
+			left[paymentKey(payment)]--
+		}
+	}
+	return left, nil
+}
+
+func openProposal(db *gorm.DB, id uint, states ...string) (*PayoutProposal, error) {
+	var p PayoutProposal
+	if err := db.First(&p, id).Error; err != nil {
+		return nil, fmt.Errorf("proposal %d: %s", id, err.Error())
+	}
+	for _, s := range states {
+		if p.State == s {
+			return &p, nil
+		}
+	}
+	return nil, fmt.Errorf("proposal %d is %s", id, p.State)
+}
+
+func setProposalState(db *gorm.DB, p *PayoutProposal, admin, state, action, detail string) error {
+	tx := db.Begin()
+	update := map[string]interface{}{"state": state}
+	if state == ProposalApproved {
+		update["approved_by"] = admin
+	}
+	// Only move from the state it was read in, in case of a race
+	res := tx.Model(&PayoutProposal{}).Where("id = ? AND state = ?", p.ID, p.State).Updates(update)
+	if res.Error != nil {
+		tx.Rollback()
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		tx.Rollback()
+		return fmt.Errorf("proposal %d changed, try again", p.ID)
+	}
+	if err := LogPayoutAction(tx, p.ID, admin, action, detail); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	p.State = state
+	if state == ProposalApproved {
+		p.ApprovedBy = admin
+	}
+	return nil
+}
+
+// checkOutstanding ensures the proposal does not pay users more than they
+// are owed, in case they were paid since it was proposed.
+func checkOutstanding(db *gorm.DB, p *PayoutProposal) error {
+	payments, err := p.GetPayments()
+	if err != nil {
+		return err
+	}
+
+	balances, err := UserBalances(db)
+	if err != nil {
+		return err
+	}
+
+	pay := make(map[string]int64)
+	for _, payment := range payments {
+		pay[payment.UserID] += payment.PaymentAmount
+	}
+	for user, amt := range pay {
+		if amt > 0 && amt > balances[user].Outstanding {
+			return fmt.Errorf("%s is paid %d, but only owed %d", user, amt, balances[user].Outstanding)
+		}
+	}
+	return nil
+}
+
+// SubmitProposal executes an approved proposal by submitting it's payments
+// as a new run. The run is recorded on the proposal, even if it failed.
+func (s *PayoutScheduler) SubmitProposal(ctx context.Context, id uint, admin string) (*PayoutRun, error) {
+	if s.Signer == nil {
+		return nil, fmt.Errorf("the pool has no payout signer")
+	}
+	if err := s.checkPending(); err != nil {
+		return nil, err
+	}
+
+	p, payments, err := ExecutePayout(s.Accountant.DB, id, admin, "submitted by the pool")
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := s.submit(ctx, payments)
+	if run != nil {
+		p.RunID = run.ID
+		if dbErr := s.Accountant.DB.Model(p).Update("run_id", run.ID).Error; dbErr != nil && err == nil {
+			err = dbErr
+		}
+	}
+	return run, err
+}
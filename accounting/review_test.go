@@ -0,0 +1,242 @@
+package accounting_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayoutProposal(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+	a.DB.AutoMigrate(&authentication.User{})
+
+	for _, u := range []string{"a@gmail.com", "b@gmail.com"} {
+		require.NoError(a.DB.Create(&authentication.User{UID: u, PayoutAddress: "FA-" + u}).Error)
+		require.NoError(a.DB.Create(&UserOwedPayouts{JobID: 1, UserID: u, Payout: 10e8}).Error)
+	}
+	_, err := RebuildUserBalances(a.DB)
+	require.NoError(err)
+
+	payments, err := a.CalculatePayments()
+	require.NoError(err)
+	require.Len(payments, 2)
+
+	p, err := ProposePayout(a.DB, "admin1", payments)
+	require.NoError(err)
+	require.Equal(ProposalProposed, p.State)
+	require.Equal(int64(20e8), p.Total)
+	digest, err := PaymentsDigest(payments)
+	require.NoError(err)
+	require.Equal(digest, p.Digest)
+
+	// Only one open proposal
+	_, err = ProposePayout(a.DB, "admin2", payments)
+	require.Error(err)
+
+	// Must be approved before executing, and by a different admin
+	_, _, err = ExecutePayout(a.DB, p.ID, "admin2", "")
+	require.Error(err)
+	_, err = ApprovePayout(a.DB, p.ID, "admin1")
+	require.Error(err)
+	p, err = ApprovePayout(a.DB, p.ID, "admin2")
+	require.NoError(err)
+	require.Equal("admin2", p.ApprovedBy)
+
+	_, paid, err := ExecutePayout(a.DB, p.ID, "admin1", "exported to sign")
+	require.NoError(err)
+	require.Equal(payments, paid)
+	_, _, err = ExecutePayout(a.DB, p.ID, "admin1", "exported to sign")
+	require.Error(err)
+
+	// The receipt must pay the executed proposal, in any order
+	receipt := []Paid{paid[1], paid[0]}
+	receipt[0].EntryHash, receipt[1].EntryHash = "e1", "e2"
+	matched, err := MatchProposal(a.DB, receipt)
+	require.NoError(err)
+	require.Equal(p.ID, matched.ID)
+	receipt[0].PaymentAmount++
+	_, err = MatchProposal(a.DB, receipt)
+	require.Error(err)
+	_, err = MatchProposal(a.DB, receipt[:1])
+	require.Error(err)
+
+	var actions []PayoutAction
+	require.NoError(a.DB.Where("proposal_id = ?", p.ID).Order("id asc").Find(&actions).Error)
+	require.Len(actions, 3)
+	require.Equal("propose", actions[0].Action)
+	require.Equal("approve", actions[1].Action)
+	require.Equal("execute", actions[2].Action)
+	require.Equal("admin1", actions[2].Admin)
+}
+
+func TestPayoutProposal_PartialReceipt(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+	a.DB.AutoMigrate(&authentication.User{})
+
+	for _, u := range []string{"a@gmail.com", "b@gmail.com", "c@gmail.com"} {
+		require.NoError(a.DB.Create(&authentication.User{UID: u, PayoutAddress: "FA-" + u}).Error)
+		require.NoError(a.DB.Create(&UserOwedPayouts{JobID: 1, UserID: u, Payout: 10e8}).Error)
+	}
+	_, err := RebuildUserBalances(a.DB)
+	require.NoError(err)
+
+	payments, err := a.CalculatePayments()
+	require.NoError(err)
+	require.Len(payments, 3)
+	p, err := ProposePayout(a.DB, "admin1", payments)
+	require.NoError(err)
+	_, err = ApprovePayout(a.DB, p.ID, "admin2")
+	require.NoError(err)
+	_, _, err = ExecutePayout(a.DB, p.ID, "admin1", "exported to sign")
+	require.NoError(err)
+
+	record := func(receipt []Paid, hash string) {
+		receipt = append([]Paid{}, receipt...)
+		for i := range receipt {
+			receipt[i].EntryHash = hash
+		}
+		matched, err := MatchProposal(a.DB, receipt)
+		require.NoError(err)
+		require.Equal(p.ID, matched.ID)
+		require.NoError(a.RecordProposalPayments(context.Background(), nil, p.ID, "cli", receipt))
+	}
+
+	// The first batch is recorded on its own
+	record(payments[:2], "e1")
+
+	// The same payments cannot be matched twice
+	again := append([]Paid{}, payments[1:]...)
+	again[0].EntryHash, again[1].EntryHash = "e2", "e2"
+	_, err = MatchProposal(a.DB, again)
+	require.Error(err)
+
+	// Nor can a payment the proposal does not have
+	foreign := []Paid{payments[2]}
+	foreign[0].PaymentAmount++
+	_, err = MatchProposal(a.DB, foreign)
+	require.Error(err)
+
+	// A receipt that cannot be marked on the proposal records no payments
+	require.NoError(a.DB.Create(&ProposalReceipt{ProposalID: p.ID + 1, EntryHash: "e3"}).Error)
+	clash := append([]Paid{}, payments[2:]...)
+	clash[0].EntryHash = "e3"
+	require.Error(a.RecordProposalPayments(context.Background(), nil, p.ID, "cli", clash))
+	var paid int
+	require.NoError(a.DB.Model(&Paid{}).Where("entry_hash = ?", "e3").Count(&paid).Error)
+	require.Zero(paid)
+
+	record(payments[2:], "e2")
+	_, err = MatchProposal(a.DB, payments[2:])
+	require.Error(err)
+
+	var actions int
+	require.NoError(a.DB.Model(&PayoutAction{}).Where("proposal_id = ? AND action = ?", p.ID, "record").Count(&actions).Error)
+	require.Equal(2, actions)
+
+	var receipts int
+	require.NoError(a.DB.Model(&ProposalReceipt{}).Where("proposal_id = ?", p.ID).Count(&receipts).Error)
+	require.Equal(2, receipts)
+}
+
+func TestPayoutProposal_Outstanding(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+	a.DB.AutoMigrate(&authentication.User{})
+
+	require.NoError(a.DB.Create(&authentication.User{UID: "a@gmail.com", PayoutAddress: "FA-a"}).Error)
+	require.NoError(a.DB.Create(&UserOwedPayouts{JobID: 1, UserID: "a@gmail.com", Payout: 10e8}).Error)
+	_, err := RebuildUserBalances(a.DB)
+	require.NoError(err)
+
+	payments, err := a.CalculatePayments()
+	require.NoError(err)
+	p, err := ProposePayout(a.DB, "admin1", payments)
+	require.NoError(err)
+
+	// Paid since the proposal
+	for i := range payments {
+		payments[i].EntryHash = "b0b1a2d5d4c3e2f1"
+	}
+	require.NoError(a.WritePayments(payments))
+
+	_, err = ApprovePayout(a.DB, p.ID, "admin2")
+	require.Error(err)
+
+	p, err = RejectPayout(a.DB, p.ID, "admin2", "already paid")
+	require.NoError(err)
+	require.Equal(ProposalRejected, p.State)
+	_, err = ApprovePayout(a.DB, p.ID, "admin2")
+	require.Error(err)
+}
+
+func TestPayoutScheduler_SubmitProposal(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+	a.DB.AutoMigrate(&authentication.User{})
+
+	require.NoError(a.DB.Create(&authentication.User{UID: "a@gmail.com", PayoutAddress: "FA-a"}).Error)
+	require.NoError(a.DB.Create(&UserOwedPayouts{JobID: 1, UserID: "a@gmail.com", Payout: 10e8}).Error)
+	_, err := RebuildUserBalances(a.DB)
+	require.NoError(err)
+
+	signer := new(testSigner)
+	s := &PayoutScheduler{Accountant: a, Signer: signer, Interval: time.Hour, RequireApproval: true}
+	require.False(s.Scheduled())
+
+	payments, err := a.CalculatePayments()
+	require.NoError(err)
+	p, err := ProposePayout(a.DB, "admin1", payments)
+	require.NoError(err)
+
+	_, err = s.SubmitProposal(context.Background(), p.ID, "admin1")
+	require.Error(err)
+	_, err = ApprovePayout(a.DB, p.ID, "admin2")
+	require.NoError(err)
+
+	run, err := s.SubmitProposal(context.Background(), p.ID, "admin1")
+	require.NoError(err)
+	require.Equal(RunSubmitted, run.State)
+	require.Equal(payments, signer.payments)
+
+	var executed PayoutProposal
+	require.NoError(a.DB.First(&executed, p.ID).Error)
+	require.Equal(ProposalExecuted, executed.State)
+	require.Equal(run.ID, executed.RunID)
+}
+
+func TestReviewPayments(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+
+	require.NoError(a.DB.Create(&Paid{EntryHash: "a", UserID: "a@gmail.com", PayoutAddress: "FA-a", PaymentAmount: 10e8}).Error)
+	require.NoError(a.DB.Create(&Paid{EntryHash: "b", UserID: "a@gmail.com", PayoutAddress: "FA-a", PaymentAmount: 20e8}).Error)
+
+	anomalies, err := ReviewPayments(a.DB, []Paid{
+		{UserID: "a@gmail.com", PayoutAddress: "FA-a", PaymentAmount: 50e8},
+		{UserID: "b@gmail.com", PayoutAddress: "FA-b", PaymentAmount: 10e8},
+	}, 5)
+	require.NoError(err)
+	require.Len(anomalies, 1)
+	require.Equal("b@gmail.com", anomalies[0].UserID)
+	require.Equal("new address", anomalies[0].Reason)
+
+	// a's average is 15
+	anomalies, err = ReviewPayments(a.DB, []Paid{
+		{UserID: "a@gmail.com", PayoutAddress: "FA-c", PaymentAmount: 80e8},
+	}, 5)
+	require.NoError(err)
+	require.Len(anomalies, 2)
+	require.Equal("new address", anomalies[0].Reason)
+	require.Contains(anomalies[1].Reason, "5x")
+}
@@ -0,0 +1,544 @@
+package accounting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/treasury"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	schedLog = log.WithField("mod", "payout")
+)
+
+// PayoutRun states
+const (
+	// RunBuilt means the payments are calculated, but not yet signed
+	RunBuilt = "built"
+	// RunSubmitted means the payments were signed and submitted to the network
+	RunSubmitted = "submitted"
+	// RunConfirmed means the payments are recorded as paid
+	RunConfirmed = "confirmed"
+	// RunFailed means the payments were never submitted, or were rejected
+	RunFailed = "failed"
+)
+
+// PayoutRun tracks a scheduled payout through it's states. Only 1 run can
+// be in progress at a time, as the payments are not recorded until they are
+// confirmed.
+type PayoutRun struct {
+	gorm.Model
+	State string `gorm:"index:run_state"`
+	// EntryHash is the first entry of the run. The payments have the
+	// entry of their batch once submitted.
+	EntryHash string `gorm:"index:run_entry_hash"`
+	Error     string
+
+	Count int
+	Total int64 // In PEG
+	// Payments is the json of the payments in the run, and the receipt
+	// once submitted.
+	Payments string `gorm:"type:text"`
+}
+
+// GetPayments returns the payments of the run
+func (r PayoutRun) GetPayments() ([]Paid, error) {
+	var payments []Paid
+	err := json.Unmarshal([]byte(r.Payments), &payments)
+	return payments, err
+}
+
+// addError adds the error to the notes of the run
+func (r *PayoutRun) addError(msg string) {
+	if r.Error != "" {
+		r.Error += "; "
+	}
+	r.Error += msg
+}
+
+// ErrPayoutFailed is returned by a confirmer when a submitted payout will
+// never be applied, such as a rejected transaction.
+var ErrPayoutFailed = errors.New("payout transaction failed")
+
+// PayoutSigner signs the payments and submits them to the network. It
+// returns the receipt, which is the payments with the entryhash of their
+// batch. If only some batches are submitted, the receipt has just their
+// payments along with the error.
+type PayoutSigner interface {
+	Submit(ctx context.Context, payments []Paid) ([]Paid, error)
+}
+
+// RecordingSigner is a signer that hands over the payments of each batch, with
+// it's entryhash, before the batch is submitted. A batch whose submit fails
+// may still land on chain, so it is kept on the run to be confirmed.
+type RecordingSigner interface {
+	SubmitRecorded(ctx context.Context, payments []Paid, record func(batch []Paid) error) ([]Paid, error)
+}
+
+// BalanceChecker is a signer that can check the PEG balance it pays from.
+// Each run is checked before it is submitted, and a run the balance cannot
+// cover is failed.
+type BalanceChecker interface {
+	CheckBalance(ctx context.Context, total int64) (int64, error)
+}
+
+// PayoutConfirmer reports if a submitted payout was applied on chain.
+type PayoutConfirmer interface {
+	Confirmed(ctx context.Context, entryhash string) (bool, error)
+}
+
+// PayoutScheduler builds the payouts on a schedule, and hands them to the
+// signer. If there is no confirmer, submitted runs are confirmed when the
+// receipt is recorded with RecordRun.
+type PayoutScheduler struct {
+	Accountant *Accountant
+	Signer     PayoutSigner
+	Confirmer  PayoutConfirmer
+	// Verifier checks the confirmed payments on chain before they are
+	// recorded, if set.
+	Verifier ReceiptVerifier
+
+	// Interval is how often to payout. Runs are aligned to the interval
+	// in UTC, so 24hrs is daily at 00:00 UTC. The offset shifts the runs
+	// from that alignment.
+	Interval time.Duration
+	Offset   time.Duration
+	// ConfirmPoll is how often submitted runs are checked
+	ConfirmPoll time.Duration
+	// RequireApproval means payouts are only submitted once approved by two
+	// admins, so no runs are built on the interval.
+	RequireApproval bool
+}
+
+func NewPayoutScheduler(conf *viper.Viper, a *Accountant, signer PayoutSigner) *PayoutScheduler {
+	s := new(PayoutScheduler)
+	s.Accountant = a
+	s.Signer = signer
+	s.Interval = conf.GetDuration(config.ConfigPayoutInterval)
+	s.Offset = conf.GetDuration(config.ConfigPayoutOffset)
+	s.ConfirmPoll = time.Minute
+	s.RequireApproval = conf.GetBool(config.ConfigPayoutRequireApproval)
+	return s
+}
+
+func (s *PayoutScheduler) SetConfirmer(c PayoutConfirmer) {
+	s.Confirmer = c
+}
+
+func (s *PayoutScheduler) SetVerifier(v ReceiptVerifier) {
+	s.Verifier = v
+}
+
+// NextRun returns the next scheduled run after the given time
+func (s *PayoutScheduler) NextRun(now time.Time) time.Time {
+	next := now.UTC().Truncate(s.Interval).Add(s.Offset)
+	for !next.After(now) {
+		next = next.Add(s.Interval)
+	}
+	return next
+}
+
+// Scheduled is true if runs are built on the interval
+func (s *PayoutScheduler) Scheduled() bool {
+	return s.Interval > 0 && !s.RequireApproval
+}
+
+func (s *PayoutScheduler) Run(ctx context.Context) {
+	if !s.Scheduled() && !s.RequireApproval {
+		return
+	}
+
+	var next time.Time
+	if s.Scheduled() {
+		next = s.NextRun(time.Now())
+		schedLog.WithField("next", next).Infof("payouts scheduled")
+	} else {
+		schedLog.Infof("payouts require approval")
+	}
+	poll := time.NewTicker(s.ConfirmPoll)
+	defer poll.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			if _, err := s.ConfirmRuns(ctx); err != nil {
+				schedLog.WithError(err).Error("failed to confirm payout runs")
+			}
+			if s.Scheduled() && !time.Now().Before(next) {
+				if _, err := s.Payout(ctx); err != nil {
+					schedLog.WithError(err).Error("scheduled payout failed")
+				}
+				next = s.NextRun(time.Now())
+				schedLog.WithField("next", next).Infof("next payout scheduled")
+			}
+		}
+	}
+}
+
+// Payout builds a new run and submits it. If a run is still in progress,
+// no new run is made.
+func (s *PayoutScheduler) Payout(ctx context.Context) (*PayoutRun, error) {
+	if err := s.checkPending(); err != nil {
+		return nil, err
+	}
+
+	payments, err := s.Accountant.CalculatePayments()
+	if err != nil {
+		return nil, err
+	}
+	if len(payments) == 0 {
+		schedLog.Info("nothing to payout")
+		return nil, nil
+	}
+
+	return s.submit(ctx, payments)
+}
+
+// checkPending returns an error if a run is still in progress
+func (s *PayoutScheduler) checkPending() error {
+	var pending PayoutRun
+	err := s.Accountant.DB.Where("state IN (?)", []string{RunBuilt, RunSubmitted}).First(&pending).Error
+	if err == nil {
+		return fmt.Errorf("payout run %d is still %s", pending.ID, pending.State)
+	} else if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	return nil
+}
+
+func (s *PayoutScheduler) submit(ctx context.Context, payments []Paid) (*PayoutRun, error) {
+	run, err := s.build(payments)
+	if err != nil {
+		return nil, err
+	}
+
+	rLog := schedLog.WithFields(log.Fields{"run": run.ID, "count": run.Count, "peg": run.Total / 1e8})
+	if c, ok := s.Signer.(BalanceChecker); ok {
+		balance, err := c.CheckBalance(ctx, run.Total)
+		if err == treasury.ErrInsufficientBalance {
+			run.State = RunFailed
+			run.Error = fmt.Sprintf("the source only holds %d PEG, but the payout needs %d PEG", balance/1e8, run.Total/1e8)
+			rLog.Error("payout refused, " + run.Error)
+			return run, s.Accountant.DB.Save(run).Error
+		} else if err != nil {
+			// Still submitted, as pegnet rejects a payout the source
+			// cannot cover. The run notes the balance was not checked.
+			rLog.WithError(err).Warn("unable to check the treasury balance, the payout is submitted unchecked")
+			run.addError(fmt.Sprintf("treasury balance not checked: %s", err.Error()))
+		}
+	}
+
+	var receipt []Paid
+	if r, ok := s.Signer.(RecordingSigner); ok {
+		var recorded []Paid
+		receipt, err = r.SubmitRecorded(ctx, payments, func(batch []Paid) error {
+			recorded = append(recorded, batch...)
+			return s.saveSubmitted(run, recorded)
+		})
+		if err != nil {
+			// A recorded batch that failed to submit may still be on
+			// chain, so it is left to the confirmer
+			receipt = recorded
+		}
+	} else {
+		receipt, err = s.Signer.Submit(ctx, payments)
+	}
+	if err != nil {
+		rLog.WithError(err).Error("payout failed to submit")
+		run.addError(err.Error())
+		if len(receipt) == 0 {
+			run.State = RunFailed
+			return run, s.Accountant.DB.Save(run).Error
+		}
+		// Some batches were submitted, so they must still be confirmed.
+		// The rest of the balances are paid in the next run.
+	}
+
+	if err := s.saveSubmitted(run, receipt); err != nil {
+		return run, err
+	}
+	rLog.WithField("entryhash", run.EntryHash).Info("payout submitted")
+	return run, nil
+}
+
+// saveSubmitted saves the run as submitted, with the receipt as it's payments
+func (s *PayoutScheduler) saveSubmitted(run *PayoutRun, receipt []Paid) error {
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	run.State, run.EntryHash, run.Payments = RunSubmitted, receipt[0].EntryHash, string(data)
+	run.Count, run.Total = len(receipt), 0
+	for _, p := range receipt {
+		run.Total += p.PaymentAmount
+	}
+	return s.Accountant.DB.Save(run).Error
+}
+
+func (s *PayoutScheduler) build(payments []Paid) (*PayoutRun, error) {
+	data, err := json.Marshal(payments)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &PayoutRun{
+		State:    RunBuilt,
+		Count:    len(payments),
+		Payments: string(data),
+	}
+	for _, p := range payments {
+		run.Total += p.PaymentAmount
+	}
+
+	return run, s.Accountant.DB.Create(run).Error
+}
+
+// ConfirmRuns records the payments of any submitted runs that are now
+// confirmed, and returns the number of runs confirmed. A run is resolved once
+// every batch is confirmed or failed. The payments of confirmed batches are
+// recorded, and a run with any failed batch is marked as failed.
+func (s *PayoutScheduler) ConfirmRuns(ctx context.Context) (int, error) {
+	if s.Confirmer == nil {
+		return 0, nil // Confirmed by recording the receipt
+	}
+
+	var runs []PayoutRun
+	if err := s.Accountant.DB.Where("state = ?", RunSubmitted).Find(&runs).Error; err != nil {
+		return 0, err
+	}
+
+	var confirmed int
+	for _, run := range runs {
+		payments, err := run.GetPayments()
+		if err != nil {
+			return confirmed, err
+		}
+
+		ok, failed, err := s.resolveBatches(ctx, payments)
+		if err != nil {
+			return confirmed, err
+		}
+		if !ok {
+			continue // Still waiting on a batch
+		}
+
+		var paid []Paid
+		for _, p := range payments {
+			if !failed[p.EntryHash] {
+				paid = append(paid, p)
+			}
+		}
+
+		// Writing the payments marks the run as confirmed
+		if len(paid) > 0 {
+			if s.Verifier != nil {
+				err = s.Accountant.RecordPayments(ctx, s.Verifier, paid)
+			} else {
+				err = s.Accountant.WritePayments(paid)
+			}
+			if err != nil {
+				return confirmed, err
+			}
+		}
+
+		if len(failed) > 0 {
+			// Nothing was paid by the failed batches, so the balances will
+			// be paid in the next run
+			schedLog.WithFields(log.Fields{"run": run.ID, "failed": len(failed)}).Error("payout failed on chain")
+			run.State, run.Error = RunFailed, fmt.Sprintf("%d batches failed: %s", len(failed), ErrPayoutFailed.Error())
+			if err := s.Accountant.DB.Model(&run).Updates(PayoutRun{State: run.State, Error: run.Error}).Error; err != nil {
+				return confirmed, err
+			}
+			continue
+		}
+		confirmed++
+	}
+	return confirmed, nil
+}
+
+// RecordRun records the receipt of a submitted run. Runs with a confirmer
+// are recorded once confirmed, so this is for runs that are not, like those
+// signed by a command.
+func (a *Accountant) RecordRun(ctx context.Context, v ReceiptVerifier, id uint) (*PayoutRun, error) {
+	run, err := a.pendingRun(id)
+	if err != nil {
+		return nil, err
+	}
+	if run.State != RunSubmitted {
+		return nil, fmt.Errorf("run %d is %s, it has no receipt", id, run.State)
+	}
+
+	payments, err := run.GetPayments()
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		err = a.RecordPayments(ctx, v, payments)
+	} else {
+		err = a.WritePayments(payments)
+	}
+	if err != nil {
+		return nil, err
+	}
+	run.State = RunConfirmed
+	return run, nil
+}
+
+// FailRun marks a built or submitted run as failed, so the balances are paid
+// in the next run. Any batch of a submitted run found on chain by the
+// verifier is recorded first. If every batch is on chain, the run must be
+// recorded instead.
+func (a *Accountant) FailRun(ctx context.Context, v ReceiptVerifier, id uint, reason string) (*PayoutRun, error) {
+	run, err := a.pendingRun(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if run.State == RunSubmitted && v != nil {
+		payments, err := run.GetPayments()
+		if err != nil {
+			return nil, err
+		}
+
+		batches := make(map[string][]Paid)
+		var hashes []string
+		for _, p := range payments {
+			if _, ok := batches[p.EntryHash]; !ok {
+				hashes = append(hashes, p.EntryHash)
+			}
+			batches[p.EntryHash] = append(batches[p.EntryHash], p)
+		}
+
+		var paid []Paid
+		for _, hash := range hashes {
+			if v.VerifyReceipt(ctx, batches[hash]) == nil {
+				paid = append(paid, batches[hash]...)
+			}
+		}
+		if len(paid) == len(payments) {
+			return nil, fmt.Errorf("run %d is on chain, record it instead", id)
+		}
+		if len(paid) > 0 {
+			if err := a.WritePayments(paid); err != nil {
+				return nil, err
+			}
+			schedLog.WithFields(log.Fields{"run": run.ID, "count": len(paid)}).Info("payments of the failed run recorded")
+		}
+	}
+
+	run.State, run.Error = RunFailed, reason
+	return run, a.DB.Model(run).Updates(PayoutRun{State: run.State, Error: run.Error}).Error
+}
+
+// pendingRun returns the run if it is still built or submitted
+func (a *Accountant) pendingRun(id uint) (*PayoutRun, error) {
+	var run PayoutRun
+	if err := a.DB.First(&run, id).Error; err != nil {
+		return nil, fmt.Errorf("run %d: %s", id, err.Error())
+	}
+	if run.State != RunBuilt && run.State != RunSubmitted {
+		return nil, fmt.Errorf("run %d is already %s", id, run.State)
+	}
+	return &run, nil
+}
+
+// resolveBatches checks each batch of the payments with the confirmer. It
+// returns if every batch is resolved, and the failed batches.
+func (s *PayoutScheduler) resolveBatches(ctx context.Context, payments []Paid) (bool, map[string]bool, error) {
+	failed := make(map[string]bool)
+	checked := make(map[string]bool)
+	for _, p := range payments {
+		if checked[p.EntryHash] {
+			continue
+		}
+		checked[p.EntryHash] = true
+
+		ok, err := s.Confirmer.Confirmed(ctx, p.EntryHash)
+		if err == ErrPayoutFailed {
+			failed[p.EntryHash] = true
+			continue
+		}
+		if err != nil {
+			return false, nil, err
+		}
+		if !ok {
+			return false, nil, nil
+		}
+	}
+	return true, failed, nil
+}
+
+// ExecSigner hands the payments to an external command, like the payout-cli.
+// The payments json path replaces '{payments}' in the command, and the
+// receipt path replaces '{receipt}'. The command must write the receipt. If
+// the command fails after submitting some batches, the receipt must have
+// their payments.
+type ExecSigner struct {
+	Command string
+}
+
+func (e ExecSigner) Submit(ctx context.Context, payments []Paid) ([]Paid, error) {
+	dir, err := ioutil.TempDir("", "prosper-payout")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	paymentsPath := filepath.Join(dir, "payments.json")
+	receiptPath := filepath.Join(dir, "receipt.json")
+	data, err := json.Marshal(payments)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(paymentsPath, data, 0600); err != nil {
+		return nil, err
+	}
+
+	args := strings.Fields(e.Command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no signer command")
+	}
+	for i := range args {
+		args[i] = strings.Replace(args[i], "{payments}", paymentsPath, -1)
+		args[i] = strings.Replace(args[i], "{receipt}", receiptPath, -1)
+	}
+
+	out, cmdErr := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	data, err = ioutil.ReadFile(receiptPath)
+	if cmdErr != nil && (err != nil || len(data) == 0) {
+		return nil, fmt.Errorf("%s: %s", cmdErr.Error(), string(out))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no receipt: %s", err.Error())
+	}
+
+	var receipt []Paid
+	if err := json.Unmarshal(data, &receipt); err != nil {
+		return nil, err
+	}
+	if len(receipt) == 0 || len(receipt) > len(payments) {
+		return nil, fmt.Errorf("receipt does not match the payments")
+	}
+	for _, p := range receipt {
+		if p.EntryHash == "" {
+			return nil, fmt.Errorf("receipt is missing an entryhash")
+		}
+	}
+	if cmdErr != nil || len(receipt) < len(payments) {
+		return receipt, fmt.Errorf("only %d of %d payments were submitted: %s", len(receipt), len(payments), string(out))
+	}
+	return receipt, nil
+}
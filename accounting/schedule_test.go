@@ -0,0 +1,242 @@
+package accounting_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayoutScheduler_NextRun(t *testing.T) {
+	require := require.New(t)
+	s := &PayoutScheduler{Interval: time.Hour * 24}
+
+	now := time.Date(2020, 1, 1, 13, 0, 0, 0, time.UTC)
+	require.Equal(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), s.NextRun(now))
+
+	s.Offset = time.Hour * 6
+	require.Equal(time.Date(2020, 1, 2, 6, 0, 0, 0, time.UTC), s.NextRun(now))
+	s.Offset = time.Hour * 14
+	require.Equal(time.Date(2020, 1, 1, 14, 0, 0, 0, time.UTC), s.NextRun(now))
+}
+
+func TestPayoutScheduler_Payout(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+	a.DB.AutoMigrate(&authentication.User{})
+
+	a.MinimumPayout = 10e8
+	for _, u := range []string{"a@gmail.com", "b@gmail.com", "c@gmail.com"} {
+		require.NoError(a.DB.Create(&authentication.User{UID: u, PayoutAddress: "FA-" + u}).Error)
+	}
+	require.NoError(a.DB.Create(&UserOwedPayouts{JobID: 1, UserID: "a@gmail.com", Payout: 20e8}).Error)
+	require.NoError(a.DB.Create(&UserOwedPayouts{JobID: 1, UserID: "b@gmail.com", Payout: 5e8}).Error)
+	require.NoError(a.DB.Create(&UserOwedPayouts{JobID: 1, UserID: "c@gmail.com", Payout: 5e8}).Error)
+	// c has a lower minimum than the pool
+	require.NoError(SetUserPayoutThreshold(a.DB, "c@gmail.com", 1e8))
+	require.Error(SetUserPayoutThreshold(a.DB, "unknown@gmail.com", 1e8))
+	_, err := RebuildUserBalances(a.DB)
+	require.NoError(err)
+
+	signer := new(testSigner)
+	s := &PayoutScheduler{Accountant: a, Signer: signer, Interval: time.Hour}
+	run, err := s.Payout(context.Background())
+	require.NoError(err)
+	require.Equal(RunSubmitted, run.State)
+	require.Equal(2, run.Count)
+	require.Equal(int64(25e8), run.Total)
+	require.Len(signer.payments, 2)
+
+	// A second run must wait for the first to be confirmed
+	_, err = s.Payout(context.Background())
+	require.Error(err)
+
+	for i := range signer.payments {
+		signer.payments[i].EntryHash = run.EntryHash
+	}
+	require.NoError(a.WritePayments(signer.payments))
+
+	var confirmed PayoutRun
+	require.NoError(a.DB.First(&confirmed, run.ID).Error)
+	require.Equal(RunConfirmed, confirmed.State)
+
+	// Only b is left, and under the minimum
+	run, err = s.Payout(context.Background())
+	require.NoError(err)
+	require.Nil(run)
+}
+
+func TestPayoutScheduler_Batches(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+	a.DB.AutoMigrate(&authentication.User{})
+
+	for _, u := range []string{"a@gmail.com", "b@gmail.com", "c@gmail.com"} {
+		require.NoError(a.DB.Create(&authentication.User{UID: u, PayoutAddress: "FA-" + u}).Error)
+		require.NoError(a.DB.Create(&UserOwedPayouts{JobID: 1, UserID: u, Payout: 10e8}).Error)
+	}
+	_, err := RebuildUserBalances(a.DB)
+	require.NoError(err)
+
+	// Each user is in their own batch
+	signer := &batchSigner{}
+	confirmer := &testConfirmer{status: make(map[string]error)}
+	s := &PayoutScheduler{Accountant: a, Signer: signer, Confirmer: confirmer, Interval: time.Hour}
+	run, err := s.Payout(context.Background())
+	require.NoError(err)
+	require.Equal(RunSubmitted, run.State)
+	require.Equal("batch-0", run.EntryHash)
+
+	// Waits for every batch
+	confirmer.status["batch-0"] = nil
+	n, err := s.ConfirmRuns(context.Background())
+	require.NoError(err)
+	require.Zero(n)
+
+	// The failed batch is not paid
+	confirmer.status["batch-1"] = ErrPayoutFailed
+	confirmer.status["batch-2"] = nil
+	n, err = s.ConfirmRuns(context.Background())
+	require.NoError(err)
+	require.Zero(n)
+	require.NoError(a.DB.First(run, run.ID).Error)
+	require.Equal(RunFailed, run.State)
+
+	balances, err := UserBalances(a.DB)
+	require.NoError(err)
+	require.Equal(int64(0), balances["a@gmail.com"].Outstanding)
+	require.Equal(int64(10e8), balances["b@gmail.com"].Outstanding)
+	require.Equal(int64(0), balances["c@gmail.com"].Outstanding)
+
+	// A receipt with an entry already recorded is refused
+	require.Error(a.WritePayments([]Paid{
+		{UserID: "b@gmail.com", EntryHash: "batch-3", PaymentAmount: 10e8},
+		{UserID: "c@gmail.com", EntryHash: "batch-2", PaymentAmount: 10e8},
+	}))
+}
+
+func TestAccountant_RecordAndFailRuns(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+	a.DB.AutoMigrate(&authentication.User{})
+
+	users := []string{"a@gmail.com", "b@gmail.com", "c@gmail.com"}
+	for _, u := range users {
+		require.NoError(a.DB.Create(&authentication.User{UID: u, PayoutAddress: "FA-" + u}).Error)
+		require.NoError(a.DB.Create(&UserOwedPayouts{JobID: 1, UserID: u, Payout: 10e8}).Error)
+	}
+	_, err := RebuildUserBalances(a.DB)
+	require.NoError(err)
+
+	// Signed by a command, so nothing confirms the run
+	s := &PayoutScheduler{Accountant: a, Signer: batchSigner{}, Interval: time.Hour}
+	run, err := s.Payout(context.Background())
+	require.NoError(err)
+	n, err := s.ConfirmRuns(context.Background())
+	require.NoError(err)
+	require.Zero(n)
+
+	// Only a batch on chain is recorded, the rest are paid in the next run
+	verifier := testVerifier{"batch-0": true, "batch-1": true, "batch-2": true}
+	_, err = a.FailRun(context.Background(), verifier, run.ID, "stuck")
+	require.Error(err, "every batch is on chain")
+	delete(verifier, "batch-1")
+	delete(verifier, "batch-2")
+	failed, err := a.FailRun(context.Background(), verifier, run.ID, "stuck")
+	require.NoError(err)
+	require.Equal(RunFailed, failed.State)
+	_, err = a.FailRun(context.Background(), verifier, run.ID, "stuck")
+	require.Error(err, "already failed")
+	_, err = a.RecordRun(context.Background(), verifier, run.ID)
+	require.Error(err, "already failed")
+
+	balances, err := UserBalances(a.DB)
+	require.NoError(err)
+	require.Zero(balances["a@gmail.com"].Outstanding)
+	require.Equal(int64(10e8), balances["b@gmail.com"].Outstanding)
+
+	// The next run's receipt is recorded once verified
+	s.Signer = batchSigner{prefix: "next-"}
+	run, err = s.Payout(context.Background())
+	require.NoError(err)
+	require.Equal(2, run.Count)
+	_, err = a.RecordRun(context.Background(), testVerifier{}, run.ID)
+	require.Error(err)
+	recorded, err := a.RecordRun(context.Background(), testVerifier{"next-batch-0": true, "next-batch-1": true}, run.ID)
+	require.NoError(err)
+	require.Equal(RunConfirmed, recorded.State)
+	require.NoError(a.DB.First(run, run.ID).Error)
+	require.Equal(RunConfirmed, run.State)
+
+	balances, err = UserBalances(a.DB)
+	require.NoError(err)
+	for _, u := range users {
+		require.Zero(balances[u].Outstanding)
+	}
+
+	// A built run was never signed, so it can only be failed
+	require.NoError(a.DB.Create(&PayoutRun{State: RunBuilt}).Error)
+	var built PayoutRun
+	require.NoError(a.DB.Where("state = ?", RunBuilt).First(&built).Error)
+	_, err = a.RecordRun(context.Background(), nil, built.ID)
+	require.Error(err)
+	_, err = a.FailRun(context.Background(), nil, built.ID, "crashed")
+	require.NoError(err)
+}
+
+type testSigner struct {
+	payments []Paid
+}
+
+func (s *testSigner) Submit(ctx context.Context, payments []Paid) ([]Paid, error) {
+	s.payments = payments
+	receipt := make([]Paid, len(payments))
+	for i, p := range payments {
+		p.EntryHash = "b0b1a2d5d4c3e2f1"
+		receipt[i] = p
+	}
+	return receipt, nil
+}
+
+// batchSigner puts each payment in its own entry
+type batchSigner struct {
+	prefix string
+}
+
+func (s batchSigner) Submit(ctx context.Context, payments []Paid) ([]Paid, error) {
+	receipt := make([]Paid, len(payments))
+	for i, p := range payments {
+		p.EntryHash = fmt.Sprintf("%sbatch-%d", s.prefix, i)
+		receipt[i] = p
+	}
+	return receipt, nil
+}
+
+// testConfirmer confirms the entries with a nil status
+type testConfirmer struct {
+	status map[string]error
+}
+
+func (c *testConfirmer) Confirmed(ctx context.Context, entryhash string) (bool, error) {
+	err, ok := c.status[entryhash]
+	return ok && err == nil, err
+}
+
+// testVerifier verifies the entries that are on chain
+type testVerifier map[string]bool
+
+func (v testVerifier) VerifyReceipt(ctx context.Context, payments []Paid) error {
+	for _, p := range payments {
+		if !v[p.EntryHash] {
+			return fmt.Errorf("%s is not on chain", p.EntryHash)
+		}
+	}
+	return nil
+}
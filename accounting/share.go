@@ -15,55 +15,82 @@ type OwedPayouts struct {
 	// PoolFeeRate is the pool cut
 	PoolFeeRate decimal.Decimal `sql:"type:decimal(20,8);" json:"poolfeerate"`
 	PoolFee     int64           `json:"poolfee"` // In PEG
-	// Dust should always be 0, but it is any rewards that are not accounted
-	// to a user or to the pool. We should account for it if it happens.
-	Dust int64 `json:"dust"`
+	// Dust is any rewards that are not accounted to a user or to the pool
+	// due to rounding. It is carried into the next payout, and is the
+	// CarriedDust of that payout.
+	Dust        int64 `json:"dust"`
+	CarriedDust int64 `gorm:"default:0" json:"carrieddust"`
 
 	PoolDifficuty float64 `json:"pooldifficulty"`
+	// PoolScore is the total weight of the shares, by the scoring. It is
+	// the pool difficulty if scoring by difficulty.
+	PoolScore     float64 `gorm:"default:0" json:"poolscore"`
+	Scoring       string  `gorm:"default:'difficulty'" json:"scoring"`
 	PDiff         string  `gorm:"default:'ffff000000000000'" json:"pdiff"` // String to avoid sql uint64 errors
 	TotalHashrate float64 `gorm:"default:0" json:"totalhashrate"`
 
 	UserPayouts []UserOwedPayouts `gorm:"foreignkey:JobID" json:"userpayouts,omitempty"`
+	// ReferralPayouts are paid from the pool fee
+	ReferralPayouts []ReferralOwedPayouts `gorm:"foreignkey:JobID" json:"referralpayouts,omitempty"`
+	// FinderPayouts are paid from the pool fee
+	FinderPayouts []FinderOwedPayouts `gorm:"foreignkey:JobID" json:"finderpayouts,omitempty"`
 }
 
 func NewPayout(r Reward, poolFeeRate decimal.Decimal, work ShareMap) *OwedPayouts {
+	return NewCarriedPayout(r, 0, poolFeeRate, work)
+}
+
+// NewCarriedPayout is a payout that also distributes the dust carried from
+// the last payout. This ensures no rewards are lost to rounding over time.
+func NewCarriedPayout(r Reward, carried int64, poolFeeRate decimal.Decimal, work ShareMap) *OwedPayouts {
 	p := new(OwedPayouts)
 	p.PoolFeeRate = poolFeeRate
 	p.Reward = r
+	p.CarriedDust = carried
 	p.PDiff = fmt.Sprintf("%x", difficulty.PDiff)
-	remaining := p.TakePoolCut(p.Reward.PoolReward)
+	remaining := p.TakePoolCut(p.Distributable())
 	p.Payouts(work, remaining)
 
 	return p
 }
 
+// Distributable is the total amount to split between the pool and users
+func (p *OwedPayouts) Distributable() int64 {
+	return p.Reward.PoolReward + p.CarriedDust
+}
+
 func (p *OwedPayouts) Payouts(work ShareMap, remaining int64) {
 	p.PoolDifficuty = work.TotalDiff
+	p.PoolScore = work.TotalScore
+	p.Scoring = work.Scoring
 	var totalPayout int64
-	for user, work := range work.Sums {
-		prop := decimal.NewFromFloat(work.TotalDifficulty).Div(decimal.NewFromFloat(p.PoolDifficuty))
-		prop = prop.Truncate(AccountingPrecision)
+	for user, sum := range work.Sums {
+		prop := work.Proportion(*sum)
 
 		// Last hashrate is the best guess
-		hashrate := work.LastHashrate()
-		if work.TotalShares < 5 {
+		hashrate := sum.LastHashrate()
+		if sum.TotalShares < 5 {
 			// If there is too few shares, don't bother trying to calc a hashrate
 			hashrate = 0
 		}
 
 		pay := UserOwedPayouts{
 			UserID:           user,
-			UserDifficuty:    work.TotalDifficulty,
-			TotalSubmissions: work.TotalShares,
+			UserDifficuty:    sum.TotalDifficulty,
+			UserScore:        sum.TotalScore,
+			TotalSubmissions: sum.TotalShares,
 			Proportion:       prop,
 			Payout:           cut(remaining, prop),
+			FeeRate:          p.PoolFeeRate,
 			HashRate:         hashrate,
 		}
+		// The fee is whatever the user would have earned without a pool cut
+		pay.PoolFee = cut(p.Distributable(), prop) - pay.Payout
 		p.UserPayouts = append(p.UserPayouts, pay)
 		totalPayout += pay.Payout
 
 		// Only if a miner mines for at least 20s
-		if work.LastShare.Sub(work.FirstShare) > time.Second*20 {
+		if sum.LastShare.Sub(sum.FirstShare) > time.Second*20 {
 			p.TotalHashrate += pay.HashRate
 		}
 	}
@@ -81,6 +108,27 @@ func (p *OwedPayouts) TakePoolCut(remaining int64) int64 {
 	return remaining - p.PoolFee
 }
 
+// ApplyUserFeeRates recalculates the payouts of any users with their own fee
+// rate. The difference from their pool rate payout is moved in or out of the
+// pool fee, so the dust does not change.
+func (p *OwedPayouts) ApplyUserFeeRates(rates map[string]decimal.Decimal) {
+	for i := range p.UserPayouts {
+		pay := &p.UserPayouts[i]
+		rate, ok := rates[pay.UserID]
+		if !ok || rate.Equal(pay.FeeRate) {
+			continue
+		}
+
+		gross := cut(p.Distributable(), pay.Proportion)
+		fee := cut(gross, rate)
+		p.PoolFee += pay.Payout - (gross - fee)
+
+		pay.Payout = gross - fee
+		pay.PoolFee = fee
+		pay.FeeRate = rate
+	}
+}
+
 // cut returns the proportional amount in the total
 func cut(total int64, prop decimal.Decimal) int64 {
 	amt := decimal.New(total, 0)
@@ -89,15 +137,21 @@ func cut(total int64, prop decimal.Decimal) int64 {
 }
 
 type UserOwedPayouts struct {
-	JobID            int32  `gorm:"primary_key" json:"jobid"`
+	JobID            int32  `gorm:"primary_key;auto_increment:false" json:"jobid"`
 	UserID           string `gorm:"primary_key"`
 	UserDifficuty    float64
+	UserScore        float64 `gorm:"default:0"`
 	TotalSubmissions int
 
 	// Proportion denoted with 10000 being 100% and 1 being 0.01%
 	Proportion decimal.Decimal `sql:"type:decimal(20,8);"`
 	Payout     int64           // In PEG
 
+	// FeeRate is the fee rate applied to this user. It is the pool fee rate
+	// unless the user has their own.
+	FeeRate decimal.Decimal `sql:"type:decimal(20,8);"`
+	PoolFee int64           // In PEG, the fee taken from the user's share
+
 	HashRate float64 `gorm:"default:0"` // Hashrate in h/s
 }
 
@@ -107,6 +161,18 @@ type Reward struct {
 
 	Winning int `json:"winningoprs"` // Number of oprs in the winning set
 	Graded  int `json:"gradedoprs"`  // Number of oprs in the graded set
+
+	// BlockTime is the time of the graded block, if known
+	BlockTime time.Time `gorm:"-" json:"-"`
+}
+
+// At is the time the reward is paid at. The fee rates in effect at the time
+// of the block apply, even if the block is synced much later.
+func (r Reward) At() time.Time {
+	if r.BlockTime.IsZero() {
+		return time.Now()
+	}
+	return r.BlockTime
 }
 
 // Share is an accepted piece of work done by a miner.
@@ -123,17 +189,50 @@ type Share struct {
 	UserID string
 }
 
+const (
+	// ScoringDifficulty weighs every share by its difficulty
+	ScoringDifficulty = "difficulty"
+	// ScoringTimeDecay weighs every share by its difficulty times
+	// exp(t/lambda), where t is the time since the job started.
+	ScoringTimeDecay = "timedecay"
+)
+
+// ValidScoring returns an error if the scoring is unknown
+func ValidScoring(scoring string) error {
+	switch scoring {
+	case ScoringDifficulty, ScoringTimeDecay:
+		return nil
+	}
+	return fmt.Errorf("unknown scoring '%s', expected '%s' or '%s'", scoring, ScoringDifficulty, ScoringTimeDecay)
+}
+
 type ShareMap struct {
-	// Sealed means no new shares are accepted and we can garbage collect
+	// Sealed means no new shares are accepted
 	Sealed bool
-
-	TotalDiff float64
-	Sums      map[string]*ShareSum
+	// Written means the payout of the shares is in the database, so once
+	// sealed, the map can be garbage collected
+	Written bool
+
+	// Scoring is how the shares are weighed, and Start is when the job
+	// started.
+	Scoring string
+	Start   time.Time
+
+	TotalDiff  float64
+	TotalScore float64
+	Sums       map[string]*ShareSum
 }
 
 func NewShareMap() *ShareMap {
+	return NewScoredShareMap(ScoringDifficulty)
+}
+
+// NewScoredShareMap weighs the shares added by the scoring
+func NewScoredShareMap(scoring string) *ShareMap {
 	s := new(ShareMap)
 	s.Sums = make(map[string]*ShareSum)
+	s.Scoring = scoring
+	s.Start = time.Now()
 	return s
 }
 
@@ -146,11 +245,31 @@ func (m *ShareMap) AddShare(key string, s Share) {
 		return // Do nothing, it's already sealed
 	}
 
+	score := m.Score(s)
 	m.TotalDiff += s.Difficulty
+	m.TotalScore += score
 	if _, ok := m.Sums[key]; !ok {
 		m.Sums[key] = new(ShareSum)
 	}
 	m.Sums[key].AddShare(s)
+	m.Sums[key].TotalScore += score
+}
+
+// Score is the weight of the share if added now
+func (m *ShareMap) Score(s Share) float64 {
+	if m.Scoring == ScoringTimeDecay {
+		return s.Difficulty * difficulty.Score(time.Since(m.Start), 0)
+	}
+	return s.Difficulty
+}
+
+// Proportion is the sum's share of the total score
+func (m ShareMap) Proportion(sum ShareSum) decimal.Decimal {
+	if m.TotalScore <= 0 {
+		return decimal.Zero
+	}
+	prop := decimal.NewFromFloat(sum.TotalScore).Div(decimal.NewFromFloat(m.TotalScore))
+	return prop.Truncate(AccountingPrecision)
 }
 
 const (
@@ -160,6 +279,7 @@ const (
 // ShareSum is the sum of shares for a given job
 type ShareSum struct {
 	TotalDifficulty float64
+	TotalScore      float64
 	TotalShares     int
 
 	FirstShare time.Time
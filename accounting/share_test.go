@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/shopspring/decimal"
 
@@ -167,6 +168,73 @@ func TestNewPayout(t *testing.T) {
 	})
 }
 
+func TestNewCarriedPayout(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		carried := rand.Int63() % 1e8
+		pays := NewCarriedPayout(Reward{
+			JobID:      100,
+			PoolReward: rand.Int63() % (1e6 * 1e8), // 100K max PEG
+			Winning:    10,
+			Graded:     15,
+		}, carried, randomRate(),
+			*randomShareMap(100, rand.Int()%100))
+
+		var totalPay int64
+		for _, pay := range pays.UserPayouts {
+			totalPay += pay.Payout
+		}
+
+		if totalPay+pays.PoolFee+pays.Dust != pays.Reward.PoolReward+carried {
+			t.Errorf("exp payouts, fee and dust to add to %d, found %d", pays.Reward.PoolReward+carried, totalPay+pays.PoolFee+pays.Dust)
+		}
+	}
+}
+
+func TestOwedPayouts_ApplyUserFeeRates(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		users := rand.Int()%100 + 1
+		pays := NewPayout(Reward{
+			JobID:      100,
+			PoolReward: rand.Int63() % (1e6 * 1e8), // 100K max PEG
+			Winning:    10,
+			Graded:     15,
+		}, randomRate(),
+			*randomShareMap(100, users))
+		dust := pays.Dust
+
+		rates := make(map[string]decimal.Decimal)
+		for j, pay := range pays.UserPayouts {
+			switch j % 3 {
+			case 0:
+				rates[pay.UserID] = decimal.Zero
+			case 1:
+				rates[pay.UserID] = randomRate().Truncate(AccountingPrecision)
+			}
+		}
+		pays.ApplyUserFeeRates(rates)
+
+		var totalPay int64
+		for _, pay := range pays.UserPayouts {
+			totalPay += pay.Payout
+			if rate, ok := rates[pay.UserID]; ok {
+				if !pay.FeeRate.Equal(rate) {
+					t.Errorf("exp fee rate %s, found %s", rate, pay.FeeRate)
+				}
+				if rate.IsZero() && pay.PoolFee != 0 {
+					t.Errorf("exp no fee for a 0%% rate, found %d", pay.PoolFee)
+				}
+			}
+		}
+
+		if pays.Dust != dust {
+			t.Errorf("dust should not change, exp %d, found %d", dust, pays.Dust)
+		}
+		if totalPay+pays.PoolFee+pays.Dust != pays.Reward.PoolReward {
+			t.Errorf("exp payouts, fee and dust to add to %d, found %d", pays.Reward.PoolReward, totalPay+pays.PoolFee+pays.Dust)
+		}
+	}
+}
+
 func TestInsertTarget(t *testing.T) {
 	var a [TargetsKept]uint64
 	for i := 0; i < 10000; i++ {
@@ -209,3 +277,126 @@ func abs(v int64) int64 {
 	}
 	return v
 }
+
+func TestNewWorkerStats(t *testing.T) {
+	work := NewShareMap()
+	work.AddShare(WorkerKey("a@gmail.com", "rig1"), Share{Difficulty: 3})
+	work.AddShare(WorkerKey("a@gmail.com", "rig2"), Share{Difficulty: 1})
+	work.AddShare(WorkerKey("b@gmail.com", "rig1"), Share{Difficulty: 4})
+
+	stats := NewWorkerStats(10, *work)
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 workers, found %d", len(stats))
+	}
+	for _, st := range stats {
+		if st.JobID != 10 {
+			t.Errorf("expected job 10, found %d", st.JobID)
+		}
+		sum := work.Sums[WorkerKey(st.UserID, st.MinerID)]
+		if sum == nil || sum.TotalDifficulty != st.WorkerDifficulty {
+			t.Errorf("%s/%s has the wrong difficulty", st.UserID, st.MinerID)
+		}
+		if !st.Proportion.Equal(decimal.NewFromFloat(st.WorkerDifficulty / 8)) {
+			t.Errorf("%s/%s has the wrong proportion %s", st.UserID, st.MinerID, st.Proportion)
+		}
+	}
+
+	user, miner := SplitWorkerKey(WorkerKey("a@gmail.com", "rig,1"))
+	if user != "a@gmail.com" || miner != "rig,1" {
+		t.Errorf("split worker key to %s and %s", user, miner)
+	}
+}
+
+func TestOwedPayouts_ApplyReferrals(t *testing.T) {
+	work := randomShareMap(1, 10)
+	pays := NewPayout(Reward{JobID: 1, PoolReward: 800e8}, decimal.RequireFromString("0.05"), *work)
+	referred := pays.UserPayouts[0]
+	fee := pays.PoolFee
+
+	referrals := map[string]string{referred.UserID: "referrer"}
+	pays.ApplyReferrals(referrals, decimal.RequireFromString("0.2"), decimal.RequireFromString("0.01"))
+	if len(pays.ReferralPayouts) != 1 {
+		t.Fatalf("expected 1 referral payout, found %d", len(pays.ReferralPayouts))
+	}
+
+	ref := pays.ReferralPayouts[0]
+	if ref.UserID != "referrer" || ref.ReferredID != referred.UserID {
+		t.Errorf("referral paid to the wrong user")
+	}
+	if ref.Payout <= 0 {
+		t.Errorf("referral payout %d should be paid", ref.Payout)
+	}
+	if pays.PoolFee+ref.Payout != fee {
+		t.Errorf("referral should come from the pool fee")
+	}
+
+	// The bonus is paid on top of the user's fee, from the pool's fee
+	pays = NewPayout(Reward{JobID: 1, PoolReward: 800e8}, decimal.RequireFromString("0.05"), *work)
+	pays.ApplyReferrals(referrals, decimal.Zero, decimal.RequireFromString("0.1"))
+	gross := referred.Payout + referred.PoolFee
+	if got := pays.ReferralPayouts[0].Payout; got <= referred.PoolFee || got < gross/10-1 {
+		t.Errorf("referral bonus %d should be 10%% of %d, more than the user's fee %d", got, gross, referred.PoolFee)
+	}
+
+	// A user with no fee still earns the bonus for their referrer
+	pays = NewPayout(Reward{JobID: 1, PoolReward: 800e8}, decimal.RequireFromString("0.05"), *work)
+	pays.ApplyUserFeeRates(map[string]decimal.Decimal{referred.UserID: decimal.Zero})
+	fee = pays.PoolFee
+	pays.ApplyReferrals(referrals, decimal.RequireFromString("0.2"), decimal.RequireFromString("0.01"))
+	if len(pays.ReferralPayouts) != 1 || pays.ReferralPayouts[0].Payout <= 0 {
+		t.Fatalf("expected a referral bonus for a user with no fee")
+	}
+	if pays.PoolFee+pays.ReferralPayouts[0].Payout != fee {
+		t.Errorf("referral should come from the pool fee")
+	}
+
+	// Never more than the pool fee, so a single user's bonus is over it
+	alone := randomShareMap(1, 1)
+	pays = NewPayout(Reward{JobID: 1, PoolReward: 800e8}, decimal.RequireFromString("0.05"), *alone)
+	fee = pays.PoolFee
+	referrals = map[string]string{pays.UserPayouts[0].UserID: "referrer"}
+	pays.ApplyReferrals(referrals, decimal.Zero, decimal.RequireFromString("0.9"))
+	if pays.ReferralPayouts[0].Payout != fee || pays.PoolFee != 0 {
+		t.Errorf("referral payout should be capped at the pool fee")
+	}
+}
+
+func TestShareMap_TimeDecay(t *testing.T) {
+	m := NewScoredShareMap(ScoringTimeDecay)
+	m.AddShare("early", Share{Difficulty: 10})
+	// Pretend a lambda of time has passed since the job started
+	m.Start = m.Start.Add(-time.Second * 1200)
+	m.AddShare("late", Share{Difficulty: 10})
+
+	if m.TotalDiff != 20 {
+		t.Errorf("expect total diff of 20, found %.2f", m.TotalDiff)
+	}
+
+	ratio := m.Sums["late"].TotalScore / m.Sums["early"].TotalScore
+	if ratio < 2.7 || ratio > 2.75 {
+		t.Errorf("expect the late share to weigh e times the early share, found %.4f", ratio)
+	}
+
+	p := NewPayout(Reward{JobID: 1, PoolReward: 100e8}, decimal.Zero, *m)
+	if p.Scoring != ScoringTimeDecay {
+		t.Errorf("expect scoring %s, found %s", ScoringTimeDecay, p.Scoring)
+	}
+	for _, pay := range p.UserPayouts {
+		exp := m.Proportion(*m.Sums[pay.UserID])
+		if !pay.Proportion.Equal(exp) {
+			t.Errorf("%s: expect proportion %s, found %s", pay.UserID, exp, pay.Proportion)
+		}
+		if pay.UserID == "late" && pay.Proportion.LessThan(decimal.RequireFromString("0.7")) {
+			t.Errorf("expect the late user to earn over 70%%, found %s", pay.Proportion)
+		}
+	}
+
+	// Difficulty scoring ignores the time
+	m = NewShareMap()
+	m.AddShare("early", Share{Difficulty: 10})
+	m.Start = m.Start.Add(-time.Second * 1200)
+	m.AddShare("late", Share{Difficulty: 10})
+	if m.Sums["late"].TotalScore != m.Sums["early"].TotalScore {
+		t.Errorf("expect equal scores, found %.2f and %.2f", m.Sums["late"].TotalScore, m.Sums["early"].TotalScore)
+	}
+}
@@ -0,0 +1,97 @@
+package accounting
+
+import (
+	"fmt"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/jinzhu/gorm"
+	"github.com/shopspring/decimal"
+)
+
+// UserPayoutSplit sends a percent of a user's payouts to an address. If a
+// user has splits, they replace the user's payout address.
+type UserPayoutSplit struct {
+	ID            uint            `gorm:"primary_key" json:"-"`
+	UserID        string          `gorm:"index:split_user_id" json:"userid"`
+	PayoutAddress string          `json:"payoutaddress"`
+	Percent       decimal.Decimal `sql:"type:decimal(20,8);" json:"percent"` // 50 is 50%
+}
+
+// SetPayoutSplits replaces all the splits of the user. The addresses must be
+// valid FA addresses, and the percents must sum to 100. No splits removes
+// the user's splits.
+func SetPayoutSplits(db *gorm.DB, userid string, splits []UserPayoutSplit) error {
+	var u authentication.User
+	if err := db.Where("uid = ?", userid).First(&u).Error; err != nil {
+		return fmt.Errorf("user %s: %s", userid, err.Error())
+	}
+
+	total := decimal.Zero
+	seen := make(map[string]bool)
+	for i := range splits {
+		if _, err := factom.NewFAAddress(splits[i].PayoutAddress); err != nil {
+			return fmt.Errorf("%s is not a valid payout address: %s", splits[i].PayoutAddress, err.Error())
+		}
+		if seen[splits[i].PayoutAddress] {
+			return fmt.Errorf("%s is in the splits more than once", splits[i].PayoutAddress)
+		}
+		seen[splits[i].PayoutAddress] = true
+
+		if !splits[i].Percent.GreaterThan(decimal.Zero) {
+			return fmt.Errorf("split percents must be above 0")
+		}
+		total = total.Add(splits[i].Percent)
+		splits[i].UserID = userid
+	}
+	if len(splits) > 0 && !total.Equal(decimal.New(100, 0)) {
+		return fmt.Errorf("split percents sum to %s, they must sum to 100", total.String())
+	}
+
+	tx := db.Begin()
+	if err := tx.Where("user_id = ?", userid).Delete(&UserPayoutSplit{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for i := range splits {
+		if err := tx.Create(&splits[i]).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// PayoutSplits returns the splits of all users, indexed by userid
+func PayoutSplits(db *gorm.DB) (map[string][]UserPayoutSplit, error) {
+	var splits []UserPayoutSplit
+	err := db.Order("user_id asc, id asc").Find(&splits).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	m := make(map[string][]UserPayoutSplit)
+	for _, s := range splits {
+		m[s.UserID] = append(m[s.UserID], s)
+	}
+	return m, nil
+}
+
+// SplitPayment expands the payment into a payment per split. The rounding
+// remainder goes to the first split, so the total is unchanged.
+func SplitPayment(p Paid, splits []UserPayoutSplit) []Paid {
+	if len(splits) == 0 || p.PaymentAmount <= 0 {
+		return []Paid{p}
+	}
+
+	payments := make([]Paid, len(splits))
+	var total int64
+	for i, s := range splits {
+		payments[i] = p
+		payments[i].PayoutAddress = s.PayoutAddress
+		payments[i].PaymentAmount = cut(p.PaymentAmount, s.Percent.Div(decimal.New(100, 0)))
+		total += payments[i].PaymentAmount
+	}
+	payments[0].PaymentAmount += p.PaymentAmount - total
+	return payments
+}
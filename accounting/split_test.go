@@ -0,0 +1,66 @@
+package accounting_test
+
+import (
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	splitA = "FA2jK2HcLnRdS94dEcU27rF3meoJfpUcZPSinpb7AwQvPRY6RL1Q"
+	splitB = "FA3EPZYqodgyEGXNMbiZKE5TS2x2J9wF8J9MvPZb52iGR78xMgCb"
+)
+
+func TestSetPayoutSplits(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+	a.DB.AutoMigrate(&authentication.User{})
+	require.NoError(a.DB.Create(&authentication.User{UID: "a@gmail.com", PayoutAddress: splitA}).Error)
+
+	split := func(addr, percent string) UserPayoutSplit {
+		return UserPayoutSplit{PayoutAddress: addr, Percent: decimal.RequireFromString(percent)}
+	}
+
+	require.Error(SetPayoutSplits(a.DB, "unknown@gmail.com", []UserPayoutSplit{split(splitA, "100")}), "unknown user")
+	require.Error(SetPayoutSplits(a.DB, "a@gmail.com", []UserPayoutSplit{split("FA-bad", "100")}), "bad address")
+	require.Error(SetPayoutSplits(a.DB, "a@gmail.com", []UserPayoutSplit{split(splitA, "50"), split(splitA, "50")}), "duplicate")
+	require.Error(SetPayoutSplits(a.DB, "a@gmail.com", []UserPayoutSplit{split(splitA, "60"), split(splitB, "30")}), "not 100")
+	require.Error(SetPayoutSplits(a.DB, "a@gmail.com", []UserPayoutSplit{split(splitA, "100"), split(splitB, "0")}), "zero percent")
+
+	require.NoError(SetPayoutSplits(a.DB, "a@gmail.com", []UserPayoutSplit{split(splitA, "66.5"), split(splitB, "33.5")}))
+	splits, err := PayoutSplits(a.DB)
+	require.NoError(err)
+	require.Len(splits["a@gmail.com"], 2)
+
+	// Replacing the splits removes the old ones
+	require.NoError(SetPayoutSplits(a.DB, "a@gmail.com", nil))
+	splits, err = PayoutSplits(a.DB)
+	require.NoError(err)
+	require.Empty(splits)
+}
+
+func TestSplitPayment(t *testing.T) {
+	require := require.New(t)
+	p := Paid{UserID: "a@gmail.com", PayoutAddress: splitA, PaymentAmount: 1001}
+
+	require.Equal([]Paid{p}, SplitPayment(p, nil))
+
+	splits := []UserPayoutSplit{
+		{PayoutAddress: splitA, Percent: decimal.New(50, 0)},
+		{PayoutAddress: splitB, Percent: decimal.New(50, 0)},
+	}
+	payments := SplitPayment(p, splits)
+	require.Len(payments, 2)
+	require.Equal(splitA, payments[0].PayoutAddress)
+	require.Equal(splitB, payments[1].PayoutAddress)
+	require.Equal(int64(501), payments[0].PaymentAmount)
+	require.Equal(int64(500), payments[1].PaymentAmount)
+
+	// Negative balances are never split
+	p.PaymentAmount = -10
+	require.Equal([]Paid{p}, SplitPayment(p, splits))
+}
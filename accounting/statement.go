@@ -0,0 +1,167 @@
+package accounting
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Statement periods
+const (
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// ValidPeriod returns an error if the statement period is unknown
+func ValidPeriod(period string) error {
+	switch period {
+	case PeriodDay, PeriodWeek, PeriodMonth:
+		return nil
+	}
+	return fmt.Errorf("unknown period '%s', expected '%s', '%s' or '%s'", period, PeriodDay, PeriodWeek, PeriodMonth)
+}
+
+// PeriodStart returns the start of the period the time is in, in UTC. Weeks
+// start on Monday.
+func PeriodStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case PeriodWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case PeriodMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+// periodEnd returns the start of the next period
+func periodEnd(period string, start time.Time) time.Time {
+	switch period {
+	case PeriodWeek:
+		return start.AddDate(0, 0, 7)
+	case PeriodMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// UserStatement is the activity of a user over a period. The owed includes
+// referral payouts and finder bonuses. The balance is what is outstanding at
+// the end of the period.
+type UserStatement struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Jobs     int       `json:"jobs"`
+	Owed     int64     `json:"owed"` // In PEG
+	Fees     int64     `json:"fees"` // In PEG, the pool fees taken from the user
+	Payments int       `json:"payments"`
+	Paid     int64     `json:"paid"`    // In PEG
+	Balance  int64     `json:"balance"` // In PEG
+}
+
+// statementItem is an amount owed or paid at a time. Jobs are dated by when
+// the pool synced their block, so jobs without a synced block are undated.
+type statementItem struct {
+	Date    *time.Time
+	Payout  int64
+	PoolFee int64
+	Paid    int64
+	Job     bool
+}
+
+// UserStatements returns the statements of the periods the user has any
+// activity in, newest first.
+func UserStatements(db *gorm.DB, userid, period string) ([]UserStatement, error) {
+	if err := ValidPeriod(period); err != nil {
+		return nil, err
+	}
+
+	items, err := statementItems(db, userid)
+	if err != nil {
+		return nil, err
+	}
+
+	var balance int64
+	var statements []UserStatement
+	for _, item := range items {
+		balance += item.Payout - item.Paid
+		if item.Date == nil {
+			continue // Only in the balance
+		}
+
+		start := PeriodStart(period, *item.Date)
+		if len(statements) == 0 || !statements[len(statements)-1].Start.Equal(start) {
+			statements = append(statements, UserStatement{Start: start, End: periodEnd(period, start)})
+		}
+		st := &statements[len(statements)-1]
+		st.Owed += item.Payout
+		st.Fees += item.PoolFee
+		st.Paid += item.Paid
+		if item.Job {
+			st.Jobs++
+		}
+		if item.Paid != 0 {
+			st.Payments++
+		}
+		st.Balance = balance
+	}
+
+	// Newest first
+	for i, j := 0, len(statements)-1; i < j; i, j = i+1, j-1 {
+		statements[i], statements[j] = statements[j], statements[i]
+	}
+	return statements, nil
+}
+
+// statementItems returns all the user's payouts and payments, oldest first.
+// Undated items are first.
+func statementItems(db *gorm.DB, userid string) ([]statementItem, error) {
+	var items []statementItem
+	owed := func(table string, fee string, job bool) error {
+		var found []statementItem
+		err := db.Table(table).
+			Select(fmt.Sprintf("%s.payout, %s as pool_fee, block_syncs.synced_date as date", table, fee)).
+			Joins(fmt.Sprintf("LEFT JOIN block_syncs ON block_syncs.synced = %s.job_id", table)).
+			Where(fmt.Sprintf("%s.user_id = ?", table), userid).
+			Scan(&found).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		for i := range found {
+			found[i].Job = job
+		}
+		items = append(items, found...)
+		return nil
+	}
+
+	if err := owed("user_owed_payouts", "user_owed_payouts.pool_fee", true); err != nil {
+		return nil, err
+	}
+	if err := owed("referral_owed_payouts", "0", false); err != nil {
+		return nil, err
+	}
+	if err := owed("finder_owed_payouts", "0", false); err != nil {
+		return nil, err
+	}
+
+	var paid []Paid
+	if err := db.Where("user_id = ?", userid).Find(&paid).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	for _, p := range paid {
+		created := p.CreatedAt
+		items = append(items, statementItem{Date: &created, Paid: p.PaymentAmount})
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Date == nil || items[j].Date == nil {
+			return items[i].Date == nil && items[j].Date != nil
+		}
+		return items[i].Date.Before(*items[j].Date)
+	})
+	return items, nil
+}
@@ -0,0 +1,78 @@
+package accounting_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeriodStart(t *testing.T) {
+	require := require.New(t)
+	// A Wednesday
+	at := time.Date(2020, 1, 15, 13, 30, 0, 0, time.UTC)
+	require.Equal(time.Date(2020, 1, 15, 0, 0, 0, 0, time.UTC), PeriodStart(PeriodDay, at))
+	require.Equal(time.Date(2020, 1, 13, 0, 0, 0, 0, time.UTC), PeriodStart(PeriodWeek, at))
+	require.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), PeriodStart(PeriodMonth, at))
+	// Sunday is the end of the week
+	require.Equal(time.Date(2020, 1, 13, 0, 0, 0, 0, time.UTC), PeriodStart(PeriodWeek, time.Date(2020, 1, 19, 23, 0, 0, 0, time.UTC)))
+
+	require.Error(ValidPeriod("year"))
+}
+
+func TestUserStatements(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+	a.DB.AutoMigrate(&database.BlockSync{})
+
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 12, 0, 0, 0, time.UTC) }
+	synced := func(job int32, at time.Time) {
+		require.NoError(a.DB.Create(&database.BlockSync{Synced: job}).Error)
+		require.NoError(a.DB.Model(&database.BlockSync{}).Where("synced = ?", job).Update("synced_date", at).Error)
+	}
+
+	user := "a@gmail.com"
+	// Job 1 has no synced block
+	require.NoError(a.DB.Create(&UserOwedPayouts{JobID: 1, UserID: user, Payout: 5e8, PoolFee: 1e8}).Error)
+	for job, d := range map[int32]int{2: 1, 3: 1, 4: 2, 5: 10} {
+		synced(job, day(d))
+		require.NoError(a.DB.Create(&UserOwedPayouts{JobID: job, UserID: user, Payout: 10e8, PoolFee: 1e8}).Error)
+	}
+	require.NoError(a.DB.Create(&ReferralOwedPayouts{JobID: 3, UserID: user, ReferredID: "b", Payout: 2e8}).Error)
+	require.NoError(a.DB.Create(&Paid{UserID: user, EntryHash: "aa", PaymentAmount: 20e8, Model: gorm.Model{CreatedAt: day(2)}}).Error)
+	// Another user
+	require.NoError(a.DB.Create(&UserOwedPayouts{JobID: 2, UserID: "b", Payout: 10e8}).Error)
+
+	statements, err := UserStatements(a.DB, user, PeriodDay)
+	require.NoError(err)
+	require.Len(statements, 3)
+
+	// Newest first
+	require.Equal(day(10).Truncate(24*time.Hour), statements[0].Start)
+	require.Equal(int64(10e8), statements[0].Owed)
+	require.Equal(int64(27e8), statements[0].Balance)
+
+	require.Equal(2, statements[2].Jobs)
+	require.Equal(int64(22e8), statements[2].Owed)
+	require.Equal(int64(2e8), statements[2].Fees)
+	// The undated job is in the balance
+	require.Equal(int64(27e8), statements[2].Balance)
+
+	require.Equal(1, statements[1].Payments)
+	require.Equal(int64(20e8), statements[1].Paid)
+	require.Equal(int64(17e8), statements[1].Balance)
+
+	statements, err = UserStatements(a.DB, user, PeriodMonth)
+	require.NoError(err)
+	require.Len(statements, 1)
+	require.Equal(4, statements[0].Jobs)
+	require.Equal(int64(42e8), statements[0].Owed)
+	require.Equal(int64(20e8), statements[0].Paid)
+
+	_, err = UserStatements(a.DB, user, "year")
+	require.Error(err)
+}
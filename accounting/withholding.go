@@ -0,0 +1,125 @@
+package accounting
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Withholding detection
+//
+// A share's difficulty is Pareto distributed, as every hash is equally
+// likely to land anywhere in the target space. Given the k best shares of a
+// job, the k-1 best are independent Pareto samples above the k'th best, so
+// the sum of ln(d_i / d_k) follows a Gamma(k-1, 1) distribution with a mean
+// and variance of k-1. This holds no matter the hashrate or share
+// difficulty of the miner.
+//
+// A miner that withholds their best shares cuts off the tail, so the sum is
+// consistently below what is expected. Summed over many jobs, the z-score
+// of the sum shows how unlikely the user's best shares are.
+
+// WithholdingStats is the spread of a user's best shares in a job
+type WithholdingStats struct {
+	JobID  int32  `gorm:"primary_key;auto_increment:false" json:"jobid"`
+	UserID string `gorm:"primary_key" json:"userid"`
+	// Spacings is the number of best shares compared to the worst kept
+	// share, and LogSpacing is the sum of ln(d_i / d_k) over them.
+	Spacings   int     `json:"spacings"`
+	LogSpacing float64 `json:"logspacing"`
+}
+
+// WithholdingReport is how a user's best shares compare to what is expected
+// over a range of jobs.
+type WithholdingReport struct {
+	UserID   string  `json:"userid"`
+	Jobs     int     `json:"jobs"`
+	Expected float64 `json:"expected"`
+	Observed float64 `json:"observed"`
+	// Score is the z-score of the observed against the expected. Very
+	// negative scores mean the best shares are missing.
+	Score   float64 `json:"score"`
+	Flagged bool    `json:"flagged"`
+}
+
+// NewWithholdingStats returns the spread of every user's best shares. Users
+// with fewer than 2 shares are skipped. The work must be keyed by user.
+func NewWithholdingStats(jobid int32, work ShareMap) []WithholdingStats {
+	var stats []WithholdingStats
+	for user, sum := range work.Sums {
+		kept := sum.TotalShares
+		if kept > TargetsKept {
+			kept = TargetsKept
+		}
+		if kept < 2 {
+			continue
+		}
+
+		// Difficulty is inverse to the distance of the target from the
+		// max, so d_i / d_k is ^t_k / ^t_i.
+		worst := float64(^sum.Targets[kept-1])
+		st := WithholdingStats{JobID: jobid, UserID: user}
+		for i := 0; i < kept-1; i++ {
+			dist := float64(^sum.Targets[i])
+			if dist == 0 {
+				continue
+			}
+			st.Spacings++
+			st.LogSpacing += math.Log(worst / dist)
+		}
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+// WriteWithholdingStats writes all the withholding stats in a single
+// transaction
+func (a *Accountant) WriteWithholdingStats(stats []WithholdingStats) error {
+	tx := a.DB.Begin()
+	for i := range stats {
+		if err := tx.Create(&stats[i]).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// DetectWithholding scores every user with stats from the job onwards. Users
+// with at least minJobs jobs, and a score below -threshold are flagged. The
+// reports are sorted by score, the most suspect first.
+func DetectWithholding(db *gorm.DB, from int32, minJobs int, threshold float64) ([]WithholdingReport, error) {
+	rows, err := db.Model(&WithholdingStats{}).
+		Select("user_id, count(*), sum(spacings), sum(log_spacing)").
+		Where("job_id >= ?", from).
+		Group("user_id").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []WithholdingReport{}
+	for rows.Next() {
+		var r WithholdingReport
+		var expected sql.NullInt64
+		var observed sql.NullFloat64
+		if err := rows.Scan(&r.UserID, &r.Jobs, &expected, &observed); err != nil {
+			return nil, err
+		}
+		r.Expected = float64(expected.Int64)
+		r.Observed = observed.Float64
+		if r.Expected > 0 {
+			r.Score = (r.Observed - r.Expected) / math.Sqrt(r.Expected)
+		}
+		r.Flagged = r.Jobs >= minJobs && r.Score < -threshold
+		reports = append(reports, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(reports, func(i, j int) bool { return reports[i].Score < reports[j].Score })
+	return reports, nil
+}
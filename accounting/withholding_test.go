@@ -0,0 +1,54 @@
+package accounting_test
+
+import (
+	"math/rand"
+	"testing"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/difficulty"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectWithholding(t *testing.T) {
+	require := require.New(t)
+	a := AccountantForTests(t)
+	defer a.DB.Close()
+
+	r := rand.New(rand.NewSource(1))
+	share := func(minimum float64) Share {
+		// Every hash is uniform in the target space, so the difficulty of
+		// the shares above the minimum is minimum/u
+		d := minimum / (1 - r.Float64())
+		return Share{Difficulty: d, Target: difficulty.TargetFromDifficulty(d, difficulty.PDiff)}
+	}
+
+	for job := int32(1); job <= 50; job++ {
+		work := NewShareMap()
+		for i := 0; i < 200; i++ {
+			work.AddShare("honest", share(1))
+			work.AddShare("honest-fast", share(100))
+
+			// Withholds any share that would be submitted
+			s := share(1)
+			if s.Difficulty < 20 {
+				work.AddShare("withholder", s)
+			}
+		}
+		require.NoError(a.WriteWithholdingStats(NewWithholdingStats(job, *work)))
+	}
+
+	reports, err := DetectWithholding(a.DB, 0, 24, 4)
+	require.NoError(err)
+	require.Len(reports, 3)
+	require.Equal("withholder", reports[0].UserID)
+	require.True(reports[0].Flagged)
+	for _, r := range reports[1:] {
+		require.False(r.Flagged, "%s has a score of %.2f", r.UserID, r.Score)
+		require.Equal(50, r.Jobs)
+	}
+
+	// Too few jobs to flag
+	reports, err = DetectWithholding(a.DB, 40, 24, 4)
+	require.NoError(err)
+	require.False(reports[0].Flagged)
+}
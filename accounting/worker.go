@@ -0,0 +1,81 @@
+package accounting
+
+import (
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// WorkerKey indexes the JobsByMiner share maps. Minerids are only unique per
+// user, so the key is the same 'username,minerid' the miner authorizes with.
+func WorkerKey(userid, minerid string) string {
+	return userid + "," + minerid
+}
+
+// SplitWorkerKey returns the userid and minerid of a worker key
+func SplitWorkerKey(key string) (string, string) {
+	arr := strings.SplitN(key, ",", 2)
+	if len(arr) != 2 {
+		return arr[0], ""
+	}
+	return arr[0], arr[1]
+}
+
+// WorkerJobStats is the work done by a single miner of a user for a job. The
+// user's payout is the sum of all their workers.
+type WorkerJobStats struct {
+	JobID            int32   `gorm:"primary_key;auto_increment:false" json:"jobid"`
+	UserID           string  `gorm:"primary_key" json:"userid"`
+	MinerID          string  `gorm:"primary_key" json:"minerid"`
+	WorkerDifficulty float64 `json:"workerdifficulty"`
+	TotalSubmissions int     `json:"totalsubmissions"`
+
+	// Proportion is the worker's proportion of the pool difficulty
+	Proportion decimal.Decimal `sql:"type:decimal(20,8);" json:"proportion"`
+	HashRate   float64         `gorm:"default:0" json:"hashrate"` // Hashrate in h/s
+
+	FirstShare time.Time `json:"firstshare"`
+	LastShare  time.Time `json:"lastshare"`
+}
+
+// NewWorkerStats returns the stats of every worker in the job. The work must
+// be keyed by WorkerKey.
+func NewWorkerStats(jobid int32, work ShareMap) []WorkerJobStats {
+	var stats []WorkerJobStats
+	for key, sum := range work.Sums {
+		user, miner := SplitWorkerKey(key)
+		prop := work.Proportion(*sum)
+
+		// Same as the user hashrate, too few shares is not worth a guess
+		var hashrate float64
+		if sum.TotalShares >= 5 {
+			hashrate = sum.LastHashrate()
+		}
+
+		stats = append(stats, WorkerJobStats{
+			JobID:            jobid,
+			UserID:           user,
+			MinerID:          miner,
+			WorkerDifficulty: sum.TotalDifficulty,
+			TotalSubmissions: sum.TotalShares,
+			Proportion:       prop,
+			HashRate:         hashrate,
+			FirstShare:       sum.FirstShare,
+			LastShare:        sum.LastShare,
+		})
+	}
+	return stats
+}
+
+// WriteWorkerStats writes all the worker stats in a single transaction
+func (a *Accountant) WriteWorkerStats(stats []WorkerJobStats) error {
+	tx := a.DB.Begin()
+	for i := range stats {
+		if err := tx.Create(&stats[i]).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
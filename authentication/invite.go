@@ -12,6 +12,8 @@ type InviteCode struct {
 	ClaimedTime time.Time `gorm:"not null"`
 	Claimed     bool      `gorm:"not null"`
 	ClaimedBy   string    `gorm:"not null"`
+	// Referrer is the user that referred whoever claims the code
+	Referrer string `gorm:"default:''"`
 }
 
 func (a *Authenticator) RegisterUser(username, password, invitecode, payoutAddress string) bool {
@@ -39,6 +41,15 @@ func (a *Authenticator) NewCode(code string) error {
 	return a.DB.Create(&InviteCode{Code: code}).Error
 }
 
+// NewReferralCode makes a code that credits the referrer for the work of
+// whoever claims it.
+func (a *Authenticator) NewReferralCode(code, referrer string) error {
+	if !a.Exists(referrer) {
+		return fmt.Errorf("referrer %s does not exist", referrer)
+	}
+	return a.DB.Create(&InviteCode{Code: code, Referrer: referrer}).Error
+}
+
 func (a *Authenticator) CodeUnclaimed(code string) bool {
 	var i InviteCode
 	dbErr := a.DB.Where("code = ?", code).Find(&i)
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	crand "crypto/rand"
 	"encoding/json"
 	"fmt"
@@ -13,7 +14,10 @@ import (
 
 	"github.com/Factom-Asset-Tokens/base58"
 	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
 	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/FactomWyomingEntity/prosper-pool/payout"
+	"github.com/FactomWyomingEntity/prosper-pool/treasury"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -23,7 +27,20 @@ func init() {
 	db.AddCommand(makeCode)
 	db.AddCommand(makePayments)
 	db.AddCommand(recordPayments)
+	db.AddCommand(checkDust)
+	db.AddCommand(audit)
+	balances.AddCommand(rebuildBalances)
+	db.AddCommand(balances)
 	rootCmd.AddCommand(db)
+
+	audit.Flags().Int32("from", 0, "First job to audit")
+	audit.Flags().Int32("to", 0, "Last job to audit, 0 is the latest job")
+	audit.Flags().Bool("json", false, "Output the report as json")
+
+	makeCode.Flags().String("referrer", "", "User that referred whoever claims the code")
+
+	checkDust.Flags().Int32("from", 0, "First job to check")
+	checkDust.Flags().Int32("to", 0, "Last job to check, 0 is the latest job")
 }
 
 var db = &cobra.Command{
@@ -73,7 +90,27 @@ var recordPayments = &cobra.Command{
 			return err
 		}
 
-		err = a.WritePayments(payments)
+		// Only an approved payout can be recorded
+		var proposal *accounting.PayoutProposal
+		if viper.GetBool(config.ConfigPayoutRequireApproval) {
+			proposal, err = accounting.MatchProposal(db.DB, payments)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Receipt matches payout proposal %d\n", proposal.ID)
+		}
+
+		// The receipt is only recorded once every entry is confirmed
+		verifier, err := payout.NewChainVerifier(viper.GetViper())
+		if err != nil {
+			return err
+		}
+		if proposal != nil {
+			// The proposal's receipt is recorded with the payments
+			err = a.RecordProposalPayments(context.Background(), verifier, proposal.ID, "cli", payments)
+		} else {
+			err = a.RecordPayments(context.Background(), verifier, payments)
+		}
 		if err != nil {
 			return err
 		}
@@ -90,6 +127,10 @@ var makePayments = &cobra.Command{
 	Args:    cobra.ExactArgs(1),
 	PreRun:  SoftReadConfig,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if viper.GetBool(config.ConfigPayoutRequireApproval) {
+			return fmt.Errorf("payouts require approval, propose and export them on the admin payouts page")
+		}
+
 		info, err := os.Stat(args[0])
 		exists := info != nil && !os.IsNotExist(err)
 		if exists {
@@ -112,8 +153,24 @@ var makePayments = &cobra.Command{
 		}
 
 		var totalPay int64
+		batches := 0
 		for _, pay := range payments {
 			totalPay += pay.PaymentAmount
+			if pay.Batch+1 > batches {
+				batches = pay.Batch + 1
+			}
+		}
+
+		t, err := treasury.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+		balance, err := t.Check(context.Background(), totalPay)
+		if err == treasury.ErrInsufficientBalance {
+			return fmt.Errorf("%s only holds %s PEG, but the payout needs %s PEG", t.Address,
+				web.FactoshiToFactoid(uint64(balance)), web.FactoshiToFactoid(uint64(totalPay)))
+		} else if err != nil {
+			fmt.Printf("WARNING: unable to check the balance of %s: %s\n", t.Address, err.Error())
 		}
 
 		data, err := json.Marshal(payments)
@@ -134,6 +191,95 @@ var makePayments = &cobra.Command{
 
 		fmt.Println("Payment data written to file")
 		fmt.Printf("%s PEG needed for the TX\n", web.FactoshiToFactoid(uint64(totalPay)))
+		fmt.Printf("%d batches will be submitted\n", batches)
+		return nil
+	},
+}
+
+var checkDust = &cobra.Command{
+	Use:     "dust",
+	Short:   "Verify the dust ledger balances",
+	Long:    "Every payout's dust is carried into the next payout. This checks all rewards are distributed to users, the pool, or the dust carried forward.",
+	Example: "prosper-pool db dust --from 230000",
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, _ := cmd.Flags().GetInt32("from")
+		to, _ := cmd.Flags().GetInt32("to")
+
+		db, err := database.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		if _, err := accounting.NewAccountant(viper.GetViper(), db.DB); err != nil {
+			return err
+		}
+
+		report, err := accounting.CheckDustLedger(db.DB, from, to)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Checked %d jobs from %d to %d\n", report.Jobs, report.FirstJob, report.LastJob)
+		fmt.Printf("%20s: %s\n", "Rewards", web.FactoshiToFactoid(uint64(report.TotalRewards)))
+		fmt.Printf("%20s: %s\n", "Dust carried in", web.FactoshiToFactoid(uint64(report.CarriedIn)))
+		fmt.Printf("%20s: %s\n", "Owed to users", web.FactoshiToFactoid(uint64(report.TotalOwed)))
+		fmt.Printf("%20s: %s\n", "Pool fees", web.FactoshiToFactoid(uint64(report.TotalFees)))
+		fmt.Printf("%20s: %s\n", "Referrals", web.FactoshiToFactoid(uint64(report.TotalReferrals)))
+		fmt.Printf("%20s: %s\n", "Finder bonuses", web.FactoshiToFactoid(uint64(report.TotalFinderBonuses)))
+		fmt.Printf("%20s: %s\n", "Dust outstanding", web.FactoshiToFactoid(uint64(report.Outstanding)))
+		for _, d := range report.Discrepancies {
+			fmt.Printf("Job %d: %s\n", d.JobID, d.Reason)
+		}
+
+		if !report.Balanced() {
+			return fmt.Errorf("dust ledger does not balance")
+		}
+		fmt.Println("Dust ledger balances")
+		return nil
+	},
+}
+
+var audit = &cobra.Command{
+	Use:   "audit",
+	Short: "Verify the pool's books balance",
+	Long: "Reconciles the rewards, pool fees, dust and user payouts over the job range, " +
+		"and every user's balance. Any discrepancies are listed with the job or user involved.",
+	Example: "prosper-pool db audit --from 230000 --to 231000 --json",
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, _ := cmd.Flags().GetInt32("from")
+		to, _ := cmd.Flags().GetInt32("to")
+		asJson, _ := cmd.Flags().GetBool("json")
+
+		db, err := database.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		if _, err := accounting.NewAccountant(viper.GetViper(), db.DB); err != nil {
+			return err
+		}
+
+		report, err := accounting.Audit(db.DB, from, to,
+			viper.GetString(config.ConfigPoolIdentity), viper.GetString(config.ConfigPoolCoinbase))
+		if err != nil {
+			return err
+		}
+
+		if asJson {
+			data, err := json.MarshalIndent(report, "", "\t")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+		} else {
+			web.WriteAuditReport(os.Stdout, report)
+		}
+
+		if !report.Balanced() {
+			return fmt.Errorf("the books do not balance")
+		}
 		return nil
 	},
 }
@@ -162,7 +308,8 @@ var makeAdmin = &cobra.Command{
 var makeCode = &cobra.Command{
 	Use:     "code",
 	Short:   "Makes a new invite code",
-	Example: "prosper db code",
+	Long:    "If the code has a referrer, the referrer earns from the work of whoever claims the code.",
+	Example: "prosper db code --referrer user@gmail.com",
 	PreRun:  SoftReadConfig, // TODO: Do a hard read
 	Run: func(cmd *cobra.Command, args []string) {
 		db, err := database.New(viper.GetViper())
@@ -179,11 +326,48 @@ var makeCode = &cobra.Command{
 			panic(err)
 		}
 
-		err = a.NewCode(code)
+		if referrer, _ := cmd.Flags().GetString("referrer"); referrer != "" {
+			err = a.NewReferralCode(code, referrer)
+		} else {
+			err = a.NewCode(code)
+		}
 		if err != nil {
-			fmt.Println("failed to make code")
+			fmt.Printf("failed to make code: %s\n", err.Error())
+			return
 		}
 
 		fmt.Printf("New Code: %s\n", code)
 	},
 }
+
+var balances = &cobra.Command{
+	Use:   "balances",
+	Short: "Manage the user balance table",
+	Long: "The user balance table holds what every user is owed and paid. It is " +
+		"updated as payouts and payments are written.",
+}
+
+var rebuildBalances = &cobra.Command{
+	Use:     "rebuild",
+	Short:   "Recompute the user balance table from the owed payouts and payments",
+	Example: "prosper-pool db balances rebuild",
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := database.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		if _, err := accounting.NewAccountant(viper.GetViper(), db.DB); err != nil {
+			return err
+		}
+
+		n, err := accounting.RebuildUserBalances(db.DB)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Rebuilt the balances of %d users\n", n)
+		return nil
+	},
+}
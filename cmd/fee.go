@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	setFee.Flags().String("from", "", "Date the fee rate takes effect, as '2006-01-02' or RFC3339. Defaults to now")
+	setFee.Flags().String("until", "", "Date the fee rate ends, as '2006-01-02' or RFC3339. Defaults to never")
+	setFee.Flags().String("note", "", "A note about the fee rate, like 'early supporter'")
+
+	fee.AddCommand(setFee)
+	fee.AddCommand(listFees)
+	fee.AddCommand(endFee)
+	db.AddCommand(fee)
+}
+
+var fee = &cobra.Command{
+	Use:   "fee",
+	Short: "Manage per user fee rates",
+	Long: "Users can have their own fee rate that overrides the pool fee rate. " +
+		"Fee rates are applied to rewards processed while the rate is in effect.",
+}
+
+var setFee = &cobra.Command{
+	Use:     "set <userid> <rate>",
+	Short:   "Set a fee rate for a user",
+	Example: "prosper-pool db fee set user@gmail.com 0.02 --from 2020-01-01 --note partner",
+	Args:    cobra.ExactArgs(2),
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rate, err := decimal.NewFromString(args[1])
+		if err != nil {
+			return err
+		}
+
+		f := accounting.UserFeeRate{
+			UserID:        args[0],
+			FeeRate:       rate,
+			EffectiveFrom: time.Now(),
+		}
+		f.Note, _ = cmd.Flags().GetString("note")
+
+		if from, _ := cmd.Flags().GetString("from"); from != "" {
+			f.EffectiveFrom, err = accounting.ParseDate(from)
+			if err != nil {
+				return err
+			}
+		}
+
+		if until, _ := cmd.Flags().GetString("until"); until != "" {
+			u, err := accounting.ParseDate(until)
+			if err != nil {
+				return err
+			}
+			f.EffectiveUntil = &u
+		}
+
+		db, err := database.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		if _, err := accounting.NewAccountant(viper.GetViper(), db.DB); err != nil {
+			return err
+		}
+
+		if err := accounting.AddUserFeeRate(db.DB, &f); err != nil {
+			return err
+		}
+
+		fmt.Printf("Fee rate %d set for %s\n", f.ID, f.UserID)
+		return nil
+	},
+}
+
+var endFee = &cobra.Command{
+	Use:     "end <id>",
+	Short:   "End a fee rate now",
+	Example: "prosper-pool db fee end 4",
+	Args:    cobra.ExactArgs(1),
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := database.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		if _, err := accounting.NewAccountant(viper.GetViper(), db.DB); err != nil {
+			return err
+		}
+
+		id, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid id: %s", err.Error())
+		}
+
+		if err := accounting.EndUserFeeRate(db.DB, uint(id), time.Now()); err != nil {
+			return err
+		}
+
+		fmt.Printf("Fee rate %d ended\n", id)
+		return nil
+	},
+}
+
+var listFees = &cobra.Command{
+	Use:     "list",
+	Short:   "List all user fee rates",
+	Example: "prosper-pool db fee list",
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := database.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		if _, err := accounting.NewAccountant(viper.GetViper(), db.DB); err != nil {
+			return err
+		}
+
+		var fees []accounting.UserFeeRate
+		if err := db.DB.Order("user_id asc, effective_from asc").Find(&fees).Error; err != nil {
+			return err
+		}
+
+		for _, f := range fees {
+			until := "never"
+			if f.EffectiveUntil != nil {
+				until = f.EffectiveUntil.UTC().String()
+			}
+			fmt.Printf("%d\t%s\t%s\tfrom %s until %s\t%s\n",
+				f.ID, f.UserID, f.FeeRate.String(), f.EffectiveFrom.UTC(), until, f.Note)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/FactomWyomingEntity/prosper-pool/factomclient"
+	"github.com/FactomWyomingEntity/prosper-pool/ledger"
+	"github.com/FactomWyomingEntity/prosper-pool/payout"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	ledgerCmd.AddCommand(ledgerChain)
+	ledgerCmd.AddCommand(ledgerProof)
+	ledgerCmd.AddCommand(ledgerVerify)
+	rootCmd.AddCommand(ledgerCmd)
+
+	ledgerVerify.Flags().String("chain", "", "The pool's ledger chain id the proof must be on")
+}
+
+var ledgerCmd = &cobra.Command{
+	Use:   "ledger",
+	Short: "Manage and verify the payout ledger published on chain",
+	Long: "The pool can publish a signed summary of the owed payouts to a factom chain. " +
+		"Every user's owed payout is committed to by a merkle root, so a user can prove their row is included.",
+}
+
+var ledgerChain = &cobra.Command{
+	Use:     "chain",
+	Short:   "Create the ledger chain for the ledger key",
+	Example: "prosper-pool ledger chain",
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := ledger.LoadKey(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		submitter, err := ledger.NewSubmitter(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		identity := viper.GetString(config.ConfigPoolIdentity)
+		e := ledger.ChainEntry(identity, key.FAAddress())
+		if err := submitter.SubmitEntry(context.Background(), &e); err != nil {
+			return fmt.Errorf("unable to create the chain: %s", err.Error())
+		}
+
+		fmt.Printf("Ledger chain created for %s, signed by %s\n", identity, key.FAAddress())
+		fmt.Printf("ChainID: %s\n", e.ChainID)
+		return nil
+	},
+}
+
+var ledgerProof = &cobra.Command{
+	Use:     "proof <jobid> <userid>",
+	Short:   "Print the proof that a user's owed payout is in the published ledger",
+	Example: "prosper-pool ledger proof 210500 user@gmail.com",
+	Args:    cobra.ExactArgs(2),
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jobid, err := strconv.ParseInt(args[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid jobid: %s", err.Error())
+		}
+
+		db, err := database.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		p, err := ledger.NewPublisher(viper.GetViper(), db.DB)
+		if err != nil {
+			return err
+		}
+
+		proof, err := p.Proof(int32(jobid), args[1])
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(proof, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	},
+}
+
+var ledgerVerify = &cobra.Command{
+	Use:     "verify <proof.json>",
+	Short:   "Verify a proof against the ledger entry on chain",
+	Long:    "Only factomd is needed to verify a proof. Pass the pool's published chain id to be sure the proof is from the pool.",
+	Example: "prosper-pool ledger verify proof.json --chain <chainid>",
+	Args:    cobra.ExactArgs(1),
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+
+		var proof ledger.Proof
+		if err := json.Unmarshal(data, &proof); err != nil {
+			return fmt.Errorf("unable to parse the proof: %s", err.Error())
+		}
+
+		if chain, _ := cmd.Flags().GetString("chain"); chain != "" {
+			var id factom.Bytes32
+			if err := id.Set(chain); err != nil {
+				return fmt.Errorf("invalid chain: %s", err.Error())
+			}
+			if id != proof.ChainID {
+				return fmt.Errorf("the proof is for chain %s, not %s", proof.ChainID, id)
+			}
+		}
+
+		cl := factomclient.FactomClientFromConfig(viper.GetViper())
+		s, err := proof.Verify(context.Background(), payout.FactomdEntries{Client: cl})
+		if err != nil {
+			return fmt.Errorf("proof is invalid: %s", err.Error())
+		}
+
+		fmt.Printf("Proof is valid\n")
+		fmt.Printf("   Chain: %s\n", proof.ChainID)
+		fmt.Printf("   Entry: %s\n", proof.EntryHash)
+		fmt.Printf("    Jobs: %d to %d, %d users, merkle root %s\n", s.FromJob, s.ToJob, s.Users, s.MerkleRoot)
+		fmt.Printf("    User: %s, Job: %d, Difficulty: %f, Payout: %d, Fee: %d\n",
+			proof.Leaf.UserID, proof.Leaf.JobID, proof.Leaf.Difficulty, proof.Leaf.Payout, proof.Leaf.PoolFee)
+		return nil
+	},
+}
@@ -0,0 +1,302 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/FactomWyomingEntity/prosper-pool/payout"
+	"github.com/FactomWyomingEntity/prosper-pool/web"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	threshold.AddCommand(setThreshold)
+	threshold.AddCommand(listThresholds)
+	db.AddCommand(threshold)
+	listRuns.AddCommand(recordRun)
+	listRuns.AddCommand(failRun)
+	db.AddCommand(listRuns)
+
+	split.AddCommand(setSplit)
+	split.AddCommand(listSplits)
+	db.AddCommand(split)
+
+	listRuns.Flags().Int("limit", 25, "Number of runs to list")
+	failRun.Flags().String("reason", "failed by an admin", "Why the run failed")
+}
+
+var threshold = &cobra.Command{
+	Use:   "threshold",
+	Short: "Manage per user minimum payouts",
+	Long: "Users can have their own minimum payout that overrides the pool minimum payout. " +
+		"Balances under the minimum are carried until a later payout.",
+}
+
+var setThreshold = &cobra.Command{
+	Use:     "set <userid> <peg>",
+	Short:   "Set the minimum payout for a user",
+	Example: "prosper-pool db threshold set user@gmail.com 500",
+	Args:    cobra.ExactArgs(2),
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		peg, err := decimal.NewFromString(args[1])
+		if err != nil {
+			return fmt.Errorf("%s is not a valid amount", args[1])
+		}
+		minimum := peg.Mul(decimal.New(1e8, 0)).IntPart()
+		if minimum < 0 {
+			return fmt.Errorf("minimum payout cannot be negative")
+		}
+
+		db, err := database.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		if _, err := accounting.NewAccountant(viper.GetViper(), db.DB); err != nil {
+			return err
+		}
+
+		if err := accounting.SetUserPayoutThreshold(db.DB, args[0], minimum); err != nil {
+			return err
+		}
+
+		fmt.Printf("Minimum payout for %s set to %s PEG\n", args[0], web.FactoshiToFactoid(uint64(minimum)))
+		return nil
+	},
+}
+
+var listThresholds = &cobra.Command{
+	Use:     "list",
+	Short:   "List all user minimum payouts",
+	Example: "prosper-pool db threshold list",
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := database.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		a, err := accounting.NewAccountant(viper.GetViper(), db.DB)
+		if err != nil {
+			return err
+		}
+
+		thresholds, err := accounting.PayoutThresholds(db.DB)
+		if err != nil {
+			return err
+		}
+
+		users := make([]string, 0, len(thresholds))
+		for user := range thresholds {
+			users = append(users, user)
+		}
+		sort.Strings(users)
+
+		fmt.Printf("Pool minimum payout: %s PEG\n", web.FactoshiToFactoid(uint64(a.MinimumPayout)))
+		for _, user := range users {
+			fmt.Printf("%s\t%s PEG\n", user, web.FactoshiToFactoid(uint64(thresholds[user])))
+		}
+		return nil
+	},
+}
+
+var listRuns = &cobra.Command{
+	Use:     "runs",
+	Short:   "List the scheduled payout runs",
+	Example: "prosper-pool db runs --limit 10",
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		db, err := database.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		if _, err := accounting.NewAccountant(viper.GetViper(), db.DB); err != nil {
+			return err
+		}
+
+		var runs []accounting.PayoutRun
+		if err := db.DB.Order("id desc").Limit(limit).Find(&runs).Error; err != nil {
+			return err
+		}
+
+		for _, r := range runs {
+			fmt.Printf("%d\t%s\t%s\t%d payments\t%s PEG\t%s\t%s\n",
+				r.ID, r.CreatedAt.UTC().Format("2006-01-02 15:04"), r.State, r.Count,
+				web.FactoshiToFactoid(uint64(r.Total)), r.EntryHash, r.Error)
+		}
+		return nil
+	},
+}
+
+var recordRun = &cobra.Command{
+	Use:   "record <id>",
+	Short: "Record the receipt of a submitted payout run",
+	Long: "Records the receipt kept on a submitted run that was never confirmed. " +
+		"Every entry of the receipt must be executed on chain.",
+	Example: "prosper-pool db runs record 12",
+	Args:    cobra.ExactArgs(1),
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("%s is not a valid run id", args[0])
+		}
+
+		db, err := database.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		a, err := accounting.NewAccountant(viper.GetViper(), db.DB)
+		if err != nil {
+			return err
+		}
+
+		verifier, err := payout.NewChainVerifier(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		run, err := a.RecordRun(context.Background(), verifier, uint(id))
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Run %d recorded, %d payments of %s PEG\n", run.ID, run.Count, web.FactoshiToFactoid(uint64(run.Total)))
+		return nil
+	},
+}
+
+var failRun = &cobra.Command{
+	Use:   "fail <id>",
+	Short: "Fail a payout run that is not on chain",
+	Long: "Fails a built or submitted run, so the balances are paid in the next run. " +
+		"Any batch of the run that is executed on chain is recorded first.",
+	Example: "prosper-pool db runs fail 12 --reason 'signer crashed'",
+	Args:    cobra.ExactArgs(1),
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("%s is not a valid run id", args[0])
+		}
+		reason, _ := cmd.Flags().GetString("reason")
+
+		db, err := database.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		a, err := accounting.NewAccountant(viper.GetViper(), db.DB)
+		if err != nil {
+			return err
+		}
+
+		verifier, err := payout.NewChainVerifier(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		run, err := a.FailRun(context.Background(), verifier, uint(id), reason)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Run %d failed\n", run.ID)
+		return nil
+	},
+}
+
+var split = &cobra.Command{
+	Use:   "split",
+	Short: "Manage per user payout splits",
+	Long: "A user's payouts can be split by percent across multiple addresses. " +
+		"The splits replace the user's payout address.",
+}
+
+var setSplit = &cobra.Command{
+	Use:   "set <userid> [<FA-address>:<percent>...]",
+	Short: "Set the payout splits of a user",
+	Long: "The percents must sum to 100. Setting no splits removes the user's " +
+		"splits, so they are paid to their payout address.",
+	Example: "prosper-pool db split set user@gmail.com FA2jK2HcLnRdS94dEcU27rF3meoJfpUcZPSinpb7AwQvPRY6RL1Q:60 FA3EPZYqodgyEGXNMbiZKE5TS2x2J9wF8J9MvPZb52iGR78xMgCb:40",
+	Args:    cobra.MinimumNArgs(1),
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var splits []accounting.UserPayoutSplit
+		for _, arg := range args[1:] {
+			arr := strings.Split(arg, ":")
+			if len(arr) != 2 {
+				return fmt.Errorf("%s must be <FA-address>:<percent>", arg)
+			}
+			percent, err := decimal.NewFromString(arr[1])
+			if err != nil {
+				return fmt.Errorf("%s is not a valid percent: %s", arr[1], err.Error())
+			}
+			splits = append(splits, accounting.UserPayoutSplit{PayoutAddress: arr[0], Percent: percent})
+		}
+
+		db, err := database.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		if _, err := accounting.NewAccountant(viper.GetViper(), db.DB); err != nil {
+			return err
+		}
+
+		if err := accounting.SetPayoutSplits(db.DB, args[0], splits); err != nil {
+			return err
+		}
+
+		fmt.Printf("%d payout splits set for %s\n", len(splits), args[0])
+		return nil
+	},
+}
+
+var listSplits = &cobra.Command{
+	Use:     "list",
+	Short:   "List all user payout splits",
+	Example: "prosper-pool db split list",
+	PreRun:  SoftReadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := database.New(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		if _, err := accounting.NewAccountant(viper.GetViper(), db.DB); err != nil {
+			return err
+		}
+
+		splits, err := accounting.PayoutSplits(db.DB)
+		if err != nil {
+			return err
+		}
+
+		users := make([]string, 0, len(splits))
+		for user := range splits {
+			users = append(users, user)
+		}
+		sort.Strings(users)
+
+		for _, user := range users {
+			fmt.Println(user)
+			for _, s := range splits[user] {
+				fmt.Printf("\t%s\t%s%%\n", s.PayoutAddress, s.Percent.String())
+			}
+		}
+		return nil
+	},
+}
@@ -10,7 +10,27 @@ import (
 const (
 	LoggingLevel = "app.loglevel"
 
-	ConfigPoolCut = "pool.PoolFeeRate"
+	ConfigPoolCut         = "pool.PoolFeeRate"
+	ConfigPoolRetainJobs  = "pool.RetainJobs"
+	ConfigPoolScoring     = "pool.Scoring"
+	ConfigPoolFinderBonus = "pool.FinderBonus"
+
+	ConfigPayoutMinimum         = "Payout.MinimumPayout"
+	ConfigPayoutInterval        = "Payout.Interval"
+	ConfigPayoutOffset          = "Payout.Offset"
+	ConfigPayoutSignerCommand   = "Payout.SignerCommand"
+	ConfigPayoutSource          = "Payout.Source"
+	ConfigPayoutKeyFile         = "Payout.KeyFile"
+	ConfigPayoutRequireApproval = "Payout.RequireApproval"
+	ConfigPayoutLargeFactor     = "Payout.LargePaymentFactor"
+
+	ConfigReferralFeeShare = "Referral.FeeShare"
+	ConfigReferralBonus    = "Referral.Bonus"
+	ConfigReferralPeriod   = "Referral.Period"
+
+	ConfigWithholdingWindow    = "Withholding.Window"
+	ConfigWithholdingMinJobs   = "Withholding.MinJobs"
+	ConfigWithholdingThreshold = "Withholding.Threshold"
 
 	ConfigSQLHost     = "Database.host"
 	ConfigSQLPort     = "Database.port"
@@ -19,6 +39,11 @@ const (
 	ConfigSQLPassword = "Database.password"
 
 	ConfigFactomdLocation = "Factom.FactomdLocation"
+	ConfigWalletdLocation = "Factom.WalletdLocation"
+	ConfigPegnetdLocation = "Factom.PegnetdLocation"
+
+	ConfigLedgerPeriod  = "Ledger.Period"
+	ConfigLedgerKeyFile = "Ledger.KeyFile"
 
 	ConfigPegnetPollingPeriod = "Pegnet.PollingPeriod"
 	ConfigPegnetRetryPeriod   = "Pegnet.RetryPeriod"
@@ -45,9 +70,15 @@ const (
 	ConfigPoolCoinbase  = "Pool.OPRCoinbase"
 	ConfigPoolESAddress = "Pool.ESAddress"
 
-	ConfigSubmitterCutoff  = "Submit.SubmissionCutoff"
-	ConfigSubmitterEMAN    = "Submit.EMA-N"
-	ConfigSubmitterSoftMax = "Submit.SoftMax"
+	ConfigSubmitterCutoff         = "Submit.SubmissionCutoff"
+	ConfigSubmitterEMAN           = "Submit.EMA-N"
+	ConfigSubmitterSoftMax        = "Submit.SoftMax"
+	ConfigSubmitterECAlert        = "Submit.ECAlertThreshold"
+	ConfigSubmitterBlockBudget    = "Submit.BlockECBudget"
+	ConfigSubmitterDailyBudget    = "Submit.DailyECBudget"
+	ConfigSubmitterMode           = "Submit.Mode"
+	ConfigSubmitterBatchSize      = "Submit.BatchSize"
+	ConfigSubmitterBatchThreshold = "Submit.BatchThreshold"
 
 	ConfigWebPort = "Web.Port"
 
@@ -55,6 +86,13 @@ const (
 	ConfigStratumPort           = "Stratum.StratumPort"
 	ConfigStratumWelcomeMessage = "Stratum.WelcomeMessage"
 	ConfigStratumCheckAllWork   = "Stratum.ValidateAllShares"
+	ConfigStratumSampleShares   = "Stratum.SampleShares"
+	ConfigStratumSampleDecay    = "Stratum.SampleDecay"
+	ConfigStratumSampleFloor    = "Stratum.SampleFloor"
+	ConfigStratumBanDuration    = "Stratum.BanDuration"
+
+	ConfigStratumValidationWorkers = "Stratum.ValidationWorkers"
+	ConfigStratumValidationQueue   = "Stratum.ValidationQueue"
 )
 
 func SetDefaults(conf *viper.Viper) {
@@ -66,6 +104,11 @@ func SetDefaults(conf *viper.Viper) {
 	conf.SetDefault(ConfigSQLPassword, "password")
 
 	conf.SetDefault(ConfigFactomdLocation, "http://localhost:8088/v2")
+	conf.SetDefault(ConfigWalletdLocation, "http://localhost:8089/v2")
+	conf.SetDefault(ConfigPegnetdLocation, "http://localhost:8070/v1")
+
+	conf.SetDefault(ConfigLedgerPeriod, "")
+	conf.SetDefault(ConfigLedgerKeyFile, "")
 
 	conf.SetDefault(ConfigPegnetPollingPeriod, time.Second*2)
 	conf.SetDefault(ConfigPegnetRetryPeriod, time.Second*5)
@@ -89,6 +132,26 @@ func SetDefaults(conf *viper.Viper) {
 	conf.SetDefault(ConfigAlternativeMePriority, -1)
 
 	conf.SetDefault(ConfigPoolCut, "0.05")
+	conf.SetDefault(ConfigPoolRetainJobs, 10)
+	conf.SetDefault(ConfigPoolScoring, "difficulty")
+	conf.SetDefault(ConfigPoolFinderBonus, "0")
+
+	conf.SetDefault(ConfigPayoutMinimum, "0")
+	conf.SetDefault(ConfigPayoutInterval, time.Duration(0))
+	conf.SetDefault(ConfigPayoutOffset, time.Duration(0))
+	conf.SetDefault(ConfigPayoutSignerCommand, "")
+	conf.SetDefault(ConfigPayoutSource, "")
+	conf.SetDefault(ConfigPayoutKeyFile, "")
+	conf.SetDefault(ConfigPayoutRequireApproval, false)
+	conf.SetDefault(ConfigPayoutLargeFactor, 5)
+
+	conf.SetDefault(ConfigReferralFeeShare, "0")
+	conf.SetDefault(ConfigReferralBonus, "0")
+	conf.SetDefault(ConfigReferralPeriod, time.Duration(0))
+
+	conf.SetDefault(ConfigWithholdingWindow, 144)
+	conf.SetDefault(ConfigWithholdingMinJobs, 24)
+	conf.SetDefault(ConfigWithholdingThreshold, 4.0)
 
 	conf.SetDefault(ConfigPoolIdentity, "Prosper")
 	conf.SetDefault(ConfigPoolCoinbase, "FA2jK2HcLnRdS94dEcU27rF3meoJfpUcZPSinpb7AwQvPRY6RL1Q")
@@ -98,10 +161,22 @@ func SetDefaults(conf *viper.Viper) {
 	// 6hrs
 	conf.SetDefault(ConfigSubmitterEMAN, 36)
 	conf.SetDefault(ConfigSubmitterSoftMax, 25)
+	conf.SetDefault(ConfigSubmitterECAlert, 1000)
+	conf.SetDefault(ConfigSubmitterBlockBudget, 0)
+	conf.SetDefault(ConfigSubmitterDailyBudget, 0)
+	conf.SetDefault(ConfigSubmitterMode, "auto")
+	conf.SetDefault(ConfigSubmitterBatchSize, 25)
+	conf.SetDefault(ConfigSubmitterBatchThreshold, 100)
 
 	conf.SetDefault(ConfigWebPort, 7070)
 
 	conf.SetDefault(ConfigStratumCheckAllWork, true)
+	conf.SetDefault(ConfigStratumSampleShares, false)
+	conf.SetDefault(ConfigStratumSampleDecay, 0.99)
+	conf.SetDefault(ConfigStratumSampleFloor, 0.05)
+	conf.SetDefault(ConfigStratumBanDuration, time.Hour)
+	conf.SetDefault(ConfigStratumValidationWorkers, 4)
+	conf.SetDefault(ConfigStratumValidationQueue, 1000)
 	conf.SetDefault(ConfigStratumRequireAuth, true)
 	conf.SetDefault(ConfigStratumPort, 1234)
 	conf.SetDefault(ConfigStratumWelcomeMessage, "Welcome to Prosper pool! Please visit http://my.pool.url:port for more information.")
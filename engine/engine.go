@@ -19,11 +19,14 @@ import (
 	"github.com/FactomWyomingEntity/prosper-pool/database"
 	"github.com/FactomWyomingEntity/prosper-pool/exit"
 	"github.com/FactomWyomingEntity/prosper-pool/factomclient"
+	"github.com/FactomWyomingEntity/prosper-pool/ledger"
 	"github.com/FactomWyomingEntity/prosper-pool/minutekeeper"
+	"github.com/FactomWyomingEntity/prosper-pool/payout"
 	"github.com/FactomWyomingEntity/prosper-pool/pegnet"
 	"github.com/FactomWyomingEntity/prosper-pool/polling"
 	"github.com/FactomWyomingEntity/prosper-pool/sharesubmit"
 	"github.com/FactomWyomingEntity/prosper-pool/stratum"
+	"github.com/FactomWyomingEntity/prosper-pool/treasury"
 	"github.com/FactomWyomingEntity/prosper-pool/web"
 	"github.com/pegnet/pegnet/modules/opr"
 	log "github.com/sirupsen/logrus"
@@ -42,6 +45,8 @@ type PoolEngine struct {
 	PegnetNode    *pegnet.Node
 	Poller        *polling.DataSources
 	Accountant    *accounting.Accountant
+	Payouts       *accounting.PayoutScheduler
+	Ledger        *ledger.Publisher
 	Submitter     *sharesubmit.Submitter
 	Authenticator *authentication.Authenticator
 	Web           *web.HttpServices
@@ -124,7 +129,61 @@ func (e *PoolEngine) init() error {
 		return err
 	}
 
+	var payouts *accounting.PayoutScheduler
+	if e.conf.GetDuration(config.ConfigPayoutInterval) > 0 || e.conf.GetBool(config.ConfigPayoutRequireApproval) {
+		if signer := e.conf.GetString(config.ConfigPayoutSignerCommand); signer != "" {
+			payouts = accounting.NewPayoutScheduler(e.conf, acc, accounting.ExecSigner{Command: signer})
+			// The command's receipt is only kept on the run, so the run
+			// is confirmed and recorded from the chain
+			verifier, err := payout.NewChainVerifier(e.conf)
+			if err != nil {
+				return err
+			}
+			payouts.SetConfirmer(verifier)
+			payouts.SetVerifier(verifier)
+		} else if e.conf.GetString(config.ConfigPayoutSource) != "" {
+			// The in-process service signs, and confirms with pegnetd
+			service, err := payout.NewService(e.conf)
+			if err != nil {
+				return err
+			}
+			payouts = accounting.NewPayoutScheduler(e.conf, acc, service)
+			payouts.SetConfirmer(service)
+			verifier, err := payout.NewChainVerifier(e.conf)
+			if err != nil {
+				return err
+			}
+			payouts.SetVerifier(verifier)
+		} else if e.conf.GetDuration(config.ConfigPayoutInterval) > 0 {
+			return fmt.Errorf("scheduled payouts require a signer command or payout source")
+		} else {
+			// Approved payouts can only be exported to sign with the payout-cli
+			engLog.Infof("payouts require approval, and have no signer")
+		}
+
+		// A run that is never confirmed blocks every later payout
+		if payouts != nil && payouts.Scheduled() && payouts.Confirmer == nil {
+			return fmt.Errorf("scheduled payouts require a confirmer")
+		}
+	} else {
+		engLog.Infof("scheduled payouts are disabled")
+	}
+
+	var pub *ledger.Publisher
+	if e.conf.GetString(config.ConfigLedgerPeriod) != "" {
+		pub, err = ledger.NewPublisher(e.conf, db.DB)
+		if err != nil {
+			return err
+		}
+	}
+
 	srv := web.NewHttpServices(e.conf, db.DB)
+	// The admin pages show the balance payouts are paid from
+	tr, err := treasury.New(e.conf)
+	if err != nil {
+		return err
+	}
+	srv.SetTreasury(tr)
 
 	mk := minutekeeper.NewMinuteKeeper(factomclient.FactomClientFromConfig(e.conf))
 
@@ -161,6 +220,8 @@ func (e *PoolEngine) init() error {
 	e.PegnetNode = p
 	e.Poller = pol
 	e.Accountant = acc
+	e.Payouts = payouts
+	e.Ledger = pub
 	e.Submitter = sub
 	e.Authenticator = auth
 	e.Web = srv
@@ -169,6 +230,12 @@ func (e *PoolEngine) init() error {
 	// Add all closes
 	exit.GlobalExitHandler.AddExit(e.Database.Close)
 
+	// Metrics are served by the profiler
+	accounting.RegisterPrometheus()
+	pegnet.RegisterPrometheus()
+	sharesubmit.RegisterPrometheus()
+	stratum.RegisterPrometheus()
+
 	return nil
 }
 
@@ -183,20 +250,29 @@ func (e *PoolEngine) link() error {
 	//	One for factom submit
 	subSubmissions := e.StratumServer.GetSubmissionExport()
 	e.Submitter.SetSubmissions(subSubmissions)
+	// The submitted shares find who won the finder bonus
+	e.Accountant.SetSubmissionFinder(e.Submitter)
+	// The minute tells the submitter when minute 9 is
+	e.Submitter.SetMinuteSource(e.MinuteKeeper)
 
 	e.Web.InitPrimary(e.Authenticator)
 	e.Web.SetStratumServer(e.StratumServer)
 	e.Web.SetMinuteKeeper(e.MinuteKeeper)
+	e.Web.SetPayouts(e.Accountant, e.Payouts)
+	e.Web.SetLedger(e.Ledger)
+	e.Web.SetSubmitter(e.Submitter)
 
 	e.StratumServer.SetAuthenticator(e.Authenticator)
 	e.StratumServer.SetShareCheck(e.MinuteKeeper)
+	e.StratumServer.SetSubmitThreshold(e.Submitter)
 
 	return nil
 }
 
 func (e *PoolEngine) Run(ctx context.Context) {
 	// MinuteKeeper watches for the min 0 to 1 problem
-	//	- Used by the submitter and stratum server to reject shares
+	//	- Used by the submitter and stratum server to reject shares, and by
+	//	  the submitter for minute 9 submissions
 	go e.MinuteKeeper.Run(ctx)
 
 	// Stratum server listens to new jobs - spits out new shares
@@ -205,6 +281,18 @@ func (e *PoolEngine) Run(ctx context.Context) {
 	// Accountant listens to new jobs, new rewards, and new shares
 	go e.Accountant.Listen(ctx)
 
+	// Payouts are built and signed on a schedule
+	if e.Payouts != nil {
+		go e.Payouts.Run(ctx)
+	}
+
+	// The ledger publishes a summary of the owed payouts
+	if e.Ledger != nil {
+		// Jobs are published once the rewards before them are written
+		e.Ledger.Rewards = e.Accountant
+		go e.Ledger.Run(ctx)
+	}
+
 	// Start syncing Blocks - spits out new jobs, new rewards
 	go e.PegnetNode.DBlockSync(ctx)
 
@@ -258,7 +346,8 @@ func (e *PoolEngine) listenBlocks(ctx context.Context) {
 // findRewards takes the graded block and tallies up the pool's rewards.
 func (e *PoolEngine) findRewards(hook pegnet.PegnetdHook) *accounting.Reward {
 	r := accounting.Reward{
-		JobID: stratum.JobIDFromHeight(hook.Height),
+		JobID:     stratum.JobIDFromHeight(hook.Height),
+		BlockTime: hook.Timestamp,
 	}
 
 	for _, graded := range hook.GradedBlock.Graded() {
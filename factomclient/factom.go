@@ -10,8 +10,8 @@ import (
 func FactomClientFromConfig(conf *viper.Viper) *factom.Client {
 	cl := factom.NewClient()
 	cl.FactomdServer = conf.GetString(config.ConfigFactomdLocation)
-	// We don't use walletd
-	cl.WalletdServer = conf.GetString("http://localhost:8089")
+	// Walletd is only used by the payout service
+	cl.WalletdServer = conf.GetString(config.ConfigWalletdLocation)
 
 	return cl
 }
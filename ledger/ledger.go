@@ -0,0 +1,182 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/payout"
+	"github.com/jinzhu/gorm"
+)
+
+// Version of the summary entries
+const Version = 1
+
+// ChainNameIDs are the name ids of the pool's ledger chain. The signer is part
+// of the chain id, so only the signer's entries are valid on the chain.
+func ChainNameIDs(identity string, signer factom.FAAddress) []factom.Bytes {
+	return []factom.Bytes{
+		factom.Bytes("prosper-pool"),
+		factom.Bytes("ledger"),
+		factom.Bytes(identity),
+		factom.Bytes(signer.String()),
+	}
+}
+
+// ChainID is the id of the pool's ledger chain
+func ChainID(identity string, signer factom.FAAddress) factom.Bytes32 {
+	return factom.ComputeChainID(ChainNameIDs(identity, signer))
+}
+
+// ChainEntry is the first entry of the ledger chain, which creates it
+func ChainEntry(identity string, signer factom.FAAddress) factom.Entry {
+	return factom.Entry{
+		ExtIDs:  ChainNameIDs(identity, signer),
+		Content: factom.Bytes(fmt.Sprintf("The payout ledger of the %s pool. Each entry is signed by %s.", identity, signer)),
+	}
+}
+
+// Leaf is a user's owed payout for a job. The leaves of a summary are ordered
+// by job, then user.
+type Leaf struct {
+	JobID      int32   `json:"jobid"`
+	UserID     string  `json:"userid"`
+	Difficulty float64 `json:"difficulty"`
+	Payout     int64   `json:"payout"`  // In PEG
+	PoolFee    int64   `json:"poolfee"` // In PEG
+}
+
+// Hash is the leaf hash of the leaf json
+func (l Leaf) Hash() factom.Bytes32 {
+	data, _ := json.Marshal(l)
+	return LeafHash(data)
+}
+
+// Summary is the entry published for a range of jobs. It commits to every
+// user's owed payout with the merkle root.
+type Summary struct {
+	Version        int            `json:"version"`
+	FromJob        int32          `json:"fromjob"`
+	ToJob          int32          `json:"tojob"`
+	PoolReward     int64          `json:"poolreward"` // In PEG
+	PoolFee        int64          `json:"poolfee"`    // In PEG
+	PoolDifficulty float64        `json:"pooldifficulty"`
+	Users          int            `json:"users"`
+	MerkleRoot     factom.Bytes32 `json:"merkleroot"`
+}
+
+// BuildSummary returns the summary of the jobs in the range, and it's leaves
+func BuildSummary(db *gorm.DB, from, to int32) (*Summary, []Leaf, error) {
+	var owed []accounting.OwedPayouts
+	err := db.Where("job_id >= ? AND job_id <= ?", from, to).Order("job_id asc").Find(&owed).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var users []accounting.UserOwedPayouts
+	err = db.Where("job_id >= ? AND job_id <= ?", from, to).Order("job_id asc, user_id asc").Find(&users).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := &Summary{Version: Version, FromJob: from, ToJob: to, Users: len(users)}
+	for _, o := range owed {
+		s.PoolReward += o.PoolReward
+		s.PoolFee += o.PoolFee
+		s.PoolDifficulty += o.PoolDifficuty
+	}
+
+	leaves := make([]Leaf, len(users))
+	hashes := make([]factom.Bytes32, len(users))
+	for i, u := range users {
+		leaves[i] = Leaf{JobID: u.JobID, UserID: u.UserID, Difficulty: u.UserDifficuty, Payout: u.Payout, PoolFee: u.PoolFee}
+		hashes[i] = leaves[i].Hash()
+	}
+	s.MerkleRoot = MerkleRoot(hashes)
+	return s, leaves, nil
+}
+
+// Entry returns the summary signed by the key at the time, on the ledger chain.
+// The extids are the timestamp, the rcd of the key, and the signature, signed
+// as a FAT entry with a single key is.
+func (s Summary) Entry(chain factom.Bytes32, key factom.FsAddress, at time.Time) (factom.Entry, error) {
+	content, err := json.Marshal(s)
+	if err != nil {
+		return factom.Entry{}, err
+	}
+
+	e := factom.Entry{ChainID: &chain, Content: content}
+	ts := []byte(strconv.FormatInt(at.Unix(), 10))
+	hash := payout.SigningHash(0, ts, e)
+	e.ExtIDs = []factom.Bytes{ts, key.RCD(), key.Sign(hash[:])}
+	return e, nil
+}
+
+// ParseEntry returns the summary of a ledger entry. The entry must be signed
+// by the key the chain is named for.
+func ParseEntry(e factom.Entry, identity string) (*Summary, error) {
+	if len(e.ExtIDs) != 3 {
+		return nil, fmt.Errorf("expected 3 extids, found %d", len(e.ExtIDs))
+	}
+	ts, rcd, sig := e.ExtIDs[0], e.ExtIDs[1], e.ExtIDs[2]
+	if len(rcd) != 1+ed25519.PublicKeySize || rcd[0] != payout.RCDType01 {
+		return nil, fmt.Errorf("invalid rcd")
+	}
+	signer := payout.RCDAddress(rcd)
+	if e.ChainID == nil || *e.ChainID != ChainID(identity, signer) {
+		return nil, fmt.Errorf("entry is not on the ledger chain of %s signed by %s", identity, signer)
+	}
+	hash := payout.SigningHash(0, ts, e)
+	if !ed25519.Verify(ed25519.PublicKey(rcd[1:]), hash[:], sig) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	var s Summary
+	if err := json.Unmarshal(e.Content, &s); err != nil {
+		return nil, err
+	}
+	if s.Version != Version {
+		return nil, fmt.Errorf("unknown version %d", s.Version)
+	}
+	return &s, nil
+}
+
+// Proof shows a user's owed payout is in a published summary. It has all a
+// user needs to check it against the chain, without trusting the pool.
+type Proof struct {
+	Identity  string         `json:"identity"`
+	ChainID   factom.Bytes32 `json:"chainid"`
+	EntryHash factom.Bytes32 `json:"entryhash"`
+	Leaf      Leaf           `json:"leaf"`
+	Path      []ProofStep    `json:"path"`
+}
+
+// Verify fetches the summary entry of the proof, and checks the leaf is in it.
+// It returns the summary.
+func (p Proof) Verify(ctx context.Context, entries payout.EntryFetcher) (*Summary, error) {
+	e, err := entries.GetEntry(ctx, p.EntryHash.String())
+	if err != nil {
+		return nil, fmt.Errorf("entry %s: %s", p.EntryHash, err.Error())
+	}
+	if e.ChainID == nil || *e.ChainID != p.ChainID {
+		return nil, fmt.Errorf("entry %s is not on chain %s", p.EntryHash, p.ChainID)
+	}
+
+	s, err := ParseEntry(e, p.Identity)
+	if err != nil {
+		return nil, fmt.Errorf("entry %s: %s", p.EntryHash, err.Error())
+	}
+	if p.Leaf.JobID < s.FromJob || p.Leaf.JobID > s.ToJob {
+		return nil, fmt.Errorf("job %d is not in the summary of jobs %d to %d", p.Leaf.JobID, s.FromJob, s.ToJob)
+	}
+	if root := ProofRoot(p.Leaf.Hash(), p.Path); !bytes.Equal(root[:], s.MerkleRoot[:]) {
+		return nil, fmt.Errorf("the leaf is not in the summary, root %s does not match %s", root, s.MerkleRoot)
+	}
+	return s, nil
+}
@@ -0,0 +1,261 @@
+package ledger_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/database"
+	. "github.com/FactomWyomingEntity/prosper-pool/ledger"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublisher(t *testing.T) {
+	require := require.New(t)
+	db := ledgerDB(t)
+	defer db.Close()
+
+	for job := int32(1); job <= 3; job++ {
+		writeOwed(t, db, job, "a@gmail.com", "b@gmail.com", "c@gmail.com")
+	}
+
+	key, err := factom.GenerateFsAddress()
+	require.NoError(err)
+	chain := new(testChain)
+	p := &Publisher{DB: db, Identity: "prosper", Key: key, Submitter: chain, Period: PeriodBlock}
+
+	n, err := p.Publish(context.Background(), time.Now())
+	require.NoError(err)
+	require.Equal(3, n)
+	require.Len(chain.entries, 3)
+
+	// Nothing new to publish
+	n, err = p.Publish(context.Background(), time.Now())
+	require.NoError(err)
+	require.Zero(n)
+
+	s, err := ParseEntry(chain.entries[1], "prosper")
+	require.NoError(err)
+	require.Equal(int32(2), s.FromJob)
+	require.Equal(int32(2), s.ToJob)
+	require.Equal(3, s.Users)
+	require.Equal(int64(3e8), s.PoolFee)
+	require.Equal(float64(30), s.PoolDifficulty)
+
+	// Every user can prove their row
+	for _, u := range []string{"a@gmail.com", "b@gmail.com", "c@gmail.com"} {
+		proof, err := p.Proof(2, u)
+		require.NoError(err)
+		require.Equal(p.ChainID(), proof.ChainID)
+		_, err = proof.Verify(context.Background(), chain)
+		require.NoError(err)
+	}
+	_, err = p.Proof(2, "unknown@gmail.com")
+	require.Error(err)
+	_, err = p.Proof(4, "a@gmail.com")
+	require.Error(err)
+
+	// A changed row does not verify
+	proof, err := p.Proof(2, "a@gmail.com")
+	require.NoError(err)
+	proof.Leaf.Payout++
+	_, err = proof.Verify(context.Background(), chain)
+	require.Error(err)
+
+	// Only the key the chain is named for can sign
+	other, err := factom.GenerateFsAddress()
+	require.NoError(err)
+	e, err := s.Entry(p.ChainID(), other, time.Now())
+	require.NoError(err)
+	_, err = ParseEntry(e, "prosper")
+	require.Error(err)
+}
+
+func TestPublisher_Day(t *testing.T) {
+	require := require.New(t)
+	db := ledgerDB(t)
+	defer db.Close()
+
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	synced := map[int32]time.Time{
+		1: day.Add(time.Hour),
+		2: day.Add(23 * time.Hour),
+		3: day.Add(25 * time.Hour),
+		4: day.Add(49 * time.Hour),
+	}
+	for job, date := range synced {
+		writeOwed(t, db, job, "a@gmail.com")
+		require.NoError(db.Create(&database.BlockSync{Synced: job}).Error)
+		require.NoError(db.Model(&database.BlockSync{}).Where("synced = ?", job).Update("synced_date", date).Error)
+	}
+
+	key, err := factom.GenerateFsAddress()
+	require.NoError(err)
+	chain := new(testChain)
+	p := &Publisher{DB: db, Identity: "prosper", Key: key, Submitter: chain, Period: PeriodDay}
+
+	// The second day is not over
+	n, err := p.Publish(context.Background(), day.Add(47*time.Hour))
+	require.NoError(err)
+	require.Equal(1, n)
+	s, err := ParseEntry(chain.entries[0], "prosper")
+	require.NoError(err)
+	require.Equal(int32(1), s.FromJob)
+	require.Equal(int32(2), s.ToJob)
+	require.Equal(2, s.Users)
+
+	n, err = p.Publish(context.Background(), day.Add(50*time.Hour))
+	require.NoError(err)
+	require.Equal(1, n)
+	s, err = ParseEntry(chain.entries[1], "prosper")
+	require.NoError(err)
+	require.Equal(int32(3), s.FromJob)
+	require.Equal(int32(3), s.ToJob)
+}
+
+func TestPublisher_Pending(t *testing.T) {
+	require := require.New(t)
+	db := ledgerDB(t)
+	defer db.Close()
+
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for job := int32(1); job <= 4; job++ {
+		writeOwed(t, db, job, "a@gmail.com")
+		require.NoError(db.Create(&database.BlockSync{Synced: job}).Error)
+		require.NoError(db.Model(&database.BlockSync{}).Where("synced = ?", job).Update("synced_date", day.Add(time.Hour)).Error)
+	}
+
+	key, err := factom.GenerateFsAddress()
+	require.NoError(err)
+	chain := new(testChain)
+	queue := &testQueue{held: 3}
+	p := &Publisher{DB: db, Identity: "prosper", Key: key, Submitter: chain, Period: PeriodDay, Rewards: queue}
+
+	// The jobs after a held reward wait for it, even once the day is over
+	now := day.Add(25 * time.Hour)
+	n, err := p.Publish(context.Background(), now)
+	require.NoError(err)
+	require.Equal(1, n)
+	s, err := ParseEntry(chain.entries[0], "prosper")
+	require.NoError(err)
+	require.Equal(int32(1), s.FromJob)
+	require.Equal(int32(2), s.ToJob)
+
+	// A failed submit is recorded, and submitted again with the same entry
+	queue.held = 0
+	chain.fail = true
+	_, err = p.Publish(context.Background(), now)
+	require.Error(err)
+	var recorded LedgerEntry
+	require.NoError(db.Where("from_job = ?", 3).First(&recorded).Error)
+	require.True(recorded.Pending)
+	_, err = p.Proof(3, "a@gmail.com")
+	require.Error(err)
+
+	chain.fail = false
+	n, err = p.Publish(context.Background(), now.Add(time.Hour))
+	require.NoError(err)
+	require.Zero(n)
+	require.Len(chain.entries, 2)
+	require.Equal(recorded.EntryHash, chain.entries[1].Hash.String())
+	s, err = ParseEntry(chain.entries[1], "prosper")
+	require.NoError(err)
+	require.Equal(int32(3), s.FromJob)
+	require.Equal(int32(4), s.ToJob)
+
+	// Every published job can be proven
+	for job := int32(1); job <= 4; job++ {
+		proof, err := p.Proof(job, "a@gmail.com")
+		require.NoError(err)
+		_, err = proof.Verify(context.Background(), chain)
+		require.NoError(err)
+	}
+
+	// A job written late in block mode is still published
+	p.Period = PeriodBlock
+	writeOwed(t, db, 6, "a@gmail.com")
+	n, err = p.Publish(context.Background(), now)
+	require.NoError(err)
+	require.Equal(1, n)
+	writeOwed(t, db, 5, "a@gmail.com")
+	n, err = p.Publish(context.Background(), now)
+	require.NoError(err)
+	require.Equal(1, n)
+	s, err = ParseEntry(chain.entries[3], "prosper")
+	require.NoError(err)
+	require.Equal(int32(5), s.FromJob)
+	require.Equal(int32(5), s.ToJob)
+}
+
+func ledgerDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+
+	conf := viper.New()
+	config.SetDefaults(conf)
+	_, err = accounting.NewAccountant(conf, db)
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&database.BlockSync{}, &LedgerEntry{}).Error)
+	return db
+}
+
+// writeOwed writes the owed payouts of the job, with 10 difficulty and 1 PEG
+// of fee per user.
+func writeOwed(t *testing.T, db *gorm.DB, job int32, users ...string) {
+	owed := &accounting.OwedPayouts{Reward: accounting.Reward{JobID: job, PoolReward: 100e8}}
+	for i, u := range users {
+		owed.PoolDifficuty += 10
+		owed.PoolFee += 1e8
+		owed.UserPayouts = append(owed.UserPayouts, accounting.UserOwedPayouts{
+			JobID: job, UserID: u, UserDifficuty: 10, Payout: int64(10e8 + i), PoolFee: 1e8,
+		})
+	}
+	require.NoError(t, accounting.WriteOwedPayouts(db, owed))
+}
+
+// testQueue holds back the jobs from held, if set
+type testQueue struct {
+	held int32
+}
+
+func (q *testQueue) HeldFrom() (int32, bool) {
+	return q.held, q.held > 0
+}
+
+// testChain stands in for factomd
+type testChain struct {
+	entries []factom.Entry
+	fail    bool
+}
+
+func (c *testChain) SubmitEntry(ctx context.Context, e *factom.Entry) error {
+	if c.fail {
+		return fmt.Errorf("factomd is down")
+	}
+	// Composing sets the entry hash, as factomd would
+	es, err := factom.GenerateEsAddress()
+	if err != nil {
+		return err
+	}
+	if _, _, _, err := e.Compose(es); err != nil {
+		return err
+	}
+	c.entries = append(c.entries, *e)
+	return nil
+}
+
+func (c *testChain) GetEntry(ctx context.Context, entryhash string) (factom.Entry, error) {
+	for _, e := range c.entries {
+		if e.Hash.String() == entryhash {
+			return e, nil
+		}
+	}
+	return factom.Entry{}, fmt.Errorf("entry not found")
+}
@@ -0,0 +1,89 @@
+package ledger
+
+import (
+	"crypto/sha256"
+
+	"github.com/Factom-Asset-Tokens/factom"
+)
+
+// Leaves and nodes are hashed with a different prefix, so a node can never be
+// passed off as a leaf.
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// ProofStep is a sibling hash on the path from a leaf to the merkle root
+type ProofStep struct {
+	Hash factom.Bytes32 `json:"hash"`
+	// Left is true if the sibling is on the left
+	Left bool `json:"left"`
+}
+
+// LeafHash is the hash of a leaf's data
+func LeafHash(data []byte) factom.Bytes32 {
+	return sha256.Sum256(append([]byte{leafPrefix}, data...))
+}
+
+func nodeHash(left, right factom.Bytes32) factom.Bytes32 {
+	data := make([]byte, 0, 1+2*len(left))
+	data = append(data, nodePrefix)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+	return sha256.Sum256(data)
+}
+
+// MerkleRoot returns the root of the leaf hashes. A node without a sibling is
+// carried up to the next level as is. An empty tree has a zero root.
+func MerkleRoot(leaves []factom.Bytes32) factom.Bytes32 {
+	if len(leaves) == 0 {
+		return factom.Bytes32{}
+	}
+
+	level := leaves
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return level[0]
+}
+
+// MerkleProof returns the path from the i'th leaf to the root
+func MerkleProof(leaves []factom.Bytes32, i int) []ProofStep {
+	var proof []ProofStep
+	level := leaves
+	for len(level) > 1 {
+		if i%2 == 1 {
+			proof = append(proof, ProofStep{Hash: level[i-1], Left: true})
+		} else if i+1 < len(level) {
+			proof = append(proof, ProofStep{Hash: level[i+1]})
+		}
+		level = nextLevel(level)
+		i /= 2
+	}
+	return proof
+}
+
+// ProofRoot returns the root the proof leads to from the leaf hash
+func ProofRoot(leaf factom.Bytes32, proof []ProofStep) factom.Bytes32 {
+	hash := leaf
+	for _, step := range proof {
+		if step.Left {
+			hash = nodeHash(step.Hash, hash)
+		} else {
+			hash = nodeHash(hash, step.Hash)
+		}
+	}
+	return hash
+}
+
+func nextLevel(level []factom.Bytes32) []factom.Bytes32 {
+	next := make([]factom.Bytes32, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, nodeHash(level[i], level[i+1]))
+		} else {
+			next = append(next, level[i])
+		}
+	}
+	return next
+}
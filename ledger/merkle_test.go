@@ -0,0 +1,39 @@
+package ledger_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	. "github.com/FactomWyomingEntity/prosper-pool/ledger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerkleProof(t *testing.T) {
+	require := require.New(t)
+	require.True(MerkleRoot(nil).IsZero())
+
+	for n := 1; n < 10; n++ {
+		leaves := make([]factom.Bytes32, n)
+		for i := range leaves {
+			leaves[i] = LeafHash([]byte(fmt.Sprintf("leaf-%d", i)))
+		}
+		root := MerkleRoot(leaves)
+
+		for i := range leaves {
+			proof := MerkleProof(leaves, i)
+			require.Equal(root, ProofRoot(leaves[i], proof), "leaf %d of %d", i, n)
+
+			// Another leaf cannot use the proof
+			other := LeafHash([]byte("other"))
+			require.NotEqual(root, ProofRoot(other, proof), "leaf %d of %d", i, n)
+		}
+	}
+
+	// A node cannot be passed off as a leaf
+	leaves := []factom.Bytes32{LeafHash([]byte("a")), LeafHash([]byte("b"))}
+	node := sha256.Sum256(append(append([]byte{0x01}, leaves[0][:]...), leaves[1][:]...))
+	require.Equal(factom.Bytes32(node), MerkleRoot(leaves))
+	require.NotEqual(MerkleRoot(leaves), LeafHash(append(leaves[0][:], leaves[1][:]...)))
+}
@@ -0,0 +1,331 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/factomclient"
+	"github.com/FactomWyomingEntity/prosper-pool/payout"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	ledgerLog = log.WithField("mod", "ledger")
+)
+
+// Ledger periods
+const (
+	// PeriodBlock publishes a summary for every job with rewards
+	PeriodBlock = "block"
+	// PeriodDay publishes a summary for the jobs synced on each UTC day, once
+	// the day is over
+	PeriodDay = "day"
+)
+
+// LedgerEntry is a published summary. The entry is recorded before it is
+// submitted, so a failed submit is retried with the same entry instead of
+// publishing the range again.
+type LedgerEntry struct {
+	gorm.Model
+	FromJob    int32 `gorm:"index:ledger_from_job"`
+	ToJob      int32 `gorm:"index:ledger_to_job"`
+	EntryHash  string
+	MerkleRoot string
+	Users      int
+	// SignedAt is the timestamp of the entry. Signing again at the same time
+	// gives the same entry.
+	SignedAt time.Time
+	// Pending is set until the entry is submitted
+	Pending bool
+}
+
+// RewardQueue holds the rewards whose payouts are not written yet
+type RewardQueue interface {
+	// HeldFrom returns the first job waiting to be written, if any
+	HeldFrom() (int32, bool)
+}
+
+// Publisher writes a signed summary of the owed payouts to the pool's ledger
+// chain, so users can prove their credited work is what the pool recorded.
+type Publisher struct {
+	DB        *gorm.DB
+	Identity  string
+	Key       factom.FsAddress
+	Submitter payout.EntrySubmitter
+	Period    string
+	Poll      time.Duration
+	// Rewards holds back the jobs after a reward that is not written, if set
+	Rewards RewardQueue
+}
+
+func NewPublisher(conf *viper.Viper, db *gorm.DB) (*Publisher, error) {
+	p := new(Publisher)
+	p.DB = db
+	p.Identity = conf.GetString(config.ConfigPoolIdentity)
+	p.Period = conf.GetString(config.ConfigLedgerPeriod)
+	p.Poll = time.Minute
+	if p.Period != PeriodBlock && p.Period != PeriodDay {
+		return nil, fmt.Errorf("ledger period must be '%s' or '%s'", PeriodBlock, PeriodDay)
+	}
+
+	var err error
+	p.Key, err = LoadKey(conf)
+	if err != nil {
+		return nil, err
+	}
+	p.Submitter, err = NewSubmitter(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, p.DB.AutoMigrate(&LedgerEntry{}).Error
+}
+
+// LoadKey returns the key that signs the ledger, from the ledger key file
+func LoadKey(conf *viper.Viper) (factom.FsAddress, error) {
+	keys, err := payout.LoadKeyFile(conf.GetString(config.ConfigLedgerKeyFile))
+	if err != nil {
+		return factom.FsAddress{}, fmt.Errorf("ledger %s", err.Error())
+	}
+	if len(keys) != 1 {
+		return factom.FsAddress{}, fmt.Errorf("ledger key file must have one key, found %d", len(keys))
+	}
+	return keys[0], nil
+}
+
+// NewSubmitter returns a submitter that pays for entries with the pool's
+// entry credit address.
+func NewSubmitter(conf *viper.Viper) (payout.EntrySubmitter, error) {
+	es, err := factom.NewEsAddress(conf.GetString(config.ConfigPoolESAddress))
+	if err != nil {
+		return nil, fmt.Errorf("config entry credit address failed: %s", err.Error())
+	}
+	return payout.FactomdSubmitter{Client: factomclient.FactomClientFromConfig(conf), ESAddress: es}, nil
+}
+
+// ChainID is the ledger chain the publisher writes to
+func (p *Publisher) ChainID() factom.Bytes32 {
+	return ChainID(p.Identity, p.Key.FAAddress())
+}
+
+func (p *Publisher) Run(ctx context.Context) {
+	ledgerLog.WithField("chain", p.ChainID().String()).Infof("publishing a ledger summary every %s", p.Period)
+	poll := time.NewTicker(p.Poll)
+	defer poll.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			if _, err := p.Publish(ctx, time.Now()); err != nil {
+				ledgerLog.WithError(err).Error("failed to publish the ledger")
+			}
+		}
+	}
+}
+
+// Publish writes the summary of every period that is complete by the given
+// time, and returns the number published. Entries that failed to submit
+// before are submitted first.
+func (p *Publisher) Publish(ctx context.Context, now time.Time) (int, error) {
+	var unsent []LedgerEntry
+	if err := p.DB.Where("pending = ?", true).Order("from_job asc").Find(&unsent).Error; err != nil {
+		return 0, err
+	}
+	for i := range unsent {
+		if err := p.submit(ctx, &unsent[i]); err != nil {
+			return 0, err
+		}
+	}
+
+	ranges, err := p.pending(now)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, r := range ranges {
+		s, _, err := BuildSummary(p.DB, r[0], r[1])
+		if err != nil {
+			return i, err
+		}
+
+		published := LedgerEntry{
+			FromJob:    s.FromJob,
+			ToJob:      s.ToJob,
+			MerkleRoot: s.MerkleRoot.String(),
+			Users:      s.Users,
+			SignedAt:   time.Unix(now.Unix(), 0),
+			Pending:    true,
+		}
+		if err := p.DB.Create(&published).Error; err != nil {
+			return i, err
+		}
+		if err := p.submit(ctx, &published); err != nil {
+			return i, err
+		}
+	}
+	return len(ranges), nil
+}
+
+// submit signs and submits the recorded summary, and marks it submitted
+func (p *Publisher) submit(ctx context.Context, published *LedgerEntry) error {
+	s, _, err := BuildSummary(p.DB, published.FromJob, published.ToJob)
+	if err != nil {
+		return err
+	}
+	if s.MerkleRoot.String() != published.MerkleRoot {
+		return fmt.Errorf("the owed payouts of jobs %d to %d changed since they were recorded", s.FromJob, s.ToJob)
+	}
+
+	e, err := s.Entry(p.ChainID(), p.Key, published.SignedAt)
+	if err != nil {
+		return err
+	}
+	data, err := e.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	hash := factom.ComputeEntryHash(data)
+	e.Hash = &hash
+
+	// The hash is saved first, so the entry can be found if the submit
+	// succeeds but the update does not
+	if err := p.DB.Model(published).Update("entry_hash", hash.String()).Error; err != nil {
+		return err
+	}
+	if err := p.Submitter.SubmitEntry(ctx, &e); err != nil {
+		return fmt.Errorf("jobs %d to %d: %s", s.FromJob, s.ToJob, err.Error())
+	}
+	if err := p.DB.Model(published).Update("pending", false).Error; err != nil {
+		return err
+	}
+	ledgerLog.WithFields(log.Fields{"from": s.FromJob, "to": s.ToJob, "entryhash": hash.String()}).
+		Info("ledger summary published")
+	return nil
+}
+
+// pending returns the job ranges to publish. Every job with owed payouts that
+// is not in a recorded summary is published, but not before the jobs before
+// it are written. A range never spans a recorded summary.
+func (p *Publisher) pending(now time.Time) ([][2]int32, error) {
+	const recorded = "EXISTS (SELECT 1 FROM ledger_entries WHERE ledger_entries.deleted_at IS NULL AND " +
+		"ledger_entries.from_job <= owed_payouts.job_id AND ledger_entries.to_job >= owed_payouts.job_id)"
+
+	var first struct {
+		JobID *int32
+	}
+	q := p.DB.Model(&accounting.OwedPayouts{}).Select("MIN(owed_payouts.job_id) AS job_id").Where("NOT " + recorded)
+	if err := q.Scan(&first).Error; err != nil {
+		return nil, err
+	}
+	if first.JobID == nil {
+		return nil, nil
+	}
+
+	type job struct {
+		JobID      int32
+		SyncedDate *time.Time
+		Published  bool
+	}
+	var jobs []job
+	q = p.DB.Model(&accounting.OwedPayouts{}).
+		Select("owed_payouts.job_id, block_syncs.synced_date, "+recorded+" AS published").
+		Joins("LEFT JOIN block_syncs ON block_syncs.synced = owed_payouts.job_id").
+		Where("owed_payouts.job_id >= ?", *first.JobID)
+	if p.Rewards != nil {
+		if held, ok := p.Rewards.HeldFrom(); ok {
+			q = q.Where("owed_payouts.job_id < ?", held)
+		}
+	}
+	if err := q.Order("owed_payouts.job_id asc").Scan(&jobs).Error; err != nil {
+		return nil, err
+	}
+
+	var ranges [][2]int32
+	if p.Period == PeriodBlock {
+		for _, j := range jobs {
+			if !j.Published {
+				ranges = append(ranges, [2]int32{j.JobID, j.JobID})
+			}
+		}
+		return ranges, nil
+	}
+
+	// A day is only published once it is over. Jobs without a sync date
+	// belong to the day of the job before them.
+	today := now.UTC().Truncate(24 * time.Hour)
+	var days []time.Time
+	var day time.Time
+	open := false
+	for _, j := range jobs {
+		if j.SyncedDate != nil {
+			day = j.SyncedDate.UTC().Truncate(24 * time.Hour)
+		}
+		if !day.Before(today) {
+			break
+		}
+		if j.Published {
+			open = false
+			continue
+		}
+		if n := len(ranges); open && days[n-1].Equal(day) {
+			ranges[n-1][1] = j.JobID
+		} else {
+			ranges = append(ranges, [2]int32{j.JobID, j.JobID})
+			days = append(days, day)
+			open = true
+		}
+	}
+	return ranges, nil
+}
+
+// Proof returns the proof of the user's owed payout for the job
+func (p *Publisher) Proof(jobid int32, userid string) (*Proof, error) {
+	return BuildProof(p.DB, p.Identity, p.Key.FAAddress(), jobid, userid)
+}
+
+// BuildProof returns the proof of the user's owed payout for the job, from the
+// published summary that has the job.
+func BuildProof(db *gorm.DB, identity string, signer factom.FAAddress, jobid int32, userid string) (*Proof, error) {
+	var published LedgerEntry
+	err := db.Where("from_job <= ? AND to_job >= ? AND pending = ?", jobid, jobid, false).First(&published).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, fmt.Errorf("job %d is not published yet", jobid)
+	} else if err != nil {
+		return nil, err
+	}
+
+	s, leaves, err := BuildSummary(db, published.FromJob, published.ToJob)
+	if err != nil {
+		return nil, err
+	}
+	if s.MerkleRoot.String() != published.MerkleRoot {
+		return nil, fmt.Errorf("the owed payouts of jobs %d to %d changed since they were published", s.FromJob, s.ToJob)
+	}
+
+	proof := &Proof{Identity: identity, ChainID: ChainID(identity, signer)}
+	if err := proof.EntryHash.Set(published.EntryHash); err != nil {
+		return nil, err
+	}
+
+	hashes := make([]factom.Bytes32, len(leaves))
+	index := -1
+	for i, l := range leaves {
+		hashes[i] = l.Hash()
+		if l.JobID == jobid && l.UserID == userid {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("%s has no owed payout for job %d", userid, jobid)
+	}
+	proof.Leaf = leaves[index]
+	proof.Path = MerkleProof(hashes, index)
+	return proof, nil
+}
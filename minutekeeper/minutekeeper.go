@@ -22,6 +22,9 @@ type MinuteKeeper struct {
 
 	submit       atomic.Bool
 	submitHeight atomic.Int32
+	// minute is the minute of the block being built, or -1 if we are not
+	// syncing minutes
+	minute atomic.Int32
 
 	lastNoneZeroHeight int32
 	syncing            bool
@@ -36,12 +39,14 @@ type MinuteKeeperStatus struct {
 	SubmitHeight       int32 `json:"submitheight"`
 	Syncing            bool  `json:"syncing"`
 	LastNoneZeroHeight int32 `json:"lastnonzero"`
+	Minute             int32 `json:"minute"`
 }
 
 func NewMinuteKeeper(cl *factom.Client) *MinuteKeeper {
 	k := new(MinuteKeeper)
 	k.FactomClient = cl
 	k.setSubmit(true)
+	k.minute.Store(-1)
 	k.Logger = log.New()
 	k.Logger.SetLevel(log.FatalLevel)
 	k.logE = k.Logger.WithField("mod", "minkeep")
@@ -55,6 +60,7 @@ func (k *MinuteKeeper) Status() MinuteKeeperStatus {
 		SubmitHeight:       k.submitHeight.Load(),
 		Syncing:            k.syncing,
 		LastNoneZeroHeight: k.lastNoneZeroHeight,
+		Minute:             k.minute.Load(),
 	}
 }
 
@@ -75,6 +81,7 @@ func (k *MinuteKeeper) Run(ctx context.Context) {
 		if err != nil {
 			// Any error? We use rolling submits, and just eat the 1min problem
 			k.setSubmit(true)
+			k.minute.Store(-1)
 			k.log().WithError(err).Error("failed to get minute")
 			time.Sleep(PollInterval)
 			continue
@@ -106,6 +113,12 @@ func (k *MinuteKeeper) Run(ctx context.Context) {
 			k.setSubmit(false)
 		}
 
+		if k.syncing {
+			k.minute.Store(cr.Minute)
+		} else {
+			k.minute.Store(-1)
+		}
+
 		k.log().WithFields(log.Fields{
 			"sub":  k.submit.Load(),
 			"min":  cr.Minute,
@@ -141,6 +154,12 @@ func (k *MinuteKeeper) CanSubmitHeight(h int32) bool {
 	return k.CanSubmit()
 }
 
+// Minute returns the minute of the block being built. If we are not syncing
+// by minutes, the minute is not known and -1 is returned.
+func (k *MinuteKeeper) Minute() int32 {
+	return k.minute.Load()
+}
+
 // CurrentMinute is the factomd api struct
 type CurrentMinute struct {
 	Leaderheight            int32 `json:"leaderheight"`
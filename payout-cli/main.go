@@ -1,17 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
-
-	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"time"
 
 	"github.com/Factom-Asset-Tokens/factom"
 
 	"github.com/FactomWyomingEntity/prosper-pool/accounting"
-	"github.com/pegnet/pegnetd/fat/fat2"
+	"github.com/FactomWyomingEntity/prosper-pool/payout"
+	"github.com/FactomWyomingEntity/prosper-pool/treasury"
 
 	"github.com/spf13/cobra"
 )
@@ -20,8 +21,14 @@ func init() {
 	// Defaults
 	rootCmd.PersistentFlags().StringP("factomdhost", "s", "http://localhost:8088/v2", "factomd api url")
 	rootCmd.PersistentFlags().StringP("walletdhost", "w", "http://localhost:8089", "factom-walletd url")
+	rootCmd.PersistentFlags().String("pegnetdhost", "http://localhost:8070/v1", "pegnetd api url, to check the source balance")
 
 	rootCmd.AddCommand(pay)
+	rootCmd.AddCommand(build)
+	rootCmd.AddCommand(sign)
+	rootCmd.AddCommand(broadcast)
+
+	sign.Flags().String("keyfile", "", "File with the Fs key of the source, instead of factom-walletd")
 }
 
 // Pool entry point
@@ -45,7 +52,7 @@ var rootCmd = &cobra.Command{
 }
 
 var pay = &cobra.Command{
-	Use:   "pay <pay.json file> <source-FA> <ECAddress> <reciept.json>",
+	Use:   "pay <pay.json file> <source-FA> <ECAddress> <receipt.json>",
 	Short: "Pay users on pegnet",
 	Args:  cobra.ExactArgs(4),
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -64,12 +71,6 @@ var pay = &cobra.Command{
 		}
 		defer file.Close()
 
-		recFile, err := os.OpenFile(receipt, os.O_CREATE|os.O_RDWR, 0666)
-		if err != nil {
-			return err
-		}
-		defer recFile.Close()
-
 		data, err := ioutil.ReadAll(file)
 		if err != nil {
 			return fmt.Errorf("error reading file: %s", err.Error())
@@ -86,80 +87,252 @@ var pay = &cobra.Command{
 			return fmt.Errorf("bad FA address: %s", err.Error())
 		}
 
-		// Construct the transaction
-		var batch fat2.TransactionBatch
-		batch.Version = 1
-		batch.ChainID = factom.NewBytes32(config.TransactionChain[:])
-		for _, pay := range payments {
-			var tx fat2.Transaction
-			if pay.PaymentAmount < 0 {
-				return fmt.Errorf("%s is below 0 in paymen", pay.PayoutAddress)
+		// Construct the transactions. Each user gets a transaction, with
+		// a transfer for each of their payout splits. The payments are
+		// split into batches that fit in an entry.
+		batches, err := payout.NewBatches(payments, poolAddr)
+		if err != nil {
+			return err
+		}
+
+		for i, batch := range batches {
+			if err := batch.MarshalEntry(); err != nil {
+				return fmt.Errorf("failed to marshal tx: %s", err.Error())
 			}
-			tx.Input.Amount = uint64(pay.PaymentAmount)
-			tx.Input.Address = poolAddr
-			tx.Input.Type = fat2.PTickerPEG
-			tx.Transfers = make([]fat2.AddressAmountTuple, 1)
-			tx.Transfers[0].Amount = uint64(pay.PaymentAmount)
-			tx.Transfers[0].Address, err = factom.NewFAAddress(pay.PayoutAddress)
-			if err != nil {
-				return fmt.Errorf("%s is not a valid payout adress: %s", pay.PayoutAddress, err.Error())
+			if _, err := batch.Entry.Cost(); err != nil {
+				return fmt.Errorf("error with batch %d: %s", i+1, err.Error())
 			}
+		}
 
-			batch.Transactions = append(batch.Transactions, tx)
+		ctx := context.Background()
+		if err := checkBalance(ctx, cmd, cl, poolAddr, payments); err != nil {
+			return err
 		}
 
-		err = batch.MarshalEntry()
+		priv, err := poolAddr.GetFsAddress(ctx, cl)
 		if err != nil {
-			return fmt.Errorf("failed to marshal tx: %s", err.Error())
+			return fmt.Errorf("unable to get private key: %s", err.Error())
 		}
 
-		if _, err := batch.Entry.Cost(); err != nil {
-			fmt.Println("If your entry is over 10KB, you can split the pay.json into parts manually.")
-			return fmt.Errorf("error with entry: %s", err.Error())
+		for i, batch := range batches {
+			if err := batch.Sign(priv); err != nil {
+				return fmt.Errorf("unable to sign batch %d: %s", i+1, err.Error())
+			}
+
+			// The signatures add to the size
+			if _, err := batch.Entry.Cost(); err != nil {
+				return fmt.Errorf("error with batch %d: %s", i+1, err.Error())
+			}
 		}
 
-		priv, err := poolAddr.GetFsAddress(cl)
+		return submitBatches(ctx, cl, payer, receipt, batches)
+	},
+}
+
+var build = &cobra.Command{
+	Use:   "build <pay.json file> <source-FA> <unsigned.json>",
+	Short: "Build the unsigned batches to pay users",
+	Long: "The first step of paying offline. The unsigned batches are signed by 'sign' on the machine with the key, " +
+		"then submitted by 'broadcast'.",
+	Args: cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filename, source, out := args[0], args[1], args[2]
+
+		data, err := ioutil.ReadFile(filename)
 		if err != nil {
-			return fmt.Errorf("unable to get private key: %s\n", err.Error())
+			return fmt.Errorf("error reading file: %s", err.Error())
 		}
 
-		batch.Sign(priv)
-
-		payment, err := factom.NewECAddress(payer)
+		var payments []accounting.Paid
+		err = json.Unmarshal(data, &payments)
 		if err != nil {
-			return fmt.Errorf("unable to get private key: %s\n", err.Error())
+			return fmt.Errorf("unable parsing file: %s", err.Error())
 		}
 
-		es, err := payment.GetEsAddress(cl)
+		poolAddr, err := factom.NewFAAddress(source)
 		if err != nil {
-			return fmt.Errorf("unable to get private key: %s", err.Error())
+			return fmt.Errorf("bad FA address: %s", err.Error())
 		}
 
-		txid, err := batch.ComposeCreate(cl, es)
+		f, err := payout.NewBatchFile(payments, poolAddr)
 		if err != nil {
-			return fmt.Errorf("unable to submit entry: %s", err.Error())
+			return err
 		}
 
-		for i := range payments {
-			payments[i].EntryHash = batch.Entry.Hash.String()
+		if err := f.Write(out); err != nil {
+			return fmt.Errorf("%s must be a new file: %s", out, err.Error())
 		}
 
-		data, err = json.Marshal(payments)
+		printBatchFile(f)
+		return nil
+	},
+}
+
+var sign = &cobra.Command{
+	Use:   "sign <unsigned.json> <signed.json>",
+	Short: "Sign the batches with the source key",
+	Long: "Signing can be done on a machine without network access. The key is read from the key file, " +
+		"or from factom-walletd. Compare the digest to the one printed by 'build'. " +
+		"Pegnet rejects a batch that lands on chain 12 hours after it is signed, so broadcast the signed " +
+		"file within 11 hours, or sign it again.",
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f, err := payout.ReadBatchFile(args[0])
 		if err != nil {
-			fmt.Printf("failed to make reciept: %s\n", err.Error())
-		} else {
-			_, err = recFile.Write(data)
+			return fmt.Errorf("refusing %s: %s", args[0], err.Error())
+		}
+
+		var keys payout.KeyStore
+		if keyfile, _ := cmd.Flags().GetString("keyfile"); keyfile != "" {
+			keys, err = payout.LoadKeyFile(keyfile)
 			if err != nil {
-				fmt.Printf("failed to make reciept: %s\n", err.Error())
+				return err
 			}
+		} else {
+			keys = payout.WalletdKeys{Client: factomdClient(cmd)}
+		}
+
+		priv, err := keys.GetFsAddress(context.Background(), f.Source)
+		if err != nil {
+			return fmt.Errorf("unable to get private key: %s", err.Error())
+		}
+
+		if err := f.Sign(priv); err != nil {
+			return err
+		}
+
+		if err := f.Write(args[1]); err != nil {
+			return fmt.Errorf("%s must be a new file: %s", args[1], err.Error())
+		}
+
+		printBatchFile(f)
+		return nil
+	},
+}
+
+var broadcast = &cobra.Command{
+	Use:   "broadcast <signed.json> <ECAddress> <receipt.json>",
+	Short: "Submit the signed batches to the network",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filename, payer, receipt := args[0], args[1], args[2]
+
+		info, err := os.Stat(receipt)
+		exists := info != nil && !os.IsNotExist(err)
+		if exists {
+			return fmt.Errorf("%s already exists. Receipt must be a new file", receipt)
+		}
+
+		f, err := payout.ReadBatchFile(filename)
+		if err != nil {
+			return fmt.Errorf("refusing %s: %s", filename, err.Error())
+		}
+		if !f.Signed() {
+			return fmt.Errorf("refusing %s: batches are not signed", filename)
+		}
+		left, err := f.CheckSaltAge(time.Now())
+		if err != nil {
+			return fmt.Errorf("refusing %s: %s", filename, err.Error())
+		}
+		if left < payout.SaltWindow/2 {
+			fmt.Printf("WARNING: the signatures expire in %s\n", left.Round(time.Minute))
 		}
 
-		fmt.Println("Payment submitted to the network")
+		batches, err := f.Batches()
+		if err != nil {
+			return err
+		}
+		printBatchFile(f)
+
+		cl := factomdClient(cmd)
+		ctx := context.Background()
+		if err := checkBalance(ctx, cmd, cl, f.Source, f.Payments); err != nil {
+			return err
+		}
+
+		return submitBatches(ctx, cl, payer, receipt, batches)
+	},
+}
+
+func printBatchFile(f *payout.BatchFile) {
+	fmt.Printf("  Source: %s\n", f.Source)
+	fmt.Printf("   Total: %d.%08d PEG (%d payments, %d batches)\n", f.Total/1e8, f.Total%1e8, len(f.Payments), len(f.Entries))
+	fmt.Printf("  Signed: %v\n", f.Signed())
+	fmt.Printf("  Digest: %s\n", f.Digest())
+}
+
+// submitBatches submits the signed batches, paid for by the EC address. The
+// receipt has every submitted batch, even if a later batch fails, so the
+// submitted payments can be recorded.
+func submitBatches(ctx context.Context, cl *factom.Client, payer, receipt string, batches []*payout.TransactionBatch) error {
+	recFile, err := os.OpenFile(receipt, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	defer recFile.Close()
+
+	payment, err := factom.NewECAddress(payer)
+	if err != nil {
+		return fmt.Errorf("unable to get private key: %s", err.Error())
+	}
+
+	es, err := payment.GetEsAddress(ctx, cl)
+	if err != nil {
+		return fmt.Errorf("unable to get private key: %s", err.Error())
+	}
+
+	var submitted []accounting.Paid
+	defer func() {
+		if err := writeReceipt(recFile, submitted); err != nil {
+			fmt.Printf("failed to make receipt: %s\n", err.Error())
+		}
+	}()
+
+	for i, batch := range batches {
+		txid, err := batch.Entry.ComposeCreate(ctx, cl, es)
+		if err != nil {
+			return fmt.Errorf("unable to submit batch %d: %s", i+1, err.Error())
+		}
+		submitted = append(submitted, batch.Receipt()...)
+
+		fmt.Printf("Batch %d of %d submitted to the network\n", i+1, len(batches))
 		fmt.Printf("EntryHash: %s\n", batch.Entry.Hash.String())
 		fmt.Printf("   Commit: %s\n", txid.String())
+	}
+	return nil
+}
 
+// writeReceipt writes the submitted payments, if there are any
+func writeReceipt(file *os.File, submitted []accounting.Paid) error {
+	if len(submitted) == 0 {
 		return nil
-	},
+	}
+	data, err := json.Marshal(submitted)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	return err
+}
+
+// checkBalance refuses a payout the source cannot cover. If pegnetd cannot
+// be reached, it only warns.
+func checkBalance(ctx context.Context, cmd *cobra.Command, cl *factom.Client, source factom.FAAddress, payments []accounting.Paid) error {
+	var total int64
+	for _, p := range payments {
+		total += p.PaymentAmount
+	}
+
+	location, _ := cmd.Flags().GetString("pegnetdhost")
+	t := &treasury.Treasury{Address: source, Source: treasury.PegnetdBalances{Client: cl, Location: location}}
+	balance, err := t.Check(ctx, total)
+	if err == treasury.ErrInsufficientBalance {
+		return fmt.Errorf("%s only holds %d.%08d PEG, but the payout needs %d.%08d PEG", source,
+			balance/1e8, balance%1e8, total/1e8, total%1e8)
+	} else if err != nil {
+		fmt.Printf("WARNING: unable to check the balance of %s: %s\n", source, err.Error())
+	}
+	return nil
 }
 
 func factomdClient(cmd *cobra.Command) *factom.Client {
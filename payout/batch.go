@@ -0,0 +1,251 @@
+package payout
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+)
+
+// The FAT-2 types are kept here, as importing pegnetd's fat2 pulls in a
+// version of the factom library the pool does not build with. The json and
+// signing match pegnetd's fat2.TransactionBatch. pegnetd only accepts a batch
+// with a single input address, signed by one RCD/signature pair.
+
+// PTickerPEG is the ticker of PEG in a FAT-2 transaction
+const PTickerPEG = "PEG"
+
+// RCDType01 is the first byte of an RCD for an ed25519 key
+const RCDType01 = 0x01
+
+// AddressAmountTuple is an amount sent to an address
+type AddressAmountTuple struct {
+	Address factom.FAAddress `json:"address"`
+	Amount  uint64           `json:"amount"`
+}
+
+// TypedAddressAmountTuple is an amount of an asset from an address
+type TypedAddressAmountTuple struct {
+	Address factom.FAAddress `json:"address"`
+	Amount  uint64           `json:"amount"`
+	Type    string           `json:"type"`
+}
+
+// Transaction moves the input amount to the transfers
+type Transaction struct {
+	Input     TypedAddressAmountTuple `json:"input"`
+	Transfers []AddressAmountTuple    `json:"transfers"`
+}
+
+// TransactionBatch is a set of transactions in a single entry. Every
+// transaction has the same input, which signs the entry once.
+type TransactionBatch struct {
+	Version      uint64        `json:"version"`
+	Transactions []Transaction `json:"transactions"`
+
+	Entry factom.Entry `json:"-"`
+	// Payments are the payments the batch pays
+	Payments []accounting.Paid `json:"-"`
+}
+
+// NewBatch pays each user from the source address in a transaction, with a
+// transfer for each of their payout splits.
+func NewBatch(payments []accounting.Paid, source factom.FAAddress) (*TransactionBatch, error) {
+	batch := &TransactionBatch{Version: 1}
+	userTx := make(map[string]int)
+	for _, pay := range payments {
+		if pay.PaymentAmount < 0 {
+			return nil, fmt.Errorf("%s is below 0 in payment", pay.PayoutAddress)
+		}
+
+		var transfer AddressAmountTuple
+		var err error
+		transfer.Amount = uint64(pay.PaymentAmount)
+		transfer.Address, err = factom.NewFAAddress(pay.PayoutAddress)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid payout address: %s", pay.PayoutAddress, err.Error())
+		}
+
+		i, ok := userTx[pay.UserID]
+		if !ok {
+			var tx Transaction
+			tx.Input.Address = source
+			tx.Input.Type = PTickerPEG
+			batch.Transactions = append(batch.Transactions, tx)
+			i = len(batch.Transactions) - 1
+			userTx[pay.UserID] = i
+		}
+
+		batch.Transactions[i].Input.Amount += transfer.Amount
+		batch.Transactions[i].Transfers = append(batch.Transactions[i].Transfers, transfer)
+		batch.Payments = append(batch.Payments, pay)
+	}
+
+	if len(batch.Transactions) == 0 {
+		return nil, fmt.Errorf("no payments in the batch")
+	}
+	return batch, nil
+}
+
+// NewBatches makes a batch for each batch the payments are assigned to, in
+// the order of the payments.
+func NewBatches(payments []accounting.Paid, source factom.FAAddress) ([]*TransactionBatch, error) {
+	var order []int
+	groups := make(map[int][]accounting.Paid)
+	for _, pay := range payments {
+		if _, ok := groups[pay.Batch]; !ok {
+			order = append(order, pay.Batch)
+		}
+		groups[pay.Batch] = append(groups[pay.Batch], pay)
+	}
+
+	var batches []*TransactionBatch
+	for _, b := range order {
+		batch, err := NewBatch(groups[b], source)
+		if err != nil {
+			return nil, fmt.Errorf("batch %d: %s", b, err.Error())
+		}
+		batches = append(batches, batch)
+	}
+	return batches, nil
+}
+
+// Receipt is the payments of the batch, with the batch's entryhash. The
+// entry must be submitted.
+func (b TransactionBatch) Receipt() []accounting.Paid {
+	receipt := make([]accounting.Paid, len(b.Payments))
+	for i, p := range b.Payments {
+		p.EntryHash = b.Entry.Hash.String()
+		receipt[i] = p
+	}
+	return receipt
+}
+
+// Total is the sum of all the inputs
+func (b TransactionBatch) Total() uint64 {
+	var total uint64
+	for _, tx := range b.Transactions {
+		total += tx.Input.Amount
+	}
+	return total
+}
+
+// MarshalEntry sets the entry content to the batch json, on the pegnet
+// transaction chain.
+func (b *TransactionBatch) MarshalEntry() error {
+	content, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	chain := factom.Bytes32(config.TransactionChain)
+	b.Entry.ChainID = &chain
+	b.Entry.Content = content
+	return nil
+}
+
+// Sign signs the entry with the key, which must be the input of every
+// transaction. The entry content must be marshalled first. The timestamp salt
+// is only valid for 12 hours either side of the entry landing on chain.
+func (b *TransactionBatch) Sign(key factom.FsAddress) error {
+	if _, err := b.input(); err != nil {
+		return err
+	}
+	for _, tx := range b.Transactions {
+		if tx.Input.Address != key.FAAddress() {
+			return fmt.Errorf("input %s does not match the signing key", tx.Input.Address)
+		}
+	}
+
+	salt := []byte(strconv.FormatInt(time.Now().Unix(), 10))
+	hash := SigningHash(0, salt, b.Entry)
+	b.Entry.ExtIDs = []factom.Bytes{salt, key.RCD(), key.Sign(hash[:])}
+	return nil
+}
+
+// Verify checks the single input of the batch signed the entry
+func (b *TransactionBatch) Verify() error {
+	input, err := b.input()
+	if err != nil {
+		return err
+	}
+	if len(b.Entry.ExtIDs) != 3 {
+		return fmt.Errorf("expected 3 extids, found %d", len(b.Entry.ExtIDs))
+	}
+
+	salt, rcd, sig := b.Entry.ExtIDs[0], b.Entry.ExtIDs[1], b.Entry.ExtIDs[2]
+	if _, err := SaltTime(salt); err != nil {
+		return err
+	}
+	if len(rcd) != 1+ed25519.PublicKeySize || rcd[0] != RCDType01 {
+		return fmt.Errorf("invalid rcd")
+	}
+	if RCDAddress(rcd) != input {
+		return fmt.Errorf("rcd does not match input %s", input)
+	}
+	hash := SigningHash(0, salt, b.Entry)
+	if !ed25519.Verify(ed25519.PublicKey(rcd[1:]), hash[:], sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// SaltTime is the signing time in the timestamp salt of a signed entry
+func SaltTime(salt []byte) (time.Time, error) {
+	sec, err := strconv.ParseInt(string(salt), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp salt: %s", err.Error())
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// input is the one input address of the batch
+func (b *TransactionBatch) input() (factom.FAAddress, error) {
+	if len(b.Transactions) == 0 {
+		return factom.FAAddress{}, fmt.Errorf("no transactions in the batch")
+	}
+	input := b.Transactions[0].Input.Address
+	for i, tx := range b.Transactions {
+		if tx.Input.Address != input {
+			return factom.FAAddress{}, fmt.Errorf("transaction %d: only one input address is allowed in a batch", i)
+		}
+	}
+	return input, nil
+}
+
+// UnmarshalEntry parses the batch from the entry
+func (b *TransactionBatch) UnmarshalEntry(e factom.Entry) error {
+	if e.ChainID == nil || bytes.Compare(e.ChainID[:], config.TransactionChain[:]) != 0 {
+		return fmt.Errorf("entry is not on the pegnet transaction chain")
+	}
+	if err := json.Unmarshal(e.Content, b); err != nil {
+		return err
+	}
+	b.Entry = e
+	return nil
+}
+
+// SigningHash is the hash signed by the i'th RCD of an entry, as FAT entries
+// are signed. It is salted by the index and timestamp, and covers the chain
+// and content. The ledger signs it's entries the same way.
+func SigningHash(i int, salt []byte, e factom.Entry) [sha512.Size]byte {
+	var msg []byte
+	msg = append(msg, []byte(strconv.Itoa(i))...)
+	msg = append(msg, salt...)
+	msg = append(msg, e.ChainID[:]...)
+	msg = append(msg, e.Content...)
+	return sha512.Sum512(msg)
+}
+
+// RCDAddress is the FA address of the RCD
+func RCDAddress(rcd []byte) factom.FAAddress {
+	first := sha256.Sum256(rcd)
+	return factom.FAAddress(sha256.Sum256(first[:]))
+}
@@ -0,0 +1,115 @@
+package payout_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	. "github.com/FactomWyomingEntity/prosper-pool/payout"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	userA = "FA2jK2HcLnRdS94dEcU27rF3meoJfpUcZPSinpb7AwQvPRY6RL1Q"
+	userB = "FA3EPZYqodgyEGXNMbiZKE5TS2x2J9wF8J9MvPZb52iGR78xMgCb"
+)
+
+func TestTransactionBatch_Sign(t *testing.T) {
+	require := require.New(t)
+	key, err := factom.GenerateFsAddress()
+	require.NoError(err)
+
+	payments := []accounting.Paid{
+		{UserID: "a", PayoutAddress: userA, PaymentAmount: 10e8},
+		{UserID: "b", PayoutAddress: userA, PaymentAmount: 4e8},
+		{UserID: "b", PayoutAddress: userB, PaymentAmount: 6e8},
+	}
+	batch, err := NewBatch(payments, key.FAAddress())
+	require.NoError(err)
+	require.Len(batch.Transactions, 2)
+	require.Len(batch.Transactions[1].Transfers, 2)
+	require.Equal(uint64(10e8), batch.Transactions[1].Input.Amount)
+	require.Equal(uint64(20e8), batch.Total())
+
+	require.NoError(batch.MarshalEntry())
+	require.NoError(batch.Sign(key))
+	// One signature for the batch, whatever the number of users
+	require.Len(batch.Entry.ExtIDs, 3)
+	require.NoError(batch.Verify())
+	require.NoError(fat2Validate(batch.Entry, time.Now()))
+	require.Error(fat2Validate(batch.Entry, time.Now().Add(13*time.Hour)))
+
+	var parsed TransactionBatch
+	require.NoError(parsed.UnmarshalEntry(batch.Entry))
+	require.NoError(parsed.Verify())
+	require.Equal(batch.Total(), parsed.Total())
+
+	// A signature for every transaction is rejected by pegnet
+	extra := batch.Entry
+	extra.ExtIDs = append(extra.ExtIDs, extra.ExtIDs[1], extra.ExtIDs[2])
+	require.Error(fat2Validate(extra, time.Now()))
+	require.Error((&TransactionBatch{Transactions: batch.Transactions, Entry: extra}).Verify())
+
+	// Changing the content breaks the signature
+	batch.Entry.Content[len(batch.Entry.Content)-2] ^= 1
+	require.Error(batch.Verify())
+	require.Error(fat2Validate(batch.Entry, time.Now()))
+
+	// Only the source can sign
+	other, err := factom.GenerateFsAddress()
+	require.NoError(err)
+	require.Error(batch.Sign(other))
+
+	_, err = NewBatch([]accounting.Paid{{UserID: "a", PayoutAddress: "FA-a", PaymentAmount: 1}}, key.FAAddress())
+	require.Error(err)
+}
+
+func TestNewBatches(t *testing.T) {
+	require := require.New(t)
+	key, err := factom.GenerateFsAddress()
+	require.NoError(err)
+
+	// Enough users to overflow a single entry, some with split payouts
+	var payments []accounting.Paid
+	var total uint64
+	for i := 0; i < 300; i++ {
+		user := fmt.Sprintf("user%d@gmail.com", i)
+		payments = append(payments, accounting.Paid{UserID: user, PayoutAddress: userA, PaymentAmount: math.MaxInt64 / 1000})
+		total += math.MaxInt64 / 1000
+		if i%3 == 0 {
+			payments = append(payments, accounting.Paid{UserID: user, PayoutAddress: userB, PaymentAmount: 1})
+			total++
+		}
+	}
+
+	n := accounting.AssignBatches(payments)
+	require.True(n > 1, "expected many batches, found %d", n)
+
+	batches, err := NewBatches(payments, key.FAAddress())
+	require.NoError(err)
+	require.Len(batches, n)
+
+	var sum uint64
+	var count int
+	for _, batch := range batches {
+		require.NoError(batch.MarshalEntry())
+		require.NoError(batch.Sign(key))
+		require.NoError(fat2Validate(batch.Entry, time.Now()))
+		_, err := batch.Entry.Cost()
+		require.NoError(err)
+		// The batches fit, and are well filled
+		size := batch.Entry.MarshalBinaryLen()
+		require.True(size <= accounting.MaxBatchSize, "batch of %d bytes", size)
+		require.True(size > accounting.MaxBatchSize*9/10 || batch == batches[len(batches)-1], "batch of %d bytes", size)
+		sum += batch.Total()
+		count += len(batch.Payments)
+	}
+	require.Equal(total, sum)
+	require.Equal(len(payments), count)
+
+	// A user is paid in a single batch
+	require.Equal(payments[0].Batch, payments[1].Batch)
+}
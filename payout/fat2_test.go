@@ -0,0 +1,89 @@
+package payout_test
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/factom"
+)
+
+// fat2Validate is pegnetd's fat2.TransactionBatch.Validate, with fatd's
+// fat.Entry.ValidExtIDs. fat2 cannot be imported, as it builds against an
+// older factom library, so the checks are copied here. The entry is taken to
+// land on chain at the given time.
+func fat2Validate(e factom.Entry, landed time.Time) error {
+	var batch struct {
+		Version      uint `json:"version"`
+		Transactions []struct {
+			Input struct {
+				Address factom.FAAddress `json:"address"`
+			} `json:"input"`
+		} `json:"transactions"`
+	}
+	if err := json.Unmarshal(e.Content, &batch); err != nil {
+		return err
+	}
+
+	// ValidData
+	if batch.Version != 1 {
+		return fmt.Errorf("invalid version")
+	}
+	if len(batch.Transactions) == 0 {
+		return fmt.Errorf("at least one output required")
+	}
+	uniqueInputs := make(map[factom.FAAddress]struct{})
+	for _, tx := range batch.Transactions {
+		uniqueInputs[tx.Input.Address] = struct{}{}
+	}
+	if len(uniqueInputs) != 1 {
+		return fmt.Errorf("only one input address allowed")
+	}
+
+	// ValidExtIDs
+	if len(e.ExtIDs) != 2*len(uniqueInputs)+1 {
+		return fmt.Errorf("invalid number of ExtIDs")
+	}
+	sec, err := strconv.ParseInt(string(e.ExtIDs[0]), 10, 64)
+	if err != nil {
+		return fmt.Errorf("timestamp salt: %v", err)
+	}
+	if diff := landed.Sub(time.Unix(sec, 0)); -12*time.Hour > diff || diff > 12*time.Hour {
+		return fmt.Errorf("timestamp salt expired")
+	}
+	numRcdSigPairs := len(e.ExtIDs) / 2
+	included := make(map[factom.FAAddress]struct{})
+	for i := 0; i < numRcdSigPairs; i++ {
+		rcd, sig := e.ExtIDs[1+2*i], e.ExtIDs[2+2*i]
+		if len(rcd) != 33 || rcd[0] != 0x01 {
+			return fmt.Errorf("ExtIDs[%v]: invalid RCD", i+1)
+		}
+		if len(sig) != ed25519.SignatureSize {
+			return fmt.Errorf("ExtIDs[%v]: invalid signature size", i+1)
+		}
+
+		// validSignatures
+		var msg []byte
+		msg = append(msg, strconv.Itoa(i)...)
+		msg = append(msg, e.ExtIDs[0]...)
+		msg = append(msg, e.ChainID[:]...)
+		msg = append(msg, e.Content...)
+		hash := sha512.Sum512(msg)
+		if !ed25519.Verify(ed25519.PublicKey(rcd[1:]), hash[:], sig) {
+			return fmt.Errorf("ExtIDs[%v]: invalid signature", 2*i+2)
+		}
+
+		first := sha256.Sum256(rcd)
+		included[factom.FAAddress(sha256.Sum256(first[:]))] = struct{}{}
+	}
+	for address := range uniqueInputs {
+		if _, ok := included[address]; !ok {
+			return fmt.Errorf("invalid RCDs")
+		}
+	}
+	return nil
+}
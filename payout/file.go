@@ -0,0 +1,237 @@
+package payout
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+)
+
+// SaltWindow is how far the signing time of a batch can be from the time its
+// entry lands on chain. Outside of the window pegnet rejects the batch, so a
+// signed file must be broadcast well within it.
+const SaltWindow = 12 * time.Hour
+
+// SaltMargin is the time left in the window that broadcast refuses below, to
+// leave time for the entry to land in a block.
+const SaltMargin = time.Hour
+
+// BatchFile is a payout passed between the build, sign and broadcast steps
+// of the payout-cli, so the key can be kept on an offline machine. Each step
+// rebuilds the batches from the payments, and refuses the file if anything
+// does not match.
+type BatchFile struct {
+	ChainID  factom.Bytes32    `json:"chainid"`
+	Source   factom.FAAddress  `json:"source"`
+	Total    uint64            `json:"total"`
+	Payments []accounting.Paid `json:"payments"`
+	Entries  []BatchEntry      `json:"entries"`
+}
+
+// BatchEntry is the entry of a batch. The extids are set once signed.
+type BatchEntry struct {
+	ExtIDs  []factom.Bytes `json:"extids,omitempty"`
+	Content factom.Bytes   `json:"content"`
+}
+
+// NewBatchFile builds the unsigned batches of the payments
+func NewBatchFile(payments []accounting.Paid, source factom.FAAddress) (*BatchFile, error) {
+	batches, err := NewBatches(payments, source)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &BatchFile{
+		ChainID:  factom.Bytes32(config.TransactionChain),
+		Source:   source,
+		Payments: payments,
+	}
+	for i, batch := range batches {
+		if err := batch.MarshalEntry(); err != nil {
+			return nil, fmt.Errorf("failed to marshal tx: %s", err.Error())
+		}
+		if _, err := batch.Entry.Cost(); err != nil {
+			return nil, fmt.Errorf("error with batch %d: %s", i+1, err.Error())
+		}
+		f.Total += batch.Total()
+		f.Entries = append(f.Entries, BatchEntry{Content: batch.Entry.Content})
+	}
+	return f, nil
+}
+
+// ReadBatchFile reads and validates the batch file
+func ReadBatchFile(path string) (*BatchFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := new(BatchFile)
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("unable to parse batch file: %s", err.Error())
+	}
+	if _, err := f.Batches(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write saves the batch file to a new file
+func (f *BatchFile) Write(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(data)
+	return err
+}
+
+// Batches validates the file and returns its batches. The batches must match
+// the ones built from the payments, on the pegnet transaction chain, with the
+// same total. If the file is signed, every signature must be valid.
+func (f *BatchFile) Batches() ([]*TransactionBatch, error) {
+	if !bytes.Equal(f.ChainID[:], config.TransactionChain[:]) {
+		return nil, fmt.Errorf("chainid %s is not the pegnet transaction chain", f.ChainID)
+	}
+
+	var total uint64
+	for _, p := range f.Payments {
+		if p.PaymentAmount <= 0 {
+			return nil, fmt.Errorf("%s has a payment of %d", p.UserID, p.PaymentAmount)
+		}
+		total += uint64(p.PaymentAmount)
+	}
+	if total != f.Total {
+		return nil, fmt.Errorf("payments total %d, but the file total is %d", total, f.Total)
+	}
+
+	batches, err := NewBatches(f.Payments, f.Source)
+	if err != nil {
+		return nil, err
+	}
+	if len(batches) != len(f.Entries) {
+		return nil, fmt.Errorf("expected %d batches, found %d", len(batches), len(f.Entries))
+	}
+
+	signed := f.Signed()
+	for i, batch := range batches {
+		if err := batch.MarshalEntry(); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(batch.Entry.Content, f.Entries[i].Content) {
+			return nil, fmt.Errorf("batch %d does not match the payments", i+1)
+		}
+
+		if len(f.Entries[i].ExtIDs) > 0 != signed {
+			return nil, fmt.Errorf("batch %d is not signed like the others", i+1)
+		}
+		if signed {
+			batch.Entry.ExtIDs = f.Entries[i].ExtIDs
+			if err := batch.Verify(); err != nil {
+				return nil, fmt.Errorf("batch %d: %s", i+1, err.Error())
+			}
+		}
+		if _, err := batch.Entry.Cost(); err != nil {
+			return nil, fmt.Errorf("error with batch %d: %s", i+1, err.Error())
+		}
+	}
+	return batches, nil
+}
+
+// Signed is true if the batches are signed
+func (f *BatchFile) Signed() bool {
+	return len(f.Entries) > 0 && len(f.Entries[0].ExtIDs) > 0
+}
+
+// Sign validates the unsigned batches, and signs them with the key
+func (f *BatchFile) Sign(key factom.FsAddress) error {
+	if f.Signed() {
+		return fmt.Errorf("batches are already signed")
+	}
+	if key.FAAddress() != f.Source {
+		return fmt.Errorf("key does not match the source %s", f.Source)
+	}
+
+	batches, err := f.Batches()
+	if err != nil {
+		return err
+	}
+
+	for i, batch := range batches {
+		if err := batch.Sign(key); err != nil {
+			return err
+		}
+		if _, err := batch.Entry.Cost(); err != nil {
+			return fmt.Errorf("error with batch %d: %s", i+1, err.Error())
+		}
+		f.Entries[i].ExtIDs = batch.Entry.ExtIDs
+	}
+	return nil
+}
+
+// SignedAt is the earliest signing time of the batches
+func (f *BatchFile) SignedAt() (time.Time, error) {
+	if !f.Signed() {
+		return time.Time{}, fmt.Errorf("batches are not signed")
+	}
+
+	var signed time.Time
+	for i, e := range f.Entries {
+		if len(e.ExtIDs) == 0 {
+			return time.Time{}, fmt.Errorf("batch %d is not signed", i+1)
+		}
+		at, err := SaltTime(e.ExtIDs[0])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("batch %d: %s", i+1, err.Error())
+		}
+		if i == 0 || at.Before(signed) {
+			signed = at
+		}
+	}
+	return signed, nil
+}
+
+// CheckSaltAge returns an error if the signed batches can no longer land on
+// chain within the salt window, with the margin to spare. It returns how much
+// of the window is left.
+func (f *BatchFile) CheckSaltAge(now time.Time) (time.Duration, error) {
+	signed, err := f.SignedAt()
+	if err != nil {
+		return 0, err
+	}
+
+	if signed.After(now.Add(SaltWindow)) {
+		return 0, fmt.Errorf("batches are signed at %s, more than %s in the future", signed.UTC(), SaltWindow)
+	}
+	left := signed.Add(SaltWindow).Sub(now)
+	if left < SaltMargin {
+		return left, fmt.Errorf("batches were signed at %s, and pegnet rejects them %s after signing. Sign them again",
+			signed.UTC(), SaltWindow)
+	}
+	return left, nil
+}
+
+// Digest is a hash of the batch contents, so the operator can check the
+// batches signed are the batches built.
+func (f *BatchFile) Digest() string {
+	h := sha256.New()
+	for _, e := range f.Entries {
+		h.Write(e.Content)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,96 @@
+package payout_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	. "github.com/FactomWyomingEntity/prosper-pool/payout"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchFile(t *testing.T) {
+	require := require.New(t)
+	key, err := factom.GenerateFsAddress()
+	require.NoError(err)
+	dir, err := ioutil.TempDir("", "prosper-payout")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	payments := []accounting.Paid{
+		{UserID: "a", PayoutAddress: userA, PaymentAmount: 10e8},
+		{UserID: "b", PayoutAddress: userB, PaymentAmount: 6e8, Batch: 1},
+	}
+	built, err := NewBatchFile(payments, key.FAAddress())
+	require.NoError(err)
+	require.Equal(uint64(16e8), built.Total)
+	require.Len(built.Entries, 2)
+	require.False(built.Signed())
+
+	unsigned := filepath.Join(dir, "unsigned.json")
+	require.NoError(built.Write(unsigned))
+	require.Error(built.Write(unsigned), "must be a new file")
+
+	f, err := ReadBatchFile(unsigned)
+	require.NoError(err)
+	require.Equal(built.Digest(), f.Digest())
+
+	other, err := factom.GenerateFsAddress()
+	require.NoError(err)
+	require.Error(f.Sign(other))
+	require.NoError(f.Sign(key))
+	require.True(f.Signed())
+	require.Error(f.Sign(key), "already signed")
+
+	signed := filepath.Join(dir, "signed.json")
+	require.NoError(f.Write(signed))
+	f, err = ReadBatchFile(signed)
+	require.NoError(err)
+	batches, err := f.Batches()
+	require.NoError(err)
+	require.Len(batches, 2)
+	require.Equal(built.Digest(), f.Digest())
+
+	// The signatures are only good for the salt window
+	_, err = built.CheckSaltAge(time.Now())
+	require.Error(err, "not signed")
+	left, err := f.CheckSaltAge(time.Now())
+	require.NoError(err)
+	require.True(left > SaltWindow-time.Minute)
+	_, err = f.CheckSaltAge(time.Now().Add(SaltWindow - SaltMargin/2))
+	require.Error(err)
+	_, err = f.CheckSaltAge(time.Now().Add(-SaltWindow - time.Hour))
+	require.Error(err)
+
+	tampered := func(change func(f *BatchFile)) error {
+		data, err := json.Marshal(f)
+		require.NoError(err)
+		var c BatchFile
+		require.NoError(json.Unmarshal(data, &c))
+		change(&c)
+		_, err = c.Batches()
+		return err
+	}
+	require.NoError(tampered(func(f *BatchFile) {}))
+	require.Error(tampered(func(f *BatchFile) { f.Payments[0].PaymentAmount++ }))
+	require.Error(tampered(func(f *BatchFile) { f.Total++ }))
+	require.Error(tampered(func(f *BatchFile) { f.Payments[1].PayoutAddress = userA }))
+	require.Error(tampered(func(f *BatchFile) { f.ChainID[0]++ }))
+	require.Error(tampered(func(f *BatchFile) { f.Entries[0].Content[10]++ }))
+	require.Error(tampered(func(f *BatchFile) { f.Entries[1].ExtIDs[2][0]++ }))
+	require.Error(tampered(func(f *BatchFile) { f.Entries[1].ExtIDs = nil }))
+	require.Error(tampered(func(f *BatchFile) { f.Entries = f.Entries[:1] }))
+	// Changing the payments and content together breaks the signature
+	require.Error(tampered(func(f *BatchFile) {
+		f.Payments[0].PaymentAmount++
+		f.Total++
+		c, err := NewBatchFile(f.Payments, f.Source)
+		require.NoError(err)
+		f.Entries[0].Content = c.Entries[0].Content
+	}))
+}
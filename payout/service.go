@@ -0,0 +1,246 @@
+package payout
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/factomclient"
+	"github.com/FactomWyomingEntity/prosper-pool/treasury"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	payLog = log.WithField("mod", "payout")
+)
+
+// KeyStore returns the private key for the payout source address
+type KeyStore interface {
+	GetFsAddress(ctx context.Context, adr factom.FAAddress) (factom.FsAddress, error)
+}
+
+// EntrySubmitter pays for and submits the signed entry
+type EntrySubmitter interface {
+	SubmitEntry(ctx context.Context, e *factom.Entry) error
+}
+
+// StatusProvider reports the execution of a pegnet transaction
+type StatusProvider interface {
+	// TransactionStatus returns if the transaction is executed. An error of
+	// accounting.ErrPayoutFailed means the transaction will never execute.
+	TransactionStatus(ctx context.Context, entryhash string) (bool, error)
+}
+
+// Service pays the users from within the pool. It builds the FAT-2 batch from
+// the payments, signs it with the source address, and submits it. It acts as
+// the signer and confirmer of the payout scheduler, so the payments are only
+// recorded once pegnetd has executed the transaction.
+type Service struct {
+	Source    factom.FAAddress
+	Keys      KeyStore
+	Submitter EntrySubmitter
+	Status    StatusProvider
+	// Treasury checks the source can cover the payout, if set
+	Treasury *treasury.Treasury
+}
+
+func NewService(conf *viper.Viper) (*Service, error) {
+	s := new(Service)
+	var err error
+	s.Source, err = factom.NewFAAddress(conf.GetString(config.ConfigPayoutSource))
+	if err != nil {
+		return nil, fmt.Errorf("payout source address: %s", err.Error())
+	}
+
+	cl := factomclient.FactomClientFromConfig(conf)
+	if keyfile := conf.GetString(config.ConfigPayoutKeyFile); keyfile != "" {
+		keys, err := LoadKeyFile(keyfile)
+		if err != nil {
+			return nil, err
+		}
+		s.Keys = keys
+	} else {
+		s.Keys = WalletdKeys{Client: cl}
+	}
+
+	es, err := factom.NewEsAddress(conf.GetString(config.ConfigPoolESAddress))
+	if err != nil {
+		return nil, fmt.Errorf("config entry credit address failed: %s", err.Error())
+	}
+	s.Submitter = FactomdSubmitter{Client: cl, ESAddress: es}
+	s.Status = PegnetdStatus{Client: cl, Location: conf.GetString(config.ConfigPegnetdLocation)}
+	s.Treasury = &treasury.Treasury{
+		Address: s.Source,
+		Source:  treasury.PegnetdBalances{Client: cl, Location: conf.GetString(config.ConfigPegnetdLocation)},
+	}
+	return s, nil
+}
+
+// Submit builds, signs and submits a batch for each batch of the payments.
+// It returns the receipt of the submitted batches. If a batch fails, the
+// batches before it are still in the receipt.
+func (s *Service) Submit(ctx context.Context, payments []accounting.Paid) ([]accounting.Paid, error) {
+	return s.SubmitRecorded(ctx, payments, nil)
+}
+
+// SubmitRecorded submits the payments as Submit does, but first hands each
+// signed batch to record, if set. A batch is not submitted if it cannot be
+// recorded. Factomd can take an entry and still return an error, so a
+// recorded batch missing from the receipt may be on chain.
+func (s *Service) SubmitRecorded(ctx context.Context, payments []accounting.Paid, record func(batch []accounting.Paid) error) ([]accounting.Paid, error) {
+	batches, err := NewBatches(payments, s.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.Keys.GetFsAddress(ctx, s.Source)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get private key: %s", err.Error())
+	}
+
+	var receipt []accounting.Paid
+	for i, batch := range batches {
+		if err := s.sign(batch, key); err != nil {
+			return receipt, fmt.Errorf("batch %d of %d: %s", i+1, len(batches), err.Error())
+		}
+		if record != nil {
+			if err := record(batch.Receipt()); err != nil {
+				return receipt, fmt.Errorf("batch %d of %d not recorded: %s", i+1, len(batches), err.Error())
+			}
+		}
+		if err := s.Submitter.SubmitEntry(ctx, &batch.Entry); err != nil {
+			return receipt, fmt.Errorf("batch %d of %d: unable to submit entry: %s", i+1, len(batches), err.Error())
+		}
+		receipt = append(receipt, batch.Receipt()...)
+		payLog.WithFields(log.Fields{"entryhash": batch.Entry.Hash.String(), "txs": len(batch.Transactions), "batch": i + 1}).
+			Info("payout batch submitted")
+	}
+	return receipt, nil
+}
+
+// CheckBalance returns the PEG balance of the source, and
+// treasury.ErrInsufficientBalance if it cannot cover the total.
+func (s *Service) CheckBalance(ctx context.Context, total int64) (int64, error) {
+	if s.Treasury == nil {
+		return 0, fmt.Errorf("no treasury for %s", s.Source)
+	}
+	return s.Treasury.Check(ctx, total)
+}
+
+// sign signs the batch with the key, and sets the hash of it's entry
+func (s *Service) sign(batch *TransactionBatch, key factom.FsAddress) error {
+	if err := batch.MarshalEntry(); err != nil {
+		return fmt.Errorf("failed to marshal tx: %s", err.Error())
+	}
+
+	if err := batch.Sign(key); err != nil {
+		return err
+	}
+
+	// The signatures add to the size, so check the cost after signing
+	if _, err := batch.Entry.Cost(); err != nil {
+		return fmt.Errorf("error with entry: %s", err.Error())
+	}
+
+	data, err := batch.Entry.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to marshal entry: %s", err.Error())
+	}
+	hash := factom.ComputeEntryHash(data)
+	batch.Entry.Hash = &hash
+	return nil
+}
+
+// Confirmed reports if pegnetd executed the batch
+func (s *Service) Confirmed(ctx context.Context, entryhash string) (bool, error) {
+	return s.Status.TransactionStatus(ctx, entryhash)
+}
+
+// WalletdKeys gets the keys from factom-walletd
+type WalletdKeys struct {
+	Client *factom.Client
+}
+
+func (w WalletdKeys) GetFsAddress(ctx context.Context, adr factom.FAAddress) (factom.FsAddress, error) {
+	return adr.GetFsAddress(ctx, w.Client)
+}
+
+// StaticKeys holds the keys in memory
+type StaticKeys []factom.FsAddress
+
+func (k StaticKeys) GetFsAddress(_ context.Context, adr factom.FAAddress) (factom.FsAddress, error) {
+	for _, key := range k {
+		if key.FAAddress() == adr {
+			return key, nil
+		}
+	}
+	return factom.FsAddress{}, fmt.Errorf("no key for %s", adr)
+}
+
+// LoadKeyFile reads the Fs addresses in the file, one per line
+func LoadKeyFile(path string) (StaticKeys, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("key file: %s", err.Error())
+	}
+
+	var keys StaticKeys
+	for _, line := range strings.Fields(string(data)) {
+		key, err := factom.NewFsAddress(line)
+		if err != nil {
+			return nil, fmt.Errorf("key file: %s", err.Error())
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// FactomdSubmitter pays for the entry with the EC address and submits it to
+// factomd.
+type FactomdSubmitter struct {
+	Client    *factom.Client
+	ESAddress factom.EsAddress
+}
+
+func (f FactomdSubmitter) SubmitEntry(ctx context.Context, e *factom.Entry) error {
+	_, err := e.ComposeCreate(ctx, f.Client, f.ESAddress)
+	return err
+}
+
+// PegnetdStatus asks pegnetd for the transaction status
+type PegnetdStatus struct {
+	Client   *factom.Client
+	Location string
+}
+
+func (p PegnetdStatus) TransactionStatus(ctx context.Context, entryhash string) (bool, error) {
+	var result struct {
+		Height   uint32 `json:"height"`
+		Executed int32  `json:"executed"`
+	}
+	params := struct {
+		Hash string `json:"entryhash"`
+	}{Hash: entryhash}
+
+	err := p.Client.Factomd.Request(ctx, p.Location, "get-transaction-status", params, &result)
+	if err != nil {
+		// Not yet synced by pegnetd
+		if strings.Contains(err.Error(), "Not Found") {
+			return false, nil
+		}
+		return false, err
+	}
+
+	switch {
+	case result.Executed == -1:
+		return false, accounting.ErrPayoutFailed
+	case result.Executed > 0:
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,253 @@
+package payout_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/authentication"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	. "github.com/FactomWyomingEntity/prosper-pool/payout"
+	"github.com/FactomWyomingEntity/prosper-pool/treasury"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService(t *testing.T) {
+	require := require.New(t)
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(err)
+	defer db.Close()
+
+	conf := viper.New()
+	config.SetDefaults(conf)
+	a, err := accounting.NewAccountant(conf, db)
+	require.NoError(err)
+	db.AutoMigrate(&authentication.User{})
+
+	require.NoError(db.Create(&authentication.User{UID: "a@gmail.com", PayoutAddress: userA}).Error)
+	require.NoError(db.Create(&authentication.User{UID: "b@gmail.com", PayoutAddress: userB}).Error)
+	require.NoError(accounting.WriteOwedPayouts(db, &accounting.OwedPayouts{Reward: accounting.Reward{JobID: 1}, UserPayouts: []accounting.UserOwedPayouts{
+		{JobID: 1, UserID: "a@gmail.com", Payout: 20e8},
+		{JobID: 1, UserID: "b@gmail.com", Payout: 5e8},
+	}}))
+
+	key, err := factom.GenerateFsAddress()
+	require.NoError(err)
+	submitter := new(testSubmitter)
+	status := &testStatus{executed: make(map[string]int)}
+	service := &Service{
+		Source:    key.FAAddress(),
+		Keys:      StaticKeys{key},
+		Submitter: submitter,
+		Status:    status,
+	}
+
+	s := &accounting.PayoutScheduler{Accountant: a, Signer: service, Confirmer: service, Interval: time.Hour}
+	s.SetVerifier(&ChainVerifier{Source: key.FAAddress(), Entries: submitter, Status: status})
+	run, err := s.Payout(context.Background())
+	require.NoError(err)
+	require.Equal(accounting.RunSubmitted, run.State)
+	require.Len(submitter.entries, 1)
+	require.Equal(submitter.entries[0].Hash.String(), run.EntryHash)
+
+	var batch TransactionBatch
+	require.NoError(batch.UnmarshalEntry(submitter.entries[0]))
+	require.NoError(batch.Verify())
+	require.Equal(uint64(25e8), batch.Total())
+
+	// Nothing is paid until pegnetd executes the batch
+	n, err := s.ConfirmRuns(context.Background())
+	require.NoError(err)
+	require.Zero(n)
+	var count int
+	require.NoError(db.Model(&accounting.Paid{}).Count(&count).Error)
+	require.Zero(count)
+
+	status.executed[run.EntryHash] = 1
+	n, err = s.ConfirmRuns(context.Background())
+	require.NoError(err)
+	require.Equal(1, n)
+	require.NoError(db.Model(&accounting.Paid{}).Where("entry_hash = ?", run.EntryHash).Count(&count).Error)
+	require.Equal(2, count)
+	require.NoError(db.First(run, run.ID).Error)
+	require.Equal(accounting.RunConfirmed, run.State)
+
+	// A rejected batch fails the run, and the balance is paid next time
+	require.NoError(accounting.WriteOwedPayouts(db, &accounting.OwedPayouts{Reward: accounting.Reward{JobID: 2}, UserPayouts: []accounting.UserOwedPayouts{
+		{JobID: 2, UserID: "a@gmail.com", Payout: 5e8},
+	}}))
+	run, err = s.Payout(context.Background())
+	require.NoError(err)
+	status.executed[run.EntryHash] = -1
+	n, err = s.ConfirmRuns(context.Background())
+	require.NoError(err)
+	require.Zero(n)
+	require.NoError(db.First(run, run.ID).Error)
+	require.Equal(accounting.RunFailed, run.State)
+
+	run, err = s.Payout(context.Background())
+	require.NoError(err)
+	require.Equal(accounting.RunSubmitted, run.State)
+	require.Equal(int64(5e8), run.Total)
+}
+
+func TestService_Treasury(t *testing.T) {
+	require := require.New(t)
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(err)
+	defer db.Close()
+
+	conf := viper.New()
+	config.SetDefaults(conf)
+	a, err := accounting.NewAccountant(conf, db)
+	require.NoError(err)
+	db.AutoMigrate(&authentication.User{})
+
+	require.NoError(db.Create(&authentication.User{UID: "a@gmail.com", PayoutAddress: userA}).Error)
+	require.NoError(db.Create(&authentication.User{UID: "b@gmail.com", PayoutAddress: userB}).Error)
+	require.NoError(accounting.WriteOwedPayouts(db, &accounting.OwedPayouts{Reward: accounting.Reward{JobID: 1}, UserPayouts: []accounting.UserOwedPayouts{
+		{JobID: 1, UserID: "a@gmail.com", Payout: 8e8},
+		{JobID: 1, UserID: "b@gmail.com", Payout: 3e8},
+	}}))
+
+	key, err := factom.GenerateFsAddress()
+	require.NoError(err)
+	submitter := new(testSubmitter)
+	balances := treasury.StaticBalances{key.FAAddress().String(): {"PEG": 10e8}}
+	service := &Service{
+		Source:    key.FAAddress(),
+		Keys:      StaticKeys{key},
+		Submitter: submitter,
+		Treasury:  &treasury.Treasury{Address: key.FAAddress(), Source: balances},
+	}
+	s := &accounting.PayoutScheduler{Accountant: a, Signer: service, Interval: time.Hour}
+
+	// The source cannot cover the run
+	run, err := s.Payout(context.Background())
+	require.NoError(err)
+	require.Equal(accounting.RunFailed, run.State)
+	require.Contains(run.Error, "only holds 10 PEG")
+	require.Empty(submitter.entries)
+
+	// The balance cannot be checked, so the run notes it was not
+	delete(balances, key.FAAddress().String())
+	run, err = s.Payout(context.Background())
+	require.NoError(err)
+	require.Equal(accounting.RunSubmitted, run.State)
+	require.Contains(run.Error, "not checked")
+	require.Len(submitter.entries, 1)
+	require.NoError(db.Model(run).Update("state", accounting.RunFailed).Error)
+
+	balances[key.FAAddress().String()] = map[string]int64{"PEG": 11e8}
+	run, err = s.Payout(context.Background())
+	require.NoError(err)
+	require.Equal(accounting.RunSubmitted, run.State)
+	require.Empty(run.Error)
+	require.Len(submitter.entries, 2)
+}
+
+func TestService_LostResponse(t *testing.T) {
+	require := require.New(t)
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(err)
+	defer db.Close()
+
+	conf := viper.New()
+	config.SetDefaults(conf)
+	a, err := accounting.NewAccountant(conf, db)
+	require.NoError(err)
+	db.AutoMigrate(&authentication.User{})
+
+	require.NoError(db.Create(&authentication.User{UID: "a@gmail.com", PayoutAddress: userA}).Error)
+	require.NoError(accounting.WriteOwedPayouts(db, &accounting.OwedPayouts{Reward: accounting.Reward{JobID: 1}, UserPayouts: []accounting.UserOwedPayouts{
+		{JobID: 1, UserID: "a@gmail.com", Payout: 20e8},
+	}}))
+
+	key, err := factom.GenerateFsAddress()
+	require.NoError(err)
+	submitter := &testSubmitter{lost: true}
+	status := &testStatus{executed: make(map[string]int)}
+	service := &Service{
+		Source:    key.FAAddress(),
+		Keys:      StaticKeys{key},
+		Submitter: submitter,
+		Status:    status,
+		// No balances, so the run notes the balance was not checked
+		Treasury: &treasury.Treasury{Address: key.FAAddress(), Source: treasury.StaticBalances{}},
+	}
+	s := &accounting.PayoutScheduler{Accountant: a, Signer: service, Confirmer: service, Interval: time.Hour}
+
+	// The entry landed, so the run waits on the confirmer instead of failing
+	run, err := s.Payout(context.Background())
+	require.NoError(err)
+	require.Equal(accounting.RunSubmitted, run.State)
+	require.Len(submitter.entries, 1)
+	require.Equal(submitter.entries[0].Hash.String(), run.EntryHash)
+	require.Contains(run.Error, "not checked")
+	require.Contains(run.Error, "connection reset")
+
+	// Nothing is paid again while the run is pending
+	_, err = s.Payout(context.Background())
+	require.Error(err)
+
+	status.executed[run.EntryHash] = 1
+	n, err := s.ConfirmRuns(context.Background())
+	require.NoError(err)
+	require.Equal(1, n)
+	var count int
+	require.NoError(db.Model(&accounting.Paid{}).Where("entry_hash = ?", run.EntryHash).Count(&count).Error)
+	require.Equal(1, count)
+}
+
+type testSubmitter struct {
+	entries []factom.Entry
+	// lost lands the entries, but returns an error as a lost response would
+	lost bool
+}
+
+func (s *testSubmitter) SubmitEntry(ctx context.Context, e *factom.Entry) error {
+	// Composing sets the entry hash, as factomd would
+	es, err := factom.GenerateEsAddress()
+	if err != nil {
+		return err
+	}
+	if _, _, _, err := e.Compose(es); err != nil {
+		return err
+	}
+	s.entries = append(s.entries, *e)
+	if s.lost {
+		return fmt.Errorf("connection reset")
+	}
+	return nil
+}
+
+// GetEntry returns the submitted entries, as factomd would
+func (s *testSubmitter) GetEntry(ctx context.Context, entryhash string) (factom.Entry, error) {
+	for _, e := range s.entries {
+		if e.Hash.String() == entryhash {
+			return e, nil
+		}
+	}
+	return factom.Entry{}, fmt.Errorf("entry not found")
+}
+
+// testStatus stands in for pegnetd
+type testStatus struct {
+	executed map[string]int
+}
+
+func (s *testStatus) TransactionStatus(ctx context.Context, entryhash string) (bool, error) {
+	switch s.executed[entryhash] {
+	case -1:
+		return false, accounting.ErrPayoutFailed
+	case 0:
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,158 @@
+package payout
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/factomclient"
+	"github.com/FactomWyomingEntity/prosper-pool/treasury"
+	"github.com/spf13/viper"
+)
+
+// EntryFetcher gets an entry from the chain by it's hash
+type EntryFetcher interface {
+	GetEntry(ctx context.Context, entryhash string) (factom.Entry, error)
+}
+
+// ChainVerifier checks the payments of a receipt were applied on chain. Each
+// entry of the receipt must be a signed batch on the pegnet transaction chain
+// that pays exactly the receipt's payments, and must be executed by pegnetd.
+type ChainVerifier struct {
+	// Source is the only address the pool pays from
+	Source  factom.FAAddress
+	Entries EntryFetcher
+	Status  StatusProvider
+}
+
+func NewChainVerifier(conf *viper.Viper) (*ChainVerifier, error) {
+	source, err := treasury.SourceAddress(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	cl := factomclient.FactomClientFromConfig(conf)
+	return &ChainVerifier{
+		Source:  source,
+		Entries: FactomdEntries{Client: cl},
+		Status:  PegnetdStatus{Client: cl, Location: conf.GetString(config.ConfigPegnetdLocation)},
+	}, nil
+}
+
+// Confirmed reports if pegnetd executed the transaction, so payouts signed
+// outside the pool are confirmed the same as the pool's own.
+func (v *ChainVerifier) Confirmed(ctx context.Context, entryhash string) (bool, error) {
+	return v.Status.TransactionStatus(ctx, entryhash)
+}
+
+// VerifyReceipt returns an error if any entry of the receipt is not
+// confirmed, or does not match the payments.
+func (v *ChainVerifier) VerifyReceipt(ctx context.Context, payments []accounting.Paid) error {
+	var order []string
+	entries := make(map[string][]accounting.Paid)
+	for _, p := range payments {
+		if p.EntryHash == "" {
+			return fmt.Errorf("this is not a receipt, no entryhash")
+		}
+		if _, ok := entries[p.EntryHash]; !ok {
+			order = append(order, p.EntryHash)
+		}
+		entries[p.EntryHash] = append(entries[p.EntryHash], p)
+	}
+
+	for _, hash := range order {
+		if err := v.verifyEntry(ctx, hash, entries[hash]); err != nil {
+			return fmt.Errorf("entry %s: %s", hash, err.Error())
+		}
+	}
+	return nil
+}
+
+func (v *ChainVerifier) verifyEntry(ctx context.Context, entryhash string, payments []accounting.Paid) error {
+	e, err := v.Entries.GetEntry(ctx, entryhash)
+	if err != nil {
+		return fmt.Errorf("not found on chain: %s", err.Error())
+	}
+
+	var batch TransactionBatch
+	if err := batch.UnmarshalEntry(e); err != nil {
+		return err
+	}
+	if len(batch.Transactions) == 0 {
+		return fmt.Errorf("batch has no transactions")
+	}
+	if err := batch.Verify(); err != nil {
+		return err
+	}
+
+	// Only a batch paid from the pool's source pays the pool's users
+	for i, tx := range batch.Transactions {
+		if tx.Input.Address != v.Source {
+			return fmt.Errorf("transaction %d is paid from %s, not the pool source %s", i, tx.Input.Address, v.Source)
+		}
+	}
+
+	expected, err := NewBatch(payments, v.Source)
+	if err != nil {
+		return err
+	}
+	if err := compareBatches(expected, &batch); err != nil {
+		return err
+	}
+
+	executed, err := v.Status.TransactionStatus(ctx, entryhash)
+	if err == accounting.ErrPayoutFailed {
+		return fmt.Errorf("transaction was rejected by pegnet")
+	}
+	if err != nil {
+		return err
+	}
+	if !executed {
+		return fmt.Errorf("transaction is not yet executed")
+	}
+	return nil
+}
+
+// compareBatches returns an error if the batch on chain does not have the
+// same transactions as the payments.
+func compareBatches(expected, found *TransactionBatch) error {
+	if len(expected.Transactions) != len(found.Transactions) {
+		return fmt.Errorf("expected %d transactions, found %d", len(expected.Transactions), len(found.Transactions))
+	}
+
+	for i, tx := range expected.Transactions {
+		f := found.Transactions[i]
+		if tx.Input != f.Input {
+			return fmt.Errorf("transaction %d: expected input of %d from %s, found %d from %s",
+				i, tx.Input.Amount, tx.Input.Address, f.Input.Amount, f.Input.Address)
+		}
+		if len(tx.Transfers) != len(f.Transfers) {
+			return fmt.Errorf("transaction %d: expected %d transfers, found %d", i, len(tx.Transfers), len(f.Transfers))
+		}
+		for j := range tx.Transfers {
+			if tx.Transfers[j] != f.Transfers[j] {
+				return fmt.Errorf("transaction %d: expected %d to %s, found %d to %s", i,
+					tx.Transfers[j].Amount, tx.Transfers[j].Address, f.Transfers[j].Amount, f.Transfers[j].Address)
+			}
+		}
+	}
+	return nil
+}
+
+// FactomdEntries gets the entries from factomd
+type FactomdEntries struct {
+	Client *factom.Client
+}
+
+func (f FactomdEntries) GetEntry(ctx context.Context, entryhash string) (factom.Entry, error) {
+	var hash factom.Bytes32
+	if err := hash.Set(entryhash); err != nil {
+		return factom.Entry{}, err
+	}
+
+	e := factom.Entry{Hash: &hash}
+	err := e.Get(ctx, f.Client)
+	return e, err
+}
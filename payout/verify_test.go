@@ -0,0 +1,158 @@
+package payout_test
+
+import (
+	"context"
+	"crypto/sha512"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	. "github.com/FactomWyomingEntity/prosper-pool/payout"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainVerifier_VerifyReceipt(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	key, err := factom.GenerateFsAddress()
+	require.NoError(err)
+
+	chain := new(testSubmitter)
+	status := &testStatus{executed: make(map[string]int)}
+	v := &ChainVerifier{Source: key.FAAddress(), Entries: chain, Status: status}
+
+	payments := []accounting.Paid{
+		{UserID: "a", PayoutAddress: userA, PaymentAmount: 10e8},
+		{UserID: "b", PayoutAddress: userA, PaymentAmount: 4e8},
+		{UserID: "b", PayoutAddress: userB, PaymentAmount: 6e8, Batch: 1},
+	}
+	batches, err := NewBatches(payments, key.FAAddress())
+	require.NoError(err)
+	var receipt []accounting.Paid
+	for _, batch := range batches {
+		require.NoError(batch.MarshalEntry())
+		require.NoError(batch.Sign(key))
+		require.NoError(chain.SubmitEntry(ctx, &batch.Entry))
+		receipt = append(receipt, batch.Receipt()...)
+	}
+
+	// Not yet executed
+	require.Error(v.VerifyReceipt(ctx, receipt))
+
+	status.executed[receipt[0].EntryHash] = 1
+	status.executed[receipt[2].EntryHash] = 1
+	require.NoError(v.VerifyReceipt(ctx, receipt))
+
+	tampered := func(change func(receipt []accounting.Paid)) error {
+		c := make([]accounting.Paid, len(receipt))
+		copy(c, receipt)
+		change(c)
+		return v.VerifyReceipt(ctx, c)
+	}
+	require.Error(tampered(func(r []accounting.Paid) { r[0].PaymentAmount++ }))
+	require.Error(tampered(func(r []accounting.Paid) { r[1].PayoutAddress = userB }))
+	require.Error(tampered(func(r []accounting.Paid) { r[1].EntryHash = r[2].EntryHash }))
+	require.Error(tampered(func(r []accounting.Paid) { r[0].EntryHash = "" }))
+	require.Error(tampered(func(r []accounting.Paid) {
+		r[0].EntryHash = "0000000000000000000000000000000000000000000000000000000000000000"
+	}))
+
+	// A forged signature
+	chain.entries[0].ExtIDs[2][0]++
+	require.Error(v.VerifyReceipt(ctx, receipt))
+	chain.entries[0].ExtIDs[2][0]--
+	require.NoError(v.VerifyReceipt(ctx, receipt))
+
+	// Rejected by pegnet
+	status.executed[receipt[2].EntryHash] = -1
+	require.Error(v.VerifyReceipt(ctx, receipt))
+}
+
+// TestChainVerifier_ForeignSource checks a batch that pays the receipt, but
+// is signed by and paid from an address that is not the pool's, is refused.
+func TestChainVerifier_ForeignSource(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	pool, err := factom.GenerateFsAddress()
+	require.NoError(err)
+	foreign, err := factom.GenerateFsAddress()
+	require.NoError(err)
+
+	chain := new(testSubmitter)
+	status := &testStatus{executed: make(map[string]int)}
+	v := &ChainVerifier{Source: pool.FAAddress(), Entries: chain, Status: status}
+
+	payments := []accounting.Paid{
+		{UserID: "a", PayoutAddress: userA, PaymentAmount: 10e8},
+		{UserID: "b", PayoutAddress: userB, PaymentAmount: 6e8},
+	}
+	batch, err := NewBatch(payments, foreign.FAAddress())
+	require.NoError(err)
+	require.NoError(batch.MarshalEntry())
+	require.NoError(batch.Sign(foreign))
+	require.NoError(chain.SubmitEntry(ctx, &batch.Entry))
+	receipt := batch.Receipt()
+	status.executed[receipt[0].EntryHash] = 1
+
+	// The batch is valid, just not the pool's
+	require.NoError(batch.Verify())
+	require.Error(v.VerifyReceipt(ctx, receipt))
+
+	v.Source = foreign.FAAddress()
+	require.NoError(v.VerifyReceipt(ctx, receipt))
+}
+
+// TestChainVerifier_PegnetEntry checks an entry signed as fatd signs a FAT-2
+// batch, one signature for the single input, is verified.
+func TestChainVerifier_PegnetEntry(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	key, err := factom.GenerateFsAddress()
+	require.NoError(err)
+	source := key.FAAddress()
+
+	content := fmt.Sprintf(`{"version":1,"transactions":[`+
+		`{"input":{"address":"%[1]s","amount":1000000000,"type":"PEG"},"transfers":[{"address":"%[2]s","amount":1000000000}]},`+
+		`{"input":{"address":"%[1]s","amount":1000000000,"type":"PEG"},"transfers":[{"address":"%[2]s","amount":400000000},{"address":"%[3]s","amount":600000000}]}`+
+		`]}`, source, userA, userB)
+	chain := factom.Bytes32(config.TransactionChain)
+	e := factom.Entry{ChainID: &chain, Content: factom.Bytes(content)}
+
+	// fat.Entry.Sign, with a single signer
+	salt := []byte(strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	var msg []byte
+	msg = append(msg, '0')
+	msg = append(msg, salt...)
+	msg = append(msg, chain[:]...)
+	msg = append(msg, e.Content...)
+	hash := sha512.Sum512(msg)
+	e.ExtIDs = []factom.Bytes{salt, key.RCD(), key.Sign(hash[:])}
+	require.NoError(fat2Validate(e, time.Now()))
+
+	entries := new(testSubmitter)
+	require.NoError(entries.SubmitEntry(ctx, &e))
+	status := &testStatus{executed: map[string]int{e.Hash.String(): 1}}
+	v := &ChainVerifier{Source: source, Entries: entries, Status: status}
+
+	receipt := []accounting.Paid{
+		{UserID: "a", PayoutAddress: userA, PaymentAmount: 10e8, EntryHash: e.Hash.String()},
+		{UserID: "b", PayoutAddress: userA, PaymentAmount: 4e8, EntryHash: e.Hash.String()},
+		{UserID: "b", PayoutAddress: userB, PaymentAmount: 6e8, EntryHash: e.Hash.String()},
+	}
+	require.NoError(v.VerifyReceipt(ctx, receipt))
+
+	// A signature per transaction is rejected by pegnet, so is not verified
+	e.ExtIDs = append(e.ExtIDs, e.ExtIDs[1], e.ExtIDs[2])
+	require.Error(fat2Validate(e, time.Now()))
+	entries.entries = nil
+	require.NoError(entries.SubmitEntry(ctx, &e))
+	status.executed[e.Hash.String()] = 1
+	for i := range receipt {
+		receipt[i].EntryHash = e.Hash.String()
+	}
+	require.Error(v.VerifyReceipt(ctx, receipt))
+}
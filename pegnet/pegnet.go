@@ -1,6 +1,8 @@
 package pegnet
 
 import (
+	"time"
+
 	"github.com/jinzhu/gorm"
 
 	"github.com/Factom-Asset-Tokens/factom"
@@ -58,6 +60,10 @@ type PegnetdHook struct {
 	// Top means the block is the latest block
 	Top         bool
 	GradedBlock grader.GradedBlock
+	// EntryHashes are all the entries in the opr chain for the height
+	EntryHashes []factom.Bytes32
+	// Timestamp is the time of the directory block
+	Timestamp time.Time
 }
 
 func (n *Node) GetHook() <-chan PegnetdHook {
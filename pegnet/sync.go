@@ -82,7 +82,7 @@ OuterSyncLoop:
 			// We are not synced, so we need to iterate through the dblocks and sync them
 			// one by one. We can only sync our current synced height +1
 			// TODO: This skips the genesis block. I'm sure that is fine
-			block, err := n.SyncBlock(ctx, tx, uint32(current))
+			block, entries, timestamp, err := n.SyncBlock(ctx, tx, uint32(current))
 			if err != nil {
 				hLog.WithError(err).Errorf("failed to sync height")
 				// If we fail, we backout to the outer loop. This allows error handling on factomd state to be a bit
@@ -135,8 +135,10 @@ OuterSyncLoop:
 			// TODO: Ensure this logic is correct.
 			hook := PegnetdHook{
 				GradedBlock: block,
+				EntryHashes: entries,
 				Top:         current == int32(heights.DirectoryBlock),
 				Height:      current,
+				Timestamp:   timestamp,
 			}
 			// Don't bother nil blocks
 			if hook.GradedBlock != nil {
@@ -170,24 +172,29 @@ OuterSyncLoop:
 // If SyncBlock returns no error, than that height was synced and saved. If any
 // part of the sync fails, the whole sync should be rolled back and not applied.
 // An error should then be returned. The context should be respected if it is
-// cancelled
-func (n *Node) SyncBlock(ctx context.Context, tx *gorm.DB, height uint32) (grader.GradedBlock, error) {
+// cancelled. The entryhashes of all oprs in the block, and the time of the
+// block, are also returned.
+func (n *Node) SyncBlock(ctx context.Context, tx *gorm.DB, height uint32) (grader.GradedBlock, []factom.Bytes32, time.Time, error) {
 	fLog := pegdLog.WithFields(log.Fields{"height": height})
 	if err := ctx.Err(); err != nil { // Just an example about how to handle it being cancelled
-		return nil, err
+		return nil, nil, time.Time{}, err
 	}
 
 	dblock := new(factom.DBlock)
 	dblock.Height = height
 	if err := dblock.Get(nil, n.FactomClient); err != nil {
-		return nil, err
+		return nil, nil, time.Time{}, err
 	}
 
 	// First, gather all entries we need from factomd
+	var entries []factom.Bytes32
 	oprEBlock := dblock.EBlock(factom.Bytes32(config.OPRChain))
 	if oprEBlock != nil {
 		if err := multiFetch(oprEBlock, n.FactomClient); err != nil {
-			return nil, err
+			return nil, nil, time.Time{}, err
+		}
+		for _, e := range oprEBlock.Entries {
+			entries = append(entries, *e.Hash)
 		}
 	}
 
@@ -195,18 +202,18 @@ func (n *Node) SyncBlock(ctx context.Context, tx *gorm.DB, height uint32) (grade
 	// to execute conversions that are in holding.
 	gradedBlock, err := n.Grade(ctx, oprEBlock)
 	if err != nil {
-		return nil, err
+		return nil, nil, time.Time{}, err
 	} else if gradedBlock != nil {
 		err = InsertGradeBlock(tx, oprEBlock, gradedBlock)
 		if err != nil {
-			return nil, err
+			return nil, nil, time.Time{}, err
 		}
 		winners := gradedBlock.Winners()
 		if 0 < len(winners) {
 			var s database.PegnetPayout
 			err := tx.Order("height desc").First(&s).Error
 			if err != nil && err != gorm.ErrRecordNotFound {
-				return nil, err
+				return nil, nil, time.Time{}, err
 			}
 			if s.Height != int32(height) {
 				// Write the top 50, not just the top 25
@@ -221,7 +228,7 @@ func (n *Node) SyncBlock(ctx context.Context, tx *gorm.DB, height uint32) (grade
 						EntryHash:       graded[i].EntryHash,
 					}
 					if dbErr := tx.Create(&payout); dbErr.Error != nil {
-						return nil, dbErr.Error
+						return nil, nil, time.Time{}, dbErr.Error
 					}
 				}
 			}
@@ -232,7 +239,7 @@ func (n *Node) SyncBlock(ctx context.Context, tx *gorm.DB, height uint32) (grade
 		fLog.WithFields(log.Fields{"section": "grading", "reason": "no graded block"}).Tracef("block not graded")
 	}
 
-	return gradedBlock, nil
+	return gradedBlock, entries, dblock.Timestamp, nil
 }
 
 func multiFetch(eblock *factom.EBlock, c *factom.Client) error {
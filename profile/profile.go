@@ -6,12 +6,14 @@ import (
 	"net/http/pprof"
 	"runtime"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 )
 
 // StartProfiler runs the go pprof tool
 // `go tool pprof http://localhost:6060/debug/pprof/profile`
 // https://golang.org/pkg/net/http/pprof/
+// The prometheus metrics are served on /metrics
 func StartProfiler(expose bool, port int) {
 	pre := "localhost"
 	if expose {
@@ -24,16 +26,10 @@ func StartProfiler(expose bool, port int) {
 	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
 	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/metrics", promhttp.Handler())
 
 	addr := fmt.Sprintf("%s:%d", pre, port)
 	log.Infof("Profiling on %s", addr)
 	runtime.SetBlockProfileRate(100000)
 	log.Println(http.ListenAndServe(addr, mux))
 }
-
-// TODO: Add prometheus option
-/*func launchPrometheus(port int) {
-	mux := http.NewServeMux()
-	mux.Handle("/metrics", prometheus.Handler())
-	go http.ListenAndServe(fmt.Sprintf(":%d", port), mux)
-}*/
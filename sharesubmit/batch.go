@@ -0,0 +1,162 @@
+package sharesubmit
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/FactomWyomingEntity/prosper-pool/stratum"
+	log "github.com/sirupsen/logrus"
+)
+
+// Submission modes
+const (
+	// ModeRolling submits shares as they come in, limited by the softmax
+	ModeRolling = "rolling"
+	// ModeMinute9 buffers the best shares of the block, and submits them at
+	// minute 9
+	ModeMinute9 = "minute9"
+	// ModeAuto uses rolling submissions, and switches to minute 9
+	// submissions when the last job had too many shares to submit
+	ModeAuto = "auto"
+)
+
+// BatchMinute is the minute the buffered shares are submitted
+const BatchMinute = 9
+
+// MinuteSource tells us the minute of the block being built. A minute < 0
+// means the minute is not known.
+type MinuteSource interface {
+	Minute() int32
+}
+
+func (s *Submitter) SetMinuteSource(ms MinuteSource) {
+	s.minutes = ms
+}
+
+// minute returns the minute of the block being built, or -1 if not known
+func (s *Submitter) minute() int32 {
+	if s.minutes == nil {
+		return -1
+	}
+	return s.minutes.Minute()
+}
+
+// useBatch decides if the next job uses minute 9 submissions. Minute 9
+// submissions need the minute, so without it we always use rolling.
+func (s *Submitter) useBatch(lastCandidates int) bool {
+	if s.minute() < 0 {
+		return false
+	}
+	switch s.configuration.Mode {
+	case ModeMinute9:
+		return true
+	case ModeAuto:
+		return lastCandidates > s.configuration.BatchThreshold
+	}
+	return false
+}
+
+// bufferShare adds the share to the best shares of the job. Any share pushed
+// out of the best, or not good enough to be in them, is returned.
+func (s *Submitter) bufferShare(share *stratum.ShareSubmission) *stratum.ShareSubmission {
+	if s.configuration.BatchSize <= 0 {
+		return share
+	}
+
+	list := s.jobState.batched
+	index := sort.Search(len(list), func(i int) bool { return list[i].Target < share.Target })
+	if index == s.configuration.BatchSize {
+		return share
+	}
+
+	var dropped *stratum.ShareSubmission
+	if len(list) == s.configuration.BatchSize {
+		dropped = list[len(list)-1]
+		list = list[:len(list)-1]
+	}
+	list = append(list, nil)
+	copy(list[index+1:], list[index:])
+	list[index] = share
+	s.jobState.batched = list
+	return dropped
+}
+
+// flushBatch submits the buffered shares once we reach minute 9. If the
+// minute is lost partway through the job, minute 9 would never be seen, so
+// the buffered shares are submitted right away and the rest of the job uses
+// rolling submissions.
+func (s *Submitter) flushBatch() {
+	if !s.jobState.batch || s.jobState.flushed {
+		return
+	}
+
+	minute := s.minute()
+	if minute < 0 {
+		sLog.WithFields(log.Fields{
+			"job":    s.currentJob.JobID,
+			"shares": len(s.jobState.batched),
+		}).Warnf("minute is not known, submitting the buffered shares and switching to rolling submissions")
+		s.jobState.batch = false
+		batchMode.Set(0)
+		s.submitBatch()
+		return
+	}
+	if minute < BatchMinute {
+		return
+	}
+
+	s.jobState.flushed = true
+	if len(s.jobState.batched) == 0 {
+		return
+	}
+	sLog.WithFields(log.Fields{
+		"job":    s.currentJob.JobID,
+		"shares": len(s.jobState.batched),
+	}).Infof("submitting the best shares at minute %d", BatchMinute)
+	s.submitBatch()
+}
+
+// submitBatch submits the buffered shares, best first, so the budget keeps
+// the best shares
+func (s *Submitter) submitBatch() {
+	for _, share := range s.jobState.batched {
+		s.submitShare(share)
+	}
+	s.jobState.batched = nil
+}
+
+// dropBatch records the buffered shares that were never submitted, as
+// minute 9 was never reached for their job.
+func (s *Submitter) dropBatch() {
+	if len(s.jobState.batched) == 0 {
+		return
+	}
+	sLog.WithFields(log.Fields{
+		"job":    s.currentJob.JobID,
+		"shares": len(s.jobState.batched),
+	}).Warnf("job ended before minute %d, buffered shares were not submitted", BatchMinute)
+	for _, share := range s.jobState.batched {
+		s.blockShare(share, BatchBlock)
+	}
+	s.jobState.batched = nil
+}
+
+// blockShare records a share we chose not to submit
+func (s *Submitter) blockShare(share *stratum.ShareSubmission, reason int) {
+	err := s.saveEntrySubmission(EntrySubmission{
+		ShareSubmission: *share,
+		EntryHash:       "0000000000000000000000000000000000000000000000000000000000000000",
+		CommitTxID:      "0000000000000000000000000000000000000000000000000000000000000000",
+		Blocked:         reason,
+	})
+	if err != nil {
+		sLog.WithError(err).WithField("jobid", share.JobID).Errorf("failed to save blocked submission")
+	}
+	if reason == BatchBlock {
+		batchBlocked.Inc()
+		sLog.WithFields(log.Fields{
+			"job":    share.JobID,
+			"target": fmt.Sprintf("%x", share.Target),
+		}).Debug("share found to submit, but not in the best of the batch")
+	}
+}
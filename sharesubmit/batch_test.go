@@ -0,0 +1,124 @@
+package sharesubmit
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/stratum"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/stretchr/testify/require"
+)
+
+type fixedMinute int32
+
+func (m *fixedMinute) Minute() int32 { return int32(*m) }
+
+func TestUseBatch(t *testing.T) {
+	require := require.New(t)
+	s := new(Submitter)
+	s.configuration.BatchThreshold = 100
+
+	// No minutes, no minute 9
+	for _, mode := range []string{ModeRolling, ModeMinute9, ModeAuto} {
+		s.configuration.Mode = mode
+		require.False(s.useBatch(1000), mode)
+	}
+
+	min := fixedMinute(-1)
+	s.SetMinuteSource(&min)
+	s.configuration.Mode = ModeMinute9
+	require.False(s.useBatch(1000))
+
+	min = 3
+	require.True(s.useBatch(0))
+
+	s.configuration.Mode = ModeRolling
+	require.False(s.useBatch(1000))
+
+	s.configuration.Mode = ModeAuto
+	require.False(s.useBatch(100))
+	require.True(s.useBatch(101))
+}
+
+func TestBufferShare(t *testing.T) {
+	require := require.New(t)
+	s := new(Submitter)
+	s.configuration.BatchSize = 10
+
+	var dropped []uint64
+	for i := 0; i < 500; i++ {
+		share := &stratum.ShareSubmission{Target: rand.Uint64()}
+		if d := s.bufferShare(share); d != nil {
+			dropped = append(dropped, d.Target)
+		}
+	}
+	require.Len(s.jobState.batched, 10)
+	require.Len(dropped, 490)
+
+	// The best are kept, best first
+	worst := s.jobState.batched[9].Target
+	for i := 1; i < len(s.jobState.batched); i++ {
+		require.True(s.jobState.batched[i-1].Target >= s.jobState.batched[i].Target)
+	}
+	for _, d := range dropped {
+		require.True(d <= worst)
+	}
+
+	s.configuration.BatchSize = 0
+	s.resetJobState()
+	share := &stratum.ShareSubmission{Target: 1}
+	require.Equal(share, s.bufferShare(share))
+}
+
+func TestBatchFlush(t *testing.T) {
+	require := require.New(t)
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(err)
+	defer db.Close()
+	require.NoError(db.AutoMigrate(&EntrySubmission{}).Error)
+
+	min := fixedMinute(5)
+	s := &Submitter{db: db, currentJob: &stratum.Job{JobID: 100}}
+	s.configuration.BatchSize = 5
+	s.SetMinuteSource(&min)
+	s.jobState.batch = true
+
+	// Not minute 9 yet
+	s.flushBatch()
+	require.False(s.jobState.flushed)
+
+	// The job ends before minute 9, so the buffered shares are blocked
+	for i := 0; i < 3; i++ {
+		s.bufferShare(&stratum.ShareSubmission{JobID: 100, Target: uint64(i + 1)})
+	}
+	s.dropBatch()
+	require.Empty(s.jobState.batched)
+	var blocked int
+	require.NoError(db.Model(&EntrySubmission{}).Where("blocked = ?", BatchBlock).Count(&blocked).Error)
+	require.Equal(3, blocked)
+
+	min = BatchMinute
+	s.flushBatch()
+	require.True(s.jobState.flushed)
+
+	// The minute is lost partway through the job, so the buffered shares
+	// are not held for a minute 9 that never comes. The spent budget
+	// stands in for factomd.
+	s.resetJobState()
+	s.jobState.batch = true
+	s.budget.PerBlock = 1
+	s.budget.Spend(100, time.Now(), 1)
+	min = 5
+	s.bufferShare(&stratum.ShareSubmission{JobID: 100, Target: 1})
+	min = -1
+	s.flushBatch()
+	require.False(s.jobState.batch)
+	require.Empty(s.jobState.batched)
+	var submitted int
+	require.NoError(db.Model(&EntrySubmission{}).Where("blocked = ?", BudgetBlock).Count(&submitted).Error)
+	require.Equal(1, submitted)
+	require.NoError(db.Model(&EntrySubmission{}).Where("blocked = ?", BatchBlock).Count(&blocked).Error)
+	require.Equal(3, blocked)
+}
@@ -0,0 +1,122 @@
+package sharesubmit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// ECBudget limits the entry credits spent on submissions per block and per
+// UTC day. A limit of 0 is unlimited.
+type ECBudget struct {
+	PerBlock int
+	PerDay   int
+
+	job      int32
+	jobSpent int
+	day      time.Time
+	daySpent int
+}
+
+// Allow returns if the cost fits in the budget of the job and day
+func (b *ECBudget) Allow(job int32, now time.Time, cost int) bool {
+	b.roll(job, now)
+	if b.PerBlock > 0 && b.jobSpent+cost > b.PerBlock {
+		return false
+	}
+	if b.PerDay > 0 && b.daySpent+cost > b.PerDay {
+		return false
+	}
+	return true
+}
+
+// Spend adds the cost to the spend of the job and day
+func (b *ECBudget) Spend(job int32, now time.Time, cost int) {
+	b.roll(job, now)
+	b.jobSpent += cost
+	b.daySpent += cost
+}
+
+// Spent returns the spend of the current job and day
+func (b *ECBudget) Spent() (job int, day int) {
+	return b.jobSpent, b.daySpent
+}
+
+func (b *ECBudget) roll(job int32, now time.Time) {
+	if job != b.job {
+		b.job, b.jobSpent = job, 0
+	}
+	if day := now.UTC().Truncate(24 * time.Hour); !day.Equal(b.day) {
+		b.day, b.daySpent = day, 0
+	}
+}
+
+// LoadECBudgetSpend sets the spend of the budget from the submissions saved
+// for the job and day, so a restart does not reset the budget.
+func LoadECBudgetSpend(db *gorm.DB, b *ECBudget, job int32, now time.Time) error {
+	b.roll(job, now)
+
+	type spend struct {
+		Total int
+	}
+	var jobSpend, daySpend spend
+	err := db.Model(&EntrySubmission{}).Select("coalesce(sum(ec_cost), 0) as total").
+		Where("job_id = ?", job).Scan(&jobSpend).Error
+	if err != nil {
+		return err
+	}
+	err = db.Model(&EntrySubmission{}).Select("coalesce(sum(ec_cost), 0) as total").
+		Where("created_at >= ?", b.day).Scan(&daySpend).Error
+	if err != nil {
+		return err
+	}
+	b.jobSpent, b.daySpent = jobSpend.Total, daySpend.Total
+	return nil
+}
+
+// ECBalance returns the last known entry credit balance of the pool's ES
+// address, and if it is below the alert threshold. The balance is -1 if it
+// is not known yet.
+func (s *Submitter) ECBalance() (int64, bool) {
+	balance := atomic.LoadInt64(&s.ecBalance)
+	return balance, balance >= 0 && balance < s.configuration.ECAlertThreshold
+}
+
+// monitorBalance polls the entry credit balance, and warns when it is low
+func (s *Submitter) monitorBalance(ctx context.Context, poll time.Duration) {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		s.checkBalance(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Submitter) checkBalance(ctx context.Context) {
+	ec := s.configuration.ESAddress.ECAddress()
+	balance, err := ec.GetBalance(ctx, s.FactomClient)
+	if err != nil {
+		sLog.WithError(err).WithField("address", ec.String()).Warnf("failed to get the entry credit balance")
+		return
+	}
+
+	atomic.StoreInt64(&s.ecBalance, int64(balance))
+	ecBalance.Set(float64(balance))
+	if _, low := s.ECBalance(); low {
+		ecBalanceLow.Set(1)
+		sLog.WithFields(log.Fields{
+			"address":   ec.String(),
+			"balance":   balance,
+			"threshold": s.configuration.ECAlertThreshold,
+		}).Warnf("entry credit balance is low")
+	} else {
+		ecBalanceLow.Set(0)
+	}
+}
@@ -0,0 +1,62 @@
+package sharesubmit_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/FactomWyomingEntity/prosper-pool/sharesubmit"
+	"github.com/FactomWyomingEntity/prosper-pool/stratum"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/stretchr/testify/require"
+)
+
+func TestECBudget(t *testing.T) {
+	require := require.New(t)
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	b := &ECBudget{PerBlock: 3, PerDay: 5}
+	for i := 0; i < 3; i++ {
+		require.True(b.Allow(100, now, 1))
+		b.Spend(100, now, 1)
+	}
+	require.False(b.Allow(100, now, 1))
+
+	// A new block has a new budget, but the day is nearly spent
+	require.True(b.Allow(101, now, 2))
+	require.False(b.Allow(101, now, 3))
+	b.Spend(101, now, 2)
+	require.False(b.Allow(102, now, 1))
+
+	// A new day
+	require.True(b.Allow(102, now.Add(12*time.Hour), 3))
+
+	// Unlimited
+	b = new(ECBudget)
+	b.Spend(100, now, 1e6)
+	require.True(b.Allow(100, now, 1e6))
+}
+
+func TestLoadECBudgetSpend(t *testing.T) {
+	require := require.New(t)
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(err)
+	defer db.Close()
+	db.AutoMigrate(&EntrySubmission{})
+
+	now := time.Now()
+	yesterday := now.Add(-48 * time.Hour)
+	require.NoError(db.Create(&EntrySubmission{ShareSubmission: stratum.ShareSubmission{JobID: 99}, ECCost: 4}).Error)
+	require.NoError(db.Model(&EntrySubmission{}).Where("job_id = ?", 99).Update("created_at", yesterday).Error)
+	require.NoError(db.Create(&EntrySubmission{ShareSubmission: stratum.ShareSubmission{JobID: 100}, ECCost: 1}).Error)
+	require.NoError(db.Create(&EntrySubmission{ShareSubmission: stratum.ShareSubmission{JobID: 100}, ECCost: 1}).Error)
+	require.NoError(db.Create(&EntrySubmission{ShareSubmission: stratum.ShareSubmission{JobID: 101}, ECCost: 1}).Error)
+
+	b := &ECBudget{PerBlock: 2, PerDay: 4}
+	require.NoError(LoadECBudgetSpend(db, b, 100, now))
+	job, day := b.Spent()
+	require.Equal(2, job)
+	require.Equal(3, day)
+	require.False(b.Allow(100, now, 1))
+	require.True(b.Allow(101, now, 1))
+}
@@ -27,6 +27,30 @@ var (
 		Name: "pool_submit_difficulty_last_graded_index",
 		Help: "Last graded index",
 	})
+	ecBalance = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pool_submit_ec_balance",
+		Help: "Entry credit balance of the submit address",
+	})
+	ecBalanceLow = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pool_submit_ec_balance_low",
+		Help: "1 if the entry credit balance is below the alert threshold",
+	})
+	ecSpent = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pool_submit_ec_spent",
+		Help: "Entry credits spent on submissions",
+	})
+	budgetBlocked = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pool_submit_budget_blocked",
+		Help: "Submissions blocked by the entry credit budget",
+	})
+	batchMode = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pool_submit_batch_mode",
+		Help: "1 if the current job submits the best shares at minute 9",
+	})
+	batchBlocked = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pool_submit_batch_blocked",
+		Help: "Submissions blocked by not being in the best shares of the batch",
+	})
 )
 
 var prom sync.Once
@@ -38,5 +62,11 @@ func RegisterPrometheus() {
 		prometheus.MustRegister(cutoffMinimumIndex)
 		prometheus.MustRegister(cutoffMinimumDifficulty)
 		prometheus.MustRegister(emaDifficulty)
+		prometheus.MustRegister(ecBalance)
+		prometheus.MustRegister(ecBalanceLow)
+		prometheus.MustRegister(ecSpent)
+		prometheus.MustRegister(budgetBlocked)
+		prometheus.MustRegister(batchMode)
+		prometheus.MustRegister(batchBlocked)
 	})
 }
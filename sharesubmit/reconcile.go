@@ -0,0 +1,179 @@
+package sharesubmit
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/FactomWyomingEntity/prosper-pool/pegnet"
+	"github.com/FactomWyomingEntity/prosper-pool/stratum"
+	"github.com/jinzhu/gorm"
+	"github.com/shopspring/decimal"
+)
+
+// ECPrice is the fixed price of an entry credit, in USD with 8 decimals
+const ECPrice = 1e5
+
+// Reconciliation issues
+const (
+	// IssueUnknown is a graded opr with our identity or coinbase, that we
+	// did not submit
+	IssueUnknown = "unknown"
+	// IssueVanished is a submission that is not in the block
+	IssueVanished = "vanished"
+	// IssueUngraded is a submission in the block that did not make the
+	// graded set
+	IssueUngraded = "ungraded"
+	// IssueLost is a submission in the graded set that did not earn a reward
+	IssueLost = "lost"
+)
+
+// Reconciliation matches the graded oprs of a block against the entries we
+// submitted for it.
+type Reconciliation struct {
+	Height int32 `gorm:"primary_key;auto_increment:false" json:"height"`
+
+	Submitted int   `json:"submitted"` // Entries we submitted, excluding blocked
+	InBlock   int   `json:"inblock"`   // Submitted entries found in the block
+	Graded    int   `json:"graded"`    // Submitted entries in the graded set
+	Winning   int   `json:"winning"`   // Submitted entries that earned a reward
+	Reward    int64 `json:"reward"`    // Reward earned by submitted entries
+	// ECSpent is the entry credits paid for the submitted entries, so the
+	// reward can be weighed against the cost of the block.
+	ECSpent int `gorm:"default:0" json:"ecspent"`
+	// ECCost is the entry credits spent in PEG, at the PEG price of the
+	// block's winners. It is 0 if the block has no PEG price.
+	ECCost int64 `gorm:"default:0" json:"eccost"`
+	// Net is the pool reward of the block, less the ECCost. The pool reward
+	// is every graded opr of ours, the same as the block's owed payouts.
+	Net int64 `gorm:"default:0" json:"net"`
+
+	// Unknown is graded oprs with our identity we did not submit
+	Unknown       int   `json:"unknown"`
+	UnknownReward int64 `json:"unknownreward"`
+
+	Issues []ReconciliationIssue `gorm:"foreignkey:Height" json:"issues,omitempty"`
+}
+
+// ReconciliationIssue is a single entry that did not reconcile
+type ReconciliationIssue struct {
+	ID        uint   `gorm:"primary_key" json:"-"`
+	Height    int32  `gorm:"index:recon_height" json:"height"`
+	EntryHash string `json:"entryhash"`
+	Issue     string `json:"issue"`
+	Position  int32  `json:"position"` // -1 if not graded
+	Reward    int64  `json:"reward"`
+}
+
+// Reconcile matches the graded block against our submissions for it. Blocks
+// we did not submit to, and have no oprs of ours, are not saved.
+func Reconcile(db *gorm.DB, block pegnet.PegnetdHook, identity, coinbase string) (*Reconciliation, error) {
+	r := &Reconciliation{Height: block.Height}
+
+	var submissions []EntrySubmission
+	err := db.Where("job_id = ? AND blocked = 0", stratum.JobIDFromHeight(block.Height)).Find(&submissions).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	var payouts []database.PegnetPayout
+	err = db.Where("height = ?", block.Height).Find(&payouts).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	inBlock := make(map[string]bool)
+	for _, e := range block.EntryHashes {
+		inBlock[e.String()] = true
+	}
+
+	graded := make(map[string]database.PegnetPayout)
+	for _, p := range payouts {
+		graded[hex.EncodeToString(p.EntryHash)] = p
+	}
+
+	ours := make(map[string]bool)
+	r.Submitted = len(submissions)
+	for _, s := range submissions {
+		ours[s.EntryHash] = true
+		r.ECSpent += s.ECCost
+		p, ok := graded[s.EntryHash]
+		switch {
+		case ok && p.Reward > 0:
+			r.InBlock++
+			r.Graded++
+			r.Winning++
+			r.Reward += p.Reward
+		case ok:
+			r.InBlock++
+			r.Graded++
+			r.addIssue(s.EntryHash, IssueLost, p.Position, 0)
+		case inBlock[s.EntryHash]:
+			r.InBlock++
+			r.addIssue(s.EntryHash, IssueUngraded, -1, 0)
+		default:
+			r.addIssue(s.EntryHash, IssueVanished, -1, 0)
+		}
+	}
+
+	for hash, p := range graded {
+		if ours[hash] || (p.Identity != identity && p.CoinbaseAddress != coinbase) {
+			continue
+		}
+		r.Unknown++
+		r.UnknownReward += p.Reward
+		r.addIssue(hash, IssueUnknown, p.Position, p.Reward)
+	}
+
+	if r.Submitted == 0 && r.Unknown == 0 {
+		return r, nil // Nothing of ours in this block
+	}
+
+	if price := PegPrice(block); price > 0 {
+		r.ECCost = decimal.New(int64(r.ECSpent)*ECPrice, 0).Mul(decimal.New(1e8, 0)).
+			Div(decimal.New(int64(price), 0)).IntPart()
+	}
+	r.Net = r.Reward + r.UnknownReward - r.ECCost
+
+	var exists Reconciliation
+	err = db.Where("height = ?", r.Height).First(&exists).Error
+	if err == nil {
+		return r, nil // Already reconciled, probably a resync
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	return r, db.Create(r).Error
+}
+
+// PegPrice returns the PEG price of the block's first winner, in USD with 8
+// decimals. It is 0 if the block has no winners, or PEG is not priced yet.
+func PegPrice(block pegnet.PegnetdHook) uint64 {
+	if block.GradedBlock == nil {
+		return 0
+	}
+	for _, winner := range block.GradedBlock.Winners() {
+		for _, asset := range winner.OPR.GetOrderedAssetsUint() {
+			if asset.Name == "PEG" {
+				return asset.Value
+			}
+		}
+	}
+	return 0
+}
+
+func (r *Reconciliation) addIssue(entryhash, issue string, position int32, reward int64) {
+	r.Issues = append(r.Issues, ReconciliationIssue{
+		Height:    r.Height,
+		EntryHash: entryhash,
+		Issue:     issue,
+		Position:  position,
+		Reward:    reward,
+	})
+}
+
+// Summary is a one line description of the reconciliation
+func (r Reconciliation) Summary() string {
+	return fmt.Sprintf("%d submitted, %d in block, %d graded, %d winning, %d unknown, %d ec spent, %s PEG net",
+		r.Submitted, r.InBlock, r.Graded, r.Winning, r.Unknown, r.ECSpent, decimal.New(r.Net, -8))
+}
@@ -0,0 +1,160 @@
+package sharesubmit_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/FactomWyomingEntity/prosper-pool/pegnet"
+	. "github.com/FactomWyomingEntity/prosper-pool/sharesubmit"
+	"github.com/FactomWyomingEntity/prosper-pool/stratum"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/pegnet/pegnet/modules/grader"
+	"github.com/pegnet/pegnet/modules/opr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcile(t *testing.T) {
+	require := require.New(t)
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(err)
+	defer db.Close()
+	db.AutoMigrate(&EntrySubmission{}, &Reconciliation{}, &ReconciliationIssue{}, &database.PegnetPayout{})
+
+	hash := func(b byte) factom.Bytes32 {
+		var h factom.Bytes32
+		h[0] = b
+		return h
+	}
+
+	submit := func(h factom.Bytes32, blocked int) {
+		require.NoError(db.Create(&EntrySubmission{
+			ShareSubmission: stratum.ShareSubmission{JobID: 100},
+			EntryHash:       h.String(),
+			Blocked:         blocked,
+			ECCost:          1,
+		}).Error)
+	}
+	payout := func(h factom.Bytes32, position int32, reward int64, id string) {
+		require.NoError(db.Create(&database.PegnetPayout{
+			Height: 100, Position: position, Reward: reward, Identity: id, EntryHash: h[:],
+		}).Error)
+	}
+
+	submit(hash(1), 0)  // Wins
+	submit(hash(2), 0)  // Graded, but lost
+	submit(hash(3), 0)  // In the block, not graded
+	submit(hash(4), 0)  // Vanished
+	submit(hash(5), -1) // Blocked, so never submitted
+	payout(hash(1), 0, 800e8, "prosper")
+	payout(hash(2), 30, 0, "prosper")
+	payout(hash(6), 1, 600e8, "prosper")  // Not ours
+	payout(hash(7), 2, 600e8, "somebody") // Someone else
+
+	block := pegnet.PegnetdHook{
+		Height:      100,
+		EntryHashes: []factom.Bytes32{hash(1), hash(2), hash(3), hash(6), hash(7)},
+	}
+	r, err := Reconcile(db, block, "prosper", "FA-prosper")
+	require.NoError(err)
+	require.Equal(4, r.Submitted)
+	require.Equal(3, r.InBlock)
+	require.Equal(2, r.Graded)
+	require.Equal(1, r.Winning)
+	require.Equal(int64(800e8), r.Reward)
+	require.Equal(1, r.Unknown)
+	require.Equal(int64(600e8), r.UnknownReward)
+	require.Equal(4, r.ECSpent)
+	// Without a PEG price the entry credits are not priced
+	require.Zero(r.ECCost)
+	require.Equal(int64(1400e8), r.Net)
+
+	issues := make(map[string]string)
+	for _, i := range r.Issues {
+		issues[i.EntryHash] = i.Issue
+	}
+	require.Equal(map[string]string{
+		hash(2).String(): IssueLost,
+		hash(3).String(): IssueUngraded,
+		hash(4).String(): IssueVanished,
+		hash(6).String(): IssueUnknown,
+	}, issues)
+
+	var saved Reconciliation
+	require.NoError(db.Preload("Issues").First(&saved, "height = ?", 100).Error)
+	require.Len(saved.Issues, 4)
+}
+
+func TestReconcile_Net(t *testing.T) {
+	require := require.New(t)
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(err)
+	defer db.Close()
+	db.AutoMigrate(&EntrySubmission{}, &Reconciliation{}, &ReconciliationIssue{}, &database.PegnetPayout{})
+
+	var h factom.Bytes32
+	require.NoError(db.Create(&EntrySubmission{
+		ShareSubmission: stratum.ShareSubmission{JobID: 100},
+		EntryHash:       h.String(),
+		ECCost:          10,
+	}).Error)
+	require.NoError(db.Create(&database.PegnetPayout{
+		Height: 100, Reward: 200e8, Identity: "prosper", EntryHash: h[:],
+	}).Error)
+
+	// PEG at $0.005, so an entry credit at $0.001 is 0.2 PEG
+	winner := &opr.V2Content{Assets: make([]uint64, len(opr.V2Assets))}
+	for i, name := range opr.V2Assets {
+		if name == "PEG" {
+			winner.Assets[i] = 5e5
+		}
+	}
+	block := pegnet.PegnetdHook{
+		Height:      100,
+		EntryHashes: []factom.Bytes32{h},
+		GradedBlock: testGraded{winners: []*grader.GradingOPR{{OPR: winner}}},
+	}
+	require.Equal(uint64(5e5), PegPrice(block))
+
+	r, err := Reconcile(db, block, "prosper", "FA-prosper")
+	require.NoError(err)
+	require.Equal(10, r.ECSpent)
+	require.Equal(int64(2e8), r.ECCost)
+	require.Equal(int64(198e8), r.Net)
+
+	var saved Reconciliation
+	require.NoError(db.First(&saved, "height = ?", 100).Error)
+	require.Equal(int64(198e8), saved.Net)
+}
+
+// testGraded is a graded block with only winners
+type testGraded struct {
+	grader.GradedBlock
+	winners []*grader.GradingOPR
+}
+
+func (g testGraded) Winners() []*grader.GradingOPR {
+	return g.winners
+}
+
+func TestSubmittedShares(t *testing.T) {
+	require := require.New(t)
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(err)
+	defer db.Close()
+	db.AutoMigrate(&EntrySubmission{})
+
+	for i, job := range []int32{10, 10, 11} {
+		require.NoError(db.Create(&EntrySubmission{
+			ShareSubmission: stratum.ShareSubmission{Username: "a@gmail.com", MinerID: "rig1", JobID: job},
+			EntryHash:       fmt.Sprintf("%064d", i),
+		}).Error)
+	}
+
+	shares, err := SubmittedShares(db, 10, []string{fmt.Sprintf("%064d", 0), fmt.Sprintf("%064d", 2), "unknown"})
+	require.NoError(err)
+	require.Len(shares, 1)
+	require.Equal("rig1", shares[fmt.Sprintf("%064d", 0)].MinerID)
+}
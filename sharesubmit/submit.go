@@ -5,6 +5,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math/big"
+	"sync/atomic"
+	"time"
 
 	"github.com/FactomWyomingEntity/prosper-pool/database"
 
@@ -30,17 +32,28 @@ var (
 const (
 	// BlockReasons
 	SoftMaxBlock = -1
+	BudgetBlock  = -2
+	BatchBlock   = -3
 )
 
 // Submitter handles submitting shares to factomd. If the share is too old,
 // or too low, it will not submit. If we are submitting too many, then it
 // will switch from rolling submissions to minute 9 submissions
 type Submitter struct {
+	// ecBalance is the last known entry credit balance, for other routines
+	// to read. It is first to be 64 bit aligned.
+	ecBalance int64
+
 	db *gorm.DB
 
 	// shares channel is made elsewhere
 	shares <-chan *stratum.ShareSubmission
 	blocks chan SubmissionJob
+	// reconciles are the graded blocks to reconcile, which is done off the
+	// submit loop as it queries the db
+	reconciles chan pegnet.PegnetdHook
+	// minutes tells us when minute 9 is, for minute 9 submissions
+	minutes MinuteSource
 
 	FactomClient *factom.Client
 
@@ -52,15 +65,39 @@ type Submitter struct {
 	jobState struct {
 		// diffList is to enforce the softmax
 		diffList []uint64
+		// candidates are the shares above the ema target, which decides the
+		// mode of the next job
+		candidates int
+		// batch is true if the job uses minute 9 submissions. The best shares
+		// are buffered until they are flushed.
+		batch   bool
+		batched []*stratum.ShareSubmission
+		flushed bool
 	}
 
-	currentEMA    EMA
+	currentEMA EMA
+	// budget limits the entry credits spent
+	budget ECBudget
+	// submitTarget is the ema target, for other routines to read
+	submitTarget uint64
+
 	configuration struct {
 		Cutoff       int
 		EMANumPoints int
 		// ESAddress pays for entries
 		ESAddress    factom.EsAddress
 		SoftMaxLimit int
+		// Mode is rolling, minute9, or auto. BatchSize is the number of shares
+		// submitted at minute 9, and BatchThreshold the candidates of a job
+		// that switch auto to minute 9.
+		Mode           string
+		BatchSize      int
+		BatchThreshold int
+		// ECAlertThreshold warns when the entry credit balance is below it
+		ECAlertThreshold int64
+		// Identity and CoinbaseAddress find our oprs when reconciling
+		Identity        string
+		CoinbaseAddress string
 	}
 }
 
@@ -75,23 +112,42 @@ type SubmissionJob struct {
 func NewSubmitter(conf *viper.Viper, db *gorm.DB) (*Submitter, error) {
 	s := new(Submitter)
 	s.blocks = make(chan SubmissionJob, 10)
+	s.reconciles = make(chan pegnet.PegnetdHook, 10)
 	s.db = db
 	s.db.AutoMigrate(&EMA{})
 	s.db.AutoMigrate(&EntrySubmission{})
+	s.db.AutoMigrate(&Reconciliation{})
+	s.db.AutoMigrate(&ReconciliationIssue{})
 
 	// Load the latest ema
 	dbErr := s.db.Order("block_height desc").First(&s.currentEMA)
 	if dbErr.Error != nil && dbErr.Error != gorm.ErrRecordNotFound {
 		return nil, dbErr.Error
 	}
+	s.submitTarget = s.currentEMA.EMAValue
 
 	s.FactomClient = factomclient.FactomClientFromConfig(conf)
 
 	s.configuration.Cutoff = conf.GetInt(config.ConfigSubmitterCutoff)
 	s.configuration.EMANumPoints = conf.GetInt(config.ConfigSubmitterEMAN)
 	s.configuration.SoftMaxLimit = conf.GetInt(config.ConfigSubmitterEMAN)
+	s.configuration.Identity = conf.GetString(config.ConfigPoolIdentity)
+	s.configuration.CoinbaseAddress = conf.GetString(config.ConfigPoolCoinbase)
+	s.configuration.ECAlertThreshold = conf.GetInt64(config.ConfigSubmitterECAlert)
+	s.budget.PerBlock = conf.GetInt(config.ConfigSubmitterBlockBudget)
+	s.budget.PerDay = conf.GetInt(config.ConfigSubmitterDailyBudget)
+	s.configuration.Mode = conf.GetString(config.ConfigSubmitterMode)
+	s.configuration.BatchSize = conf.GetInt(config.ConfigSubmitterBatchSize)
+	s.configuration.BatchThreshold = conf.GetInt(config.ConfigSubmitterBatchThreshold)
+	s.ecBalance = -1
 	s.resetJobState()
 
+	switch s.configuration.Mode {
+	case ModeRolling, ModeMinute9, ModeAuto:
+	default:
+		return nil, fmt.Errorf("submit mode must be '%s', '%s' or '%s'", ModeRolling, ModeMinute9, ModeAuto)
+	}
+
 	if ec := conf.GetString(config.ConfigPoolESAddress); ec == "" {
 		return nil, fmt.Errorf("private entry credit address must be set")
 	} else {
@@ -107,12 +163,21 @@ func NewSubmitter(conf *viper.Viper, db *gorm.DB) (*Submitter, error) {
 
 func (s *Submitter) resetJobState() {
 	s.jobState.diffList = make([]uint64, s.configuration.SoftMaxLimit)
+	s.jobState.candidates = 0
+	s.jobState.batch = false
+	s.jobState.batched = nil
+	s.jobState.flushed = false
 }
 
 func (s *Submitter) SetSubmissions(shares <-chan *stratum.ShareSubmission) {
 	s.shares = shares
 }
 
+// SubmitTarget is the target shares must be above to be submitted
+func (s *Submitter) SubmitTarget() uint64 {
+	return atomic.LoadUint64(&s.submitTarget)
+}
+
 func (s Submitter) GetBlocksChannel() chan<- SubmissionJob {
 	return s.blocks
 }
@@ -130,14 +195,26 @@ func (s *Submitter) softMax(diff uint64) bool {
 }
 
 func (s *Submitter) Run(ctx context.Context) {
+	go s.monitorBalance(ctx, time.Minute)
+	go s.reconcileBlocks(ctx)
+	// The minute is polled for minute 9 submissions
+	minutes := time.NewTicker(time.Second)
+	defer minutes.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-minutes.C:
+			s.flushBatch()
 		case block := <-s.blocks:
 			// A new block indicates a new job
+			if s.currentJob != nil {
+				s.dropBatch()
+			}
+			lastCandidates := s.jobState.candidates
 			s.currentJob = block.Job
 			s.resetJobState()
+			s.jobState.batch = s.useBatch(lastCandidates)
 
 			set := block.Block.GradedBlock.Graded()
 			last, lastIndex := uint64(0), 0
@@ -174,12 +251,28 @@ func (s *Submitter) Run(ctx context.Context) {
 				if err != nil {
 					sLog.WithError(err).WithField("height", block.Block.Height).Errorf("failed to marshal opr")
 				}
+				if s.jobState.batch {
+					batchMode.Set(1)
+				} else {
+					batchMode.Set(0)
+				}
+				// Loading the spend keeps the budget across restarts
+				if err := LoadECBudgetSpend(s.db, &s.budget, block.Job.JobID, time.Now()); err != nil {
+					sLog.WithError(err).WithField("job", block.Job.JobID).Errorf("failed to load the ec spend")
+				}
 				sLog.WithFields(log.Fields{
-					"job": block.Job.JobID,
-					"ema": fmt.Sprintf("%x", ema.EMAValue),
+					"job":   block.Job.JobID,
+					"ema":   fmt.Sprintf("%x", ema.EMAValue),
+					"batch": s.jobState.batch,
 				}).Infof("ema share submit set")
 			}
 			s.currentEMA = ema
+			atomic.StoreUint64(&s.submitTarget, ema.EMAValue)
+			select {
+			case s.reconciles <- block.Block:
+			default:
+				sLog.WithField("height", block.Block.Height).Warnf("reconcile queue is full, block not reconciled")
+			}
 		case share := <-s.shares:
 			if share.JobID != s.currentJob.JobID {
 				continue // Invalid share
@@ -187,14 +280,20 @@ func (s *Submitter) Run(ctx context.Context) {
 
 			// If the target is above the ema target
 			if share.Target > s.currentEMA.EMAValue {
+				s.jobState.candidates++
+				if s.jobState.batch {
+					if s.jobState.flushed {
+						// Too late, the best shares are already submitted
+						s.blockShare(share, BatchBlock)
+					} else if dropped := s.bufferShare(share); dropped != nil {
+						s.blockShare(dropped, BatchBlock)
+					}
+					continue
+				}
+
 				if !s.softMax(share.Target) {
 					// Rejected, as we already submitted better shares this job.
-					_ = s.saveEntrySubmission(EntrySubmission{
-						ShareSubmission: *share,
-						EntryHash:       "0000000000000000000000000000000000000000000000000000000000000000",
-						CommitTxID:      "0000000000000000000000000000000000000000000000000000000000000000",
-						Blocked:         SoftMaxBlock,
-					})
+					s.blockShare(share, SoftMaxBlock)
 					sLog.WithFields(log.Fields{
 						"job":    share.JobID,
 						"target": fmt.Sprintf("%x", share.Target),
@@ -202,47 +301,132 @@ func (s *Submitter) Run(ctx context.Context) {
 					}).Debug("share found to submit, but blocked by softmax (this is good)")
 					continue // blocked
 				}
-
-				buf := make([]byte, 8)
-				binary.BigEndian.PutUint64(buf, share.Target)
-				oChain := factom.Bytes32(config.OPRChain)
-				entry := factom.Entry{
-					ChainID: &oChain,
-					ExtIDs: []factom.Bytes{
-						//	[0] the nonce for the entry
-						share.Nonce,
-						//	[1] Self reported difficulty
-						buf,
-						//  [2] Version number
-						[]byte{config.OPRVersion(uint32(share.JobID))},
-					},
-					Content: s.oprCopyData,
-				}
-				txid, err := entry.ComposeCreate(nil, s.FactomClient, s.configuration.ESAddress)
-				if err != nil {
-					sLog.WithError(err).WithField("job", share.JobID).Errorf("failed to submit opr")
-				} else {
-					err := s.saveEntrySubmission(EntrySubmission{
-						ShareSubmission: *share,
-						EntryHash:       entry.Hash.String(),
-						CommitTxID:      txid.String(),
-					})
-					if err != nil {
-						sLog.WithError(err).WithField("jobid", share.JobID).Errorf("failed to save entry submission")
-					} else {
-						sLog.WithFields(log.Fields{
-							"job":       share.JobID,
-							"entryhash": fmt.Sprintf("%s", entry.Hash.String()),
-							"target":    fmt.Sprintf("%x", share.Target),
-							"nonce":     fmt.Sprintf("%x", share.Nonce),
-						}).Debug("share submitted to factomd")
-					}
-				}
+				s.submitShare(share)
 			}
 		}
 	}
 }
 
+// submitShare submits the share to factomd, if it fits in the ec budget
+func (s *Submitter) submitShare(share *stratum.ShareSubmission) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, share.Target)
+	oChain := factom.Bytes32(config.OPRChain)
+	entry := factom.Entry{
+		ChainID: &oChain,
+		ExtIDs: []factom.Bytes{
+			//	[0] the nonce for the entry
+			share.Nonce,
+			//	[1] Self reported difficulty
+			buf,
+			//  [2] Version number
+			[]byte{config.OPRVersion(uint32(share.JobID))},
+		},
+		Content: s.oprCopyData,
+	}
+	cost, err := entry.Cost()
+	if err != nil {
+		sLog.WithError(err).WithField("job", share.JobID).Errorf("failed to cost opr")
+		return
+	}
+	if !s.budget.Allow(share.JobID, time.Now(), int(cost)) {
+		s.blockShare(share, BudgetBlock)
+		budgetBlocked.Inc()
+		jobSpent, daySpent := s.budget.Spent()
+		sLog.WithFields(log.Fields{
+			"job":      share.JobID,
+			"jobspent": jobSpent,
+			"dayspent": daySpent,
+		}).Debug("share found to submit, but blocked by the ec budget")
+		return // blocked
+	}
+
+	txid, err := entry.ComposeCreate(nil, s.FactomClient, s.configuration.ESAddress)
+	if err != nil {
+		sLog.WithError(err).WithField("job", share.JobID).Errorf("failed to submit opr")
+		return
+	}
+
+	s.budget.Spend(share.JobID, time.Now(), int(cost))
+	ecSpent.Add(float64(cost))
+	err = s.saveEntrySubmission(EntrySubmission{
+		ShareSubmission: *share,
+		EntryHash:       entry.Hash.String(),
+		CommitTxID:      txid.String(),
+		ECCost:          int(cost),
+	})
+	if err != nil {
+		sLog.WithError(err).WithField("jobid", share.JobID).Errorf("failed to save entry submission")
+	} else {
+		sLog.WithFields(log.Fields{
+			"job":       share.JobID,
+			"entryhash": fmt.Sprintf("%s", entry.Hash.String()),
+			"target":    fmt.Sprintf("%x", share.Target),
+			"nonce":     fmt.Sprintf("%x", share.Nonce),
+		}).Debug("share submitted to factomd")
+	}
+}
+
+// reconcileBlocks reconciles the graded blocks until the context is done
+func (s *Submitter) reconcileBlocks(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case block := <-s.reconciles:
+			s.reconcile(block)
+		}
+	}
+}
+
+// reconcile checks our submissions made it into the graded block, and any
+// issues are logged.
+func (s *Submitter) reconcile(block pegnet.PegnetdHook) {
+	rLog := sLog.WithField("height", block.Height)
+	r, err := Reconcile(s.db, block, s.configuration.Identity, s.configuration.CoinbaseAddress)
+	if err != nil {
+		rLog.WithError(err).Errorf("failed to reconcile block")
+		return
+	}
+
+	if r.Submitted == 0 && r.Unknown == 0 {
+		return
+	}
+
+	for _, issue := range r.Issues {
+		iLog := rLog.WithFields(log.Fields{"entryhash": issue.EntryHash, "issue": issue.Issue})
+		switch issue.Issue {
+		case IssueUnknown:
+			iLog.WithField("reward", issue.Reward).Warnf("graded opr with our identity that we did not submit")
+		case IssueVanished:
+			iLog.Warnf("submitted entry is not in the block")
+		}
+	}
+	rLog.Infof("reconciled: %s", r.Summary())
+}
+
+// SubmittedShares returns the shares of the job we submitted as the entries,
+// indexed by entry hash. Entries we did not submit are left out.
+func (s *Submitter) SubmittedShares(jobid int32, entryhashes []string) (map[string]stratum.ShareSubmission, error) {
+	return SubmittedShares(s.db, jobid, entryhashes)
+}
+
+// SubmittedShares returns the shares of the job submitted as the entries,
+// indexed by entry hash.
+func SubmittedShares(db *gorm.DB, jobid int32, entryhashes []string) (map[string]stratum.ShareSubmission, error) {
+	var subs []EntrySubmission
+	err := db.Where("job_id = ? AND entry_hash IN (?)", jobid, entryhashes).Find(&subs).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	shares := make(map[string]stratum.ShareSubmission)
+	for _, sub := range subs {
+		shares[sub.EntryHash] = sub.ShareSubmission
+	}
+	return shares, nil
+}
+
 // saveEntrySubmission will save a copy of the EntrySubmission to the database.
 // It's a copy because uint64s are not always safe to sql and we need to modify
 // it before saving
@@ -269,7 +453,9 @@ type EntrySubmission struct {
 	EntryHash  string `json:"entryhash"`
 	CommitTxID string `json:"committxid"`
 	// We might block some submissions for limiting reasons
-	Blocked int `json:"blocked",gorm:"default:0"`
+	Blocked int `gorm:"default:0" json:"blocked"`
+	// ECCost is the entry credits paid for the entry
+	ECCost int `gorm:"default:0" json:"eccost"`
 }
 
 // BeforeCreate
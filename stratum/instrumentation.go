@@ -0,0 +1,39 @@
+package stratum
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	validationQueueLength = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pool_stratum_validation_queue_length",
+		Help: "Number of share submissions waiting for a validation worker",
+	})
+	validationQueueFull = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pool_stratum_validation_queue_full_total",
+		Help: "Number of share submissions that waited on a full validation queue",
+	})
+	validationQueueTime = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pool_stratum_validation_queue_seconds",
+		Help:    "Time a share submission waits for a validation worker",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	})
+	validationTime = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pool_stratum_validation_seconds",
+		Help:    "Time to process a share submission",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	})
+)
+
+var prom sync.Once
+
+func RegisterPrometheus() {
+	prom.Do(func() {
+		prometheus.MustRegister(validationQueueLength)
+		prometheus.MustRegister(validationQueueFull)
+		prometheus.MustRegister(validationQueueTime)
+		prometheus.MustRegister(validationTime)
+	})
+}
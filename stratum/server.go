@@ -23,8 +23,11 @@ import (
 
 type Server struct {
 	// miners is a map of miners to their session id
-	Miners     *MinerMap
-	config     *viper.Viper
+	Miners *MinerMap
+	config *viper.Viper
+
+	// currentJob is read by the validation workers
+	jobLock    sync.RWMutex
 	currentJob *Job
 
 	// For any user authentication
@@ -32,10 +35,17 @@ type Server struct {
 
 	// Will assist in rejecting stale shares
 	ShareGate ShareCheck
+	// Shares above the submit threshold are always validated
+	SubmitThreshold SubmitThreshold
+	// Trust samples the shares to validate, if sampling
+	Trust *TrustKeeper
+	// Validators process the share submissions
+	Validators *ValidatorPool
 
 	configuration struct {
 		RequireAuth    bool // Require actual username from miners
 		ValidateShares bool
+		SampleShares   bool
 	}
 
 	// We forward submissions to any listeners
@@ -79,6 +89,7 @@ func NewServer(conf *viper.Viper) (*Server, error) {
 	s.configuration.RequireAuth = conf.GetBool(config.ConfigStratumRequireAuth)
 	// Stub this out so we don't get a nil dereference
 	s.ShareGate = new(AlwaysYesShareCheck)
+	s.SubmitThreshold = new(NoSubmitThreshold)
 	s.stratumPort = conf.GetInt(config.ConfigStratumPort)
 	s.welcomeMessage = conf.GetString(config.ConfigStratumWelcomeMessage)
 	s.configuration.ValidateShares = conf.GetBool(config.ConfigStratumCheckAllWork)
@@ -86,6 +97,26 @@ func NewServer(conf *viper.Viper) (*Server, error) {
 		InitLX()
 	}
 
+	s.Validators = NewValidatorPool(context.Background(), s, conf.GetInt(config.ConfigStratumValidationWorkers),
+		conf.GetInt(config.ConfigStratumValidationQueue))
+
+	s.configuration.SampleShares = conf.GetBool(config.ConfigStratumSampleShares)
+	s.Trust = NewTrustKeeper(conf.GetFloat64(config.ConfigStratumSampleDecay),
+		conf.GetFloat64(config.ConfigStratumSampleFloor), conf.GetDuration(config.ConfigStratumBanDuration))
+	if s.configuration.SampleShares {
+		// Sampling picks which shares are validated, so there must be
+		// validation to sample
+		if !s.configuration.ValidateShares {
+			return nil, fmt.Errorf("sampling shares requires validating shares")
+		}
+		if s.Trust.Decay <= 0 || s.Trust.Decay > 1 {
+			return nil, fmt.Errorf("sample decay must be above 0, and at most 1")
+		}
+		if s.Trust.Floor <= 0 || s.Trust.Floor > 1 {
+			return nil, fmt.Errorf("sample floor must be above 0, and at most 1")
+		}
+	}
+
 	return s, nil
 }
 
@@ -93,6 +124,10 @@ func (s *Server) SetShareCheck(sc ShareCheck) {
 	s.ShareGate = sc
 }
 
+func (s *Server) SetSubmitThreshold(st SubmitThreshold) {
+	s.SubmitThreshold = st
+}
+
 func (s *Server) SetAuthenticator(auth *authentication.Authenticator) {
 	s.Auth = auth
 }
@@ -100,10 +135,19 @@ func (s *Server) SetAuthenticator(auth *authentication.Authenticator) {
 // UpdateCurrentJob sets currently-active job details on the stratum server
 // and automatically pushes a notification to all connected miners
 func (s *Server) UpdateCurrentJob(job *Job) {
+	s.jobLock.Lock()
 	s.currentJob = job
+	s.jobLock.Unlock()
 	s.Notify(job)
 }
 
+// CurrentJob returns the job miners are working on
+func (s *Server) CurrentJob() *Job {
+	s.jobLock.RLock()
+	defer s.jobLock.RUnlock()
+	return s.currentJob
+}
+
 // Notify will notify all miners of a new block to mine
 func (s *Server) Notify(job *Job) {
 	jobReq := NotifyRequest(job.JobIDString(), job.OPRHash, "")
@@ -131,6 +175,7 @@ func (s *Server) Listen(ctx context.Context) {
 		case <-ctx.Done():
 			log.Infof("closing stratum server")
 			_ = server.Close()
+			s.Validators.Close()
 			return
 		}
 	}()
@@ -155,6 +200,10 @@ func (s *Server) Listen(ctx context.Context) {
 // NewConn handles all new conns from the listen. By factoring this out, we
 // can create unit tests using net.Pipe()
 func (s *Server) NewConn(conn net.Conn) {
+	if s.Trust.BannedIP(conn.RemoteAddr().String()) {
+		_ = conn.Close()
+		return
+	}
 	m := InitMiner(conn)
 	go s.HandleClient(m)
 	go s.HandleBroadcasts(m)
@@ -199,6 +248,14 @@ func InitMiner(conn net.Conn) *Miner {
 	return m
 }
 
+// Encode writes the message to the miner. Submissions are answered from
+// the validation workers, so all writes must go through here.
+func (m *Miner) Encode(v interface{}) error {
+	m.encSync.Lock()
+	defer m.encSync.Unlock()
+	return m.enc.Encode(v)
+}
+
 // Close shuts down miner's broadcast channel
 func (m *Miner) Close() {
 	close(m.broadcast)
@@ -235,9 +292,7 @@ func (s *Server) HandleBroadcasts(client *Miner) {
 			if !ok {
 				return
 			}
-			client.encSync.Lock()
-			err := client.enc.Encode(msg)
-			client.encSync.Unlock()
+			err := client.Encode(msg)
 			if err == io.EOF {
 				client.log.Infof("client disconnected")
 				return
@@ -269,9 +324,7 @@ func (s *Server) HandleClient(client *Miner) {
 			break
 		}
 
-		client.encSync.Lock()
 		s.HandleMessage(client, data)
-		client.encSync.Unlock()
 	}
 }
 
@@ -298,7 +351,7 @@ func (s *Server) HandleRequest(client *Miner, req Request) {
 	var params RPCParams
 	if err := req.FitParams(&params); err != nil {
 		client.log.WithField("method", req.Method).Warnf("bad params %s", req.Method)
-		_ = client.enc.Encode(QuickRPCError(req.ID, ErrorInvalidParams))
+		_ = client.Encode(QuickRPCError(req.ID, ErrorInvalidParams))
 		return
 	}
 
@@ -306,13 +359,13 @@ func (s *Server) HandleRequest(client *Miner, req Request) {
 	case "mining.authorize":
 		// "params": ["username,minerid", "password", "invitecode", "payoutaddress"]
 		if len(params) < 1 {
-			_ = client.enc.Encode(QuickRPCError(req.ID, ErrorInvalidParams))
+			_ = client.Encode(QuickRPCError(req.ID, ErrorInvalidParams))
 			return
 		}
 		// Ignore the session id if provided in the params
 		arr := strings.Split(params[0], ",")
 		if len(arr) != 2 {
-			_ = client.enc.Encode(HelpfulRPCError(req.ID, ErrorInvalidParams, "authorize requires 'username,minerid'"))
+			_ = client.Encode(HelpfulRPCError(req.ID, ErrorInvalidParams, "authorize requires 'username,minerid'"))
 			return
 		}
 
@@ -320,6 +373,11 @@ func (s *Server) HandleRequest(client *Miner, req Request) {
 		client.minerid = arr[1]
 		client.log = client.log.WithFields(log.Fields{"minerid": client.minerid, "username": client.username})
 
+		if s.Trust.Banned(client.username, client.minerid) {
+			_ = client.Encode(AuthorizeResponse(req.ID, false, nil))
+			return
+		}
+
 		if s.Auth != nil && s.configuration.RequireAuth {
 			if !s.Auth.Exists(client.username) {
 				// Did they provide a password, code, and payout addr?
@@ -329,7 +387,7 @@ func (s *Server) HandleRequest(client *Miner, req Request) {
 					// User rejected
 					// TODO: Provide a reason?
 					// TODO: Disconnect them?
-					if err := client.enc.Encode(AuthorizeResponse(req.ID, false, nil)); err != nil {
+					if err := client.Encode(AuthorizeResponse(req.ID, false, nil)); err != nil {
 						client.log.WithField("method", req.Method).WithError(err).Error("failed to send message")
 					}
 					return
@@ -337,7 +395,7 @@ func (s *Server) HandleRequest(client *Miner, req Request) {
 			}
 		}
 
-		if err := client.enc.Encode(AuthorizeResponse(req.ID, true, nil)); err != nil {
+		if err := client.Encode(AuthorizeResponse(req.ID, true, nil)); err != nil {
 			client.log.WithField("method", req.Method).WithError(err).Error("failed to send message")
 		} else {
 			client.authorized = true
@@ -345,47 +403,51 @@ func (s *Server) HandleRequest(client *Miner, req Request) {
 		}
 	case "mining.get_oprhash":
 		if len(params) < 1 {
-			_ = client.enc.Encode(QuickRPCError(req.ID, ErrorInvalidParams))
+			_ = client.Encode(QuickRPCError(req.ID, ErrorInvalidParams))
 			return
 		}
 
 		// TODO: actually retrieve OPR hash for the given jobID (for now using dummy data)
 		dummyOPRHash := "00011111af870a1f49129f9c82d935665d352fffffea3296208f6f7b16faaabc"
 
-		if err := client.enc.Encode(GetOPRHashResponse(req.ID, dummyOPRHash)); err != nil {
+		if err := client.Encode(GetOPRHashResponse(req.ID, dummyOPRHash)); err != nil {
 			client.log.WithField("method", req.Method).WithError(err).Error("failed to send message")
 		}
 	case "mining.submit":
 		// "params": ["username", "jobID", "nonce", "oprHash", "target"]
 		if len(params) < 5 {
-			_ = client.enc.Encode(QuickRPCError(req.ID, ErrorInvalidParams))
+			_ = client.Encode(QuickRPCError(req.ID, ErrorInvalidParams))
 			return
 		}
 
 		if params[0] != client.username {
-			_ = client.enc.Encode(HelpfulRPCError(req.ID, ErrorInvalidParams, "username not as expected"))
+			_ = client.Encode(HelpfulRPCError(req.ID, ErrorInvalidParams, "username not as expected"))
 			return
 		}
 
-		if !s.ProcessSubmission(client, params[1], params[2], params[3], params[4]) {
-			// Rejected share
-			// ignore errors on reject shares
-			_ = client.enc.Encode(SubmitResponse(req.ID, false, nil))
-			return
-		}
-
-		if err := client.enc.Encode(SubmitResponse(req.ID, true, nil)); err != nil {
-			client.log.WithField("method", req.Method).WithError(err).Error("failed to send message")
-		}
+		// The share is validated and answered by the validation workers.
+		// If they are behind, this blocks reading from the miner.
+		s.Validators.Submit(SubmitTask{
+			Miner:           client,
+			ID:              req.ID,
+			Username:        client.username,
+			MinerID:         client.minerid,
+			PreferredTarget: client.preferredTarget,
+			JobID:           params[1],
+			Nonce:           params[2],
+			OPRHash:         params[3],
+			Target:          params[4],
+			Job:             s.CurrentJob(),
+		})
 	case "mining.subscribe":
 		if len(params) < 1 {
-			_ = client.enc.Encode(QuickRPCError(req.ID, ErrorInvalidParams))
+			_ = client.Encode(QuickRPCError(req.ID, ErrorInvalidParams))
 			return
 		}
 		// Ignore the session id if provided in the params
 		client.agent = params[0]
 
-		if err := client.enc.Encode(SubscribeResponse(req.ID, client.sessionID, client.nonce)); err != nil {
+		if err := client.Encode(SubscribeResponse(req.ID, client.sessionID, client.nonce)); err != nil {
 			client.log.WithField("method", req.Method).WithError(err).Error("failed to send message")
 		} else {
 			client.subscribed = true
@@ -397,8 +459,8 @@ func (s *Server) HandleRequest(client *Miner, req Request) {
 				log.WithError(err).Error("failed to set target")
 			}
 			// Notify newly-subscribed client with current job details
-			if s.currentJob != nil {
-				err = s.SingleClientNotify(client.sessionID, s.currentJob.JobIDString(), s.currentJob.OPRHash, "")
+			if job := s.CurrentJob(); job != nil {
+				err = s.SingleClientNotify(client.sessionID, job.JobIDString(), job.OPRHash, "")
 				if err != nil {
 					log.WithError(err).Error("failed to send job")
 				}
@@ -406,7 +468,7 @@ func (s *Server) HandleRequest(client *Miner, req Request) {
 		}
 	case "mining.suggest_target":
 		if len(params) < 1 {
-			_ = client.enc.Encode(QuickRPCError(req.ID, ErrorInvalidParams))
+			_ = client.Encode(QuickRPCError(req.ID, ErrorInvalidParams))
 			return
 		}
 
@@ -417,18 +479,19 @@ func (s *Server) HandleRequest(client *Miner, req Request) {
 		//}
 	default:
 		client.log.Warnf("unknown method %s", req.Method)
-		_ = client.enc.Encode(QuickRPCError(req.ID, ErrorMethodNotFound))
+		_ = client.Encode(QuickRPCError(req.ID, ErrorMethodNotFound))
 	}
 }
 
 // ProcessSubmission will forward the shares and return if the share was accepted
-func (s *Server) ProcessSubmission(miner *Miner, jobID, nonce, oprHash, target string) bool {
-	sLog := log.WithFields(log.Fields{"user": miner.username, "miner": miner.minerid, "job": jobID})
-	if s.currentJob == nil {
+func (s *Server) ProcessSubmission(task SubmitTask) bool {
+	jobID, nonce, oprHash, target := task.JobID, task.Nonce, task.OPRHash, task.Target
+	sLog := log.WithFields(log.Fields{"user": task.Username, "miner": task.MinerID, "job": jobID})
+	if task.Job == nil {
 		return false // No current job
 	}
 
-	if jobID != s.currentJob.JobIDString() || oprHash != s.currentJob.OPRHash {
+	if jobID != task.Job.JobIDString() || oprHash != task.Job.OPRHash {
 		return false // Only accepts current job
 	}
 
@@ -451,7 +514,7 @@ func (s *Server) ProcessSubmission(miner *Miner, jobID, nonce, oprHash, target s
 		return false
 	}
 
-	if tU < miner.preferredTarget {
+	if tU < task.PreferredTarget {
 		return false
 	}
 
@@ -461,8 +524,12 @@ func (s *Server) ProcessSubmission(miner *Miner, jobID, nonce, oprHash, target s
 		return false
 	}
 
+	if s.Trust.Banned(task.Username, task.MinerID) {
+		return false
+	}
+
 	if s.configuration.ValidateShares {
-		if !Validate(oB, nB, tU) {
+		if !s.validate(task, oB, nB, tU) {
 			return false // Submitted a bad share
 		}
 	}
@@ -475,8 +542,8 @@ func (s *Server) ProcessSubmission(miner *Miner, jobID, nonce, oprHash, target s
 	}
 
 	submit := &ShareSubmission{
-		Username: miner.username,
-		MinerID:  miner.minerid,
+		Username: task.Username,
+		MinerID:  task.MinerID,
 		JobID:    int32(jobHeight),
 		OPRHash:  oB,
 		Nonce:    nB,
@@ -494,12 +561,38 @@ func (s *Server) ProcessSubmission(miner *Miner, jobID, nonce, oprHash, target s
 	return true
 }
 
+// validate checks the share is real work. If sampling, only some shares are
+// checked. A bad share bans and disconnects the miner.
+func (s *Server) validate(task SubmitTask, oprhash, nonce []byte, target uint64) bool {
+	if !s.configuration.SampleShares {
+		return Validate(oprhash, nonce, target)
+	}
+
+	if !s.Trust.ShouldValidate(task.Username, task.MinerID, target, s.SubmitThreshold.SubmitTarget()) {
+		return true
+	}
+
+	if Validate(oprhash, nonce, target) {
+		s.Trust.Passed(task.Username, task.MinerID)
+		return true
+	}
+
+	s.Trust.Failed(task.Username, task.MinerID, task.Miner.ip)
+	task.log().WithFields(log.Fields{
+		"target":   fmt.Sprintf("%x", target),
+		"duration": s.Trust.BanDuration,
+	}).Warn("miner submitted a bad share, banning the miner")
+	// Closing the connection ends the client's read loop
+	_ = task.Miner.conn.Close()
+	return false
+}
+
 func (s *Server) GetVersion(clientName string) error {
 	miner, err := s.Miners.GetMiner(clientName)
 	if err != nil {
 		return err
 	}
-	err = miner.enc.Encode(GetVersionRequest())
+	err = miner.Encode(GetVersionRequest())
 	return err
 }
 
@@ -508,7 +601,7 @@ func (s *Server) SingleClientNotify(clientName, jobID, oprHash, cleanjobs string
 	if err != nil {
 		return err
 	}
-	err = miner.enc.Encode(NotifyRequest(jobID, oprHash, cleanjobs))
+	err = miner.Encode(NotifyRequest(jobID, oprHash, cleanjobs))
 	return err
 }
 
@@ -517,7 +610,7 @@ func (s *Server) ReconnectClient(clientName, hostname, port, waittime string) er
 	if err != nil {
 		return err
 	}
-	err = miner.enc.Encode(ReconnectRequest(hostname, port, waittime))
+	err = miner.Encode(ReconnectRequest(hostname, port, waittime))
 	return err
 }
 
@@ -526,7 +619,7 @@ func (s *Server) SetTarget(clientName, target string) error {
 	if err != nil {
 		return err
 	}
-	err = miner.enc.Encode(SetTargetRequest(target))
+	err = miner.Encode(SetTargetRequest(target))
 	return err
 }
 
@@ -535,7 +628,7 @@ func (s *Server) SetNonce(clientName, nonce string) error {
 	if err != nil {
 		return err
 	}
-	err = miner.enc.Encode(SetNonceRequest(nonce))
+	err = miner.Encode(SetNonceRequest(nonce))
 	return err
 }
 
@@ -544,7 +637,7 @@ func (s *Server) ShowMessage(clientName, message string) error {
 	if err != nil {
 		return err
 	}
-	err = miner.enc.Encode(ShowMessageRequest(message))
+	err = miner.Encode(ShowMessageRequest(message))
 	return err
 }
 
@@ -553,7 +646,7 @@ func (s *Server) StopMining(clientName string) error {
 	if err != nil {
 		return err
 	}
-	err = miner.enc.Encode(StopMiningRequest())
+	err = miner.Encode(StopMiningRequest())
 	return err
 }
 
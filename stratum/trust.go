@@ -0,0 +1,133 @@
+package stratum
+
+import (
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// SubmitThreshold reports the target above which shares are submitted to
+// the chain. Those shares must always be validated.
+type SubmitThreshold interface {
+	SubmitTarget() uint64
+}
+
+// NoSubmitThreshold is used when nothing is submitted
+type NoSubmitThreshold struct{}
+
+func (NoSubmitThreshold) SubmitTarget() uint64 { return math.MaxUint64 }
+
+// TrustKeeper decides which shares to validate. A new miner has every share
+// validated. Each valid share lowers the rate its shares are validated at,
+// down to the floor. A bad share resets the miner's trust, and bans the
+// miner and its ip for the ban duration.
+type TrustKeeper struct {
+	sync.Mutex
+	// Each valid share multiplies the validation rate by the decay
+	Decay       float64
+	Floor       float64
+	BanDuration time.Duration
+
+	rates map[string]float64   // Indexed by trustKey
+	bans  map[string]time.Time // Indexed by trustKey or ip, the ban expiry
+
+	random *rand.Rand
+}
+
+func NewTrustKeeper(decay, floor float64, ban time.Duration) *TrustKeeper {
+	t := new(TrustKeeper)
+	t.Decay = decay
+	t.Floor = floor
+	t.BanDuration = ban
+	t.rates = make(map[string]float64)
+	t.bans = make(map[string]time.Time)
+	t.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return t
+}
+
+func trustKey(username, minerid string) string {
+	return username + "," + minerid
+}
+
+// ipHost drops the port of the address, if it has one
+func ipHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// Rate is the chance the miner's next share is validated
+func (t *TrustKeeper) Rate(username, minerid string) float64 {
+	t.Lock()
+	defer t.Unlock()
+	return t.rate(trustKey(username, minerid))
+}
+
+func (t *TrustKeeper) rate(key string) float64 {
+	r, ok := t.rates[key]
+	if !ok {
+		return 1
+	}
+	return r
+}
+
+// ShouldValidate returns true if the share needs to be validated. Shares
+// above the submit target are always validated.
+func (t *TrustKeeper) ShouldValidate(username, minerid string, target, submitTarget uint64) bool {
+	if target > submitTarget {
+		return true
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	return t.random.Float64() < t.rate(trustKey(username, minerid))
+}
+
+// Passed lowers the validation rate of the miner
+func (t *TrustKeeper) Passed(username, minerid string) {
+	t.Lock()
+	defer t.Unlock()
+	key := trustKey(username, minerid)
+	t.rates[key] = math.Max(t.Floor, t.rate(key)*t.Decay)
+}
+
+// Failed resets the miner's trust, and bans the miner and the ip
+func (t *TrustKeeper) Failed(username, minerid, ip string) {
+	t.Lock()
+	defer t.Unlock()
+	key := trustKey(username, minerid)
+	delete(t.rates, key)
+	if t.BanDuration > 0 {
+		expires := time.Now().Add(t.BanDuration)
+		t.bans[key] = expires
+		t.bans[ipHost(ip)] = expires
+	}
+}
+
+// Banned returns true if the miner is banned
+func (t *TrustKeeper) Banned(username, minerid string) bool {
+	return t.banned(trustKey(username, minerid))
+}
+
+// BannedIP returns true if the ip is banned
+func (t *TrustKeeper) BannedIP(ip string) bool {
+	return t.banned(ipHost(ip))
+}
+
+func (t *TrustKeeper) banned(key string) bool {
+	t.Lock()
+	defer t.Unlock()
+	expires, ok := t.bans[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expires) {
+		delete(t.bans, key)
+		return false
+	}
+	return true
+}
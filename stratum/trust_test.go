@@ -0,0 +1,66 @@
+package stratum_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	. "github.com/FactomWyomingEntity/prosper-pool/stratum"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustKeeper(t *testing.T) {
+	require := require.New(t)
+	k := NewTrustKeeper(0.5, 0.1, time.Hour)
+
+	// New miners have every share validated
+	require.Equal(1.0, k.Rate("user", "rig1"))
+	for i := 0; i < 10; i++ {
+		require.True(k.ShouldValidate("user", "rig1", 1, math.MaxUint64))
+	}
+
+	k.Passed("user", "rig1")
+	require.Equal(0.5, k.Rate("user", "rig1"))
+	for i := 0; i < 10; i++ {
+		k.Passed("user", "rig1")
+	}
+	require.Equal(0.1, k.Rate("user", "rig1"), "the rate stops at the floor")
+
+	// Shares that will be submitted are always validated
+	for i := 0; i < 100; i++ {
+		require.True(k.ShouldValidate("user", "rig1", 10, 5))
+	}
+
+	// A failure resets the trust, and bans the miner and ip
+	require.False(k.BannedIP("10.0.0.1:4000"))
+	k.Failed("user", "rig1", "10.0.0.1:4000")
+	require.Equal(1.0, k.Rate("user", "rig1"))
+	require.True(k.Banned("user", "rig1"))
+	require.True(k.BannedIP("10.0.0.1:5000"))
+	require.False(k.Banned("user", "rig2"))
+
+	// Bans expire
+	k = NewTrustKeeper(0.5, 0.1, time.Millisecond)
+	k.Failed("user", "rig1", "10.0.0.1:4000")
+	time.Sleep(time.Millisecond * 5)
+	require.False(k.Banned("user", "rig1"))
+	require.False(k.BannedIP("10.0.0.1:4000"))
+}
+
+func TestNewServer_SampleShares(t *testing.T) {
+	require := require.New(t)
+	conf := viper.New()
+	config.SetDefaults(conf)
+	conf.Set(config.ConfigStratumSampleShares, true)
+
+	// There is nothing to sample without validating shares
+	conf.Set(config.ConfigStratumCheckAllWork, false)
+	_, err := NewServer(conf)
+	require.Error(err)
+
+	conf.Set(config.ConfigStratumSampleShares, false)
+	_, err = NewServer(conf)
+	require.NoError(err)
+}
@@ -0,0 +1,128 @@
+package stratum
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SubmitTask is a mining.submit waiting to be processed
+type SubmitTask struct {
+	Miner *Miner
+	ID    int32 // The request id the response is for
+
+	// The miner's fields are copied when the share is submitted, as the
+	// miner's routine can change them while the task waits.
+	Username, MinerID string
+	PreferredTarget   uint64
+
+	JobID, Nonce, OPRHash, Target string
+	// Job is the current job when the share was submitted, which the share
+	// must be for.
+	Job *Job
+
+	queued time.Time
+}
+
+// ValidatorPool processes share submissions on a fixed number of workers, so
+// validating shares does not hold up a miner's connection. The queue is
+// bounded, and once full, submitting blocks until there is room. Responses
+// are sent by the workers, and are matched to the submit by the request id.
+type ValidatorPool struct {
+	server  *Server
+	Workers int
+	tasks   chan SubmitTask
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewValidatorPool starts the workers, which run until the context is done,
+// or the pool is closed. With no workers, submissions are processed on the
+// caller's routine.
+func NewValidatorPool(ctx context.Context, s *Server, workers, queue int) *ValidatorPool {
+	p := new(ValidatorPool)
+	p.server = s
+	p.Workers = workers
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	if workers <= 0 {
+		return p
+	}
+
+	p.tasks = make(chan SubmitTask, queue)
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Close stops the workers, and waits for them to finish the share they are
+// validating. Queued shares are dropped.
+func (p *ValidatorPool) Close() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// Submit queues the submission, blocking if the queue is full. Once the pool
+// is closed, submissions are dropped.
+func (p *ValidatorPool) Submit(task SubmitTask) {
+	task.queued = time.Now()
+	if p.Workers <= 0 {
+		p.process(task)
+		return
+	}
+
+	select {
+	case p.tasks <- task:
+	case <-p.ctx.Done():
+		return
+	default:
+		validationQueueFull.Inc()
+		select {
+		case p.tasks <- task:
+		case <-p.ctx.Done():
+			return
+		}
+	}
+	validationQueueLength.Set(float64(len(p.tasks)))
+}
+
+func (p *ValidatorPool) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case task := <-p.tasks:
+			validationQueueLength.Set(float64(len(p.tasks)))
+			p.process(task)
+		}
+	}
+}
+
+func (p *ValidatorPool) process(task SubmitTask) {
+	validationQueueTime.Observe(time.Since(task.queued).Seconds())
+
+	start := time.Now()
+	accepted := p.server.ProcessSubmission(task)
+	validationTime.Observe(time.Since(start).Seconds())
+
+	if !accepted {
+		// Rejected share
+		// ignore errors on reject shares
+		_ = task.Miner.Encode(SubmitResponse(task.ID, false, nil))
+		return
+	}
+
+	if err := task.Miner.Encode(SubmitResponse(task.ID, true, nil)); err != nil {
+		task.log().WithField("method", "mining.submit").WithError(err).Error("failed to send message")
+	}
+}
+
+func (task SubmitTask) log() *log.Entry {
+	return log.WithFields(log.Fields{"ip": task.Miner.ip, "minerid": task.MinerID, "username": task.Username})
+}
@@ -0,0 +1,78 @@
+package stratum_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	. "github.com/FactomWyomingEntity/prosper-pool/stratum"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatorPool(t *testing.T) {
+	require := require.New(t)
+	conf := viper.New()
+	config.SetDefaults(conf)
+	conf.Set(config.ConfigStratumCheckAllWork, false)
+	conf.Set(config.ConfigStratumValidationWorkers, 2)
+	conf.Set(config.ConfigStratumValidationQueue, 2)
+
+	s, err := NewServer(conf)
+	require.NoError(err)
+	srv, cli := net.Pipe()
+	s.NewConn(srv)
+
+	// Submits are answered by the workers, in any order
+	go func() {
+		for i := 1; i <= 10; i++ {
+			_, _ = fmt.Fprintf(cli, `{"id":%d,"method":"mining.submit","params":["","1","00","00","00"]}`+"\n", i)
+		}
+	}()
+
+	r := bufio.NewReader(cli)
+	seen := make(map[int32]bool)
+	for len(seen) < 10 {
+		data, _, err := r.ReadLine()
+		require.NoError(err)
+		var resp Response
+		require.NoError(json.Unmarshal(data, &resp))
+		// There is no job, so every share is rejected
+		require.Equal("false", string(resp.Result))
+		seen[resp.ID] = true
+	}
+	for i := int32(1); i <= 10; i++ {
+		require.True(seen[i], "no response for %d", i)
+	}
+}
+
+func TestValidatorPool_Close(t *testing.T) {
+	require := require.New(t)
+	conf := viper.New()
+	config.SetDefaults(conf)
+	conf.Set(config.ConfigStratumCheckAllWork, false)
+	conf.Set(config.ConfigStratumValidationWorkers, 2)
+	conf.Set(config.ConfigStratumValidationQueue, 1)
+
+	s, err := NewServer(conf)
+	require.NoError(err)
+	s.Validators.Close()
+
+	// Nothing is validated once closed, so a full queue drops submissions
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			s.Validators.Submit(SubmitTask{})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("submit blocked after the pool closed")
+	}
+}
@@ -0,0 +1,117 @@
+package treasury
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/factomclient"
+	"github.com/spf13/viper"
+)
+
+// ErrInsufficientBalance is returned when the treasury cannot cover a payout
+var ErrInsufficientBalance = errors.New("insufficient PEG balance")
+
+// BalanceSource returns the pegnet balances of an address by ticker, in
+// the smallest unit.
+type BalanceSource interface {
+	Balances(ctx context.Context, adr factom.FAAddress) (map[string]int64, error)
+}
+
+// Treasury is the address the pool pays users from. Payouts are checked
+// against it's balance, so a short balance does not waste entry credits on a
+// transaction pegnet will reject.
+type Treasury struct {
+	Address factom.FAAddress
+	Source  BalanceSource
+}
+
+// New returns the treasury of the address the pool pays from
+func New(conf *viper.Viper) (*Treasury, error) {
+	t := new(Treasury)
+	var err error
+	t.Address, err = SourceAddress(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Source = PegnetdBalances{
+		Client:   factomclient.FactomClientFromConfig(conf),
+		Location: conf.GetString(config.ConfigPegnetdLocation),
+	}
+	return t, nil
+}
+
+// SourceAddress returns the address the pool pays from, which is the payout
+// source, or the pool coinbase if the pool has no payout source.
+func SourceAddress(conf *viper.Viper) (factom.FAAddress, error) {
+	address := conf.GetString(config.ConfigPayoutSource)
+	if address == "" {
+		address = conf.GetString(config.ConfigPoolCoinbase)
+	}
+
+	source, err := factom.NewFAAddress(address)
+	if err != nil {
+		return source, fmt.Errorf("payout source address: %s", err.Error())
+	}
+	return source, nil
+}
+
+// Balance returns the PEG balance of the treasury
+func (t *Treasury) Balance(ctx context.Context) (int64, error) {
+	balances, err := t.Source.Balances(ctx, t.Address)
+	if err != nil {
+		return 0, err
+	}
+	return balances["PEG"], nil
+}
+
+// Check returns ErrInsufficientBalance if the treasury holds less PEG than
+// the total. The balance is returned even if it is short.
+func (t *Treasury) Check(ctx context.Context, total int64) (int64, error) {
+	balance, err := t.Balance(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if balance < total {
+		return balance, ErrInsufficientBalance
+	}
+	return balance, nil
+}
+
+// PegnetdBalances asks pegnetd for the balances
+type PegnetdBalances struct {
+	Client   *factom.Client
+	Location string
+}
+
+func (p PegnetdBalances) Balances(ctx context.Context, adr factom.FAAddress) (map[string]int64, error) {
+	var result map[string]int64
+	params := struct {
+		Address string `json:"address"`
+	}{Address: adr.String()}
+
+	err := p.Client.Factomd.Request(ctx, p.Location, "get-pegnet-balances", params, &result)
+	if err != nil {
+		// pegnetd has never seen the address
+		if strings.Contains(err.Error(), "Not Found") {
+			return map[string]int64{}, nil
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// StaticBalances is a local stand-in for pegnetd, with balances by address
+type StaticBalances map[string]map[string]int64
+
+func (s StaticBalances) Balances(ctx context.Context, adr factom.FAAddress) (map[string]int64, error) {
+	balances, ok := s[adr.String()]
+	if !ok {
+		return nil, fmt.Errorf("no balances for %s", adr.String())
+	}
+	return balances, nil
+}
@@ -0,0 +1,78 @@
+package treasury_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	. "github.com/FactomWyomingEntity/prosper-pool/treasury"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTreasury_Check(t *testing.T) {
+	require := require.New(t)
+	adr, err := factom.NewFAAddress("FA2jK2HcLnRdS94dEcU27rF3meoJfpUcZPSinpb7AwQvPRY6RL1Q")
+	require.NoError(err)
+
+	tr := &Treasury{Address: adr, Source: StaticBalances{
+		adr.String(): {"PEG": 100e8, "pUSD": 5e8},
+	}}
+
+	bal, err := tr.Check(context.Background(), 100e8)
+	require.NoError(err)
+	require.Equal(int64(100e8), bal)
+
+	bal, err = tr.Check(context.Background(), 101e8)
+	require.Equal(ErrInsufficientBalance, err)
+	require.Equal(int64(100e8), bal)
+
+	// Unknown balances are not insufficient
+	tr.Source = StaticBalances{}
+	_, err = tr.Check(context.Background(), 1)
+	require.Error(err)
+	require.NotEqual(ErrInsufficientBalance, err)
+}
+
+func TestPegnetdBalances(t *testing.T) {
+	require := require.New(t)
+	adr, err := factom.NewFAAddress("FA2jK2HcLnRdS94dEcU27rF3meoJfpUcZPSinpb7AwQvPRY6RL1Q")
+	require.NoError(err)
+
+	// A stand-in for pegnetd that only knows one address
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     interface{} `json:"id"`
+			Method string      `json:"method"`
+			Params struct {
+				Address string `json:"address"`
+			} `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		if req.Method == "get-pegnet-balances" && req.Params.Address == adr.String() {
+			resp["result"] = map[string]int64{"PEG": 42e8}
+		} else {
+			resp["error"] = map[string]interface{}{"code": -32800, "message": "Not Found"}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	p := PegnetdBalances{Client: factom.NewClient(), Location: srv.URL}
+	balances, err := p.Balances(context.Background(), adr)
+	require.NoError(err)
+	require.Equal(int64(42e8), balances["PEG"])
+
+	other, err := factom.NewFAAddress("FA3EPZYqodgyEGXNMbiZKE5TS2x2J9wF8J9MvPZb52iGR78xMgCb")
+	require.NoError(err)
+	balances, err = p.Balances(context.Background(), other)
+	require.NoError(err)
+	require.Zero(balances["PEG"])
+}
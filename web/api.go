@@ -94,6 +94,43 @@ func (s *HttpServices) EntrySubmissions(r *http.Request, args *EntrySubmissionPa
 	return nil
 }
 
+type ReconciliationParams struct {
+	Height int32 `json:"height"`
+	database.PaginationParams
+}
+
+type ReconciliationResponse struct {
+	Data       []sharesubmit.Reconciliation `json:"data"`
+	Pagination database.PaginationResponse  `json:"info"`
+}
+
+// Reconciliations returns how our submissions fared in each graded block
+func (s *HttpServices) Reconciliations(r *http.Request, args *ReconciliationParams, reply *ReconciliationResponse) error {
+	args.Default(50, "desc", "height").Max(MaxLimit)
+	db, err := database.SimplePagination(s.db, args.PaginationParams)
+	if err != nil {
+		return err
+	}
+
+	// Filter
+	if args.Height != 0 {
+		db = db.Where("height = ?", args.Height)
+	}
+
+	err = db.Preload("Issues").Find(&reply.Data).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil // No records
+	}
+	if err != nil {
+		return err
+	}
+
+	total := database.TotalCount(db.Model(&sharesubmit.Reconciliation{}))
+	reply.Pagination.TotalRecords = total
+	reply.Pagination.Records = len(reply.Data)
+	return nil
+}
+
 func (s *HttpServices) SubmitSync(r *http.Request, _ *json.RawMessage, reply *minutekeeper.MinuteKeeperStatus) error {
 	*reply = s.MinuteKeeper.Status()
 	return nil
@@ -0,0 +1,103 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+)
+
+// AdminAudit displays the audit report for a range of jobs. Adding
+// 'format=json' returns the report as json.
+func (s *HttpServices) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	from, _ := strconv.ParseInt(r.FormValue("from"), 10, 32)
+	to, _ := strconv.ParseInt(r.FormValue("to"), 10, 32)
+
+	report, err := accounting.Audit(s.db, int32(from), int32(to),
+		s.conf.GetString(config.ConfigPoolIdentity), s.conf.GetString(config.ConfigPoolCoinbase))
+
+	if r.FormValue("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	w.Write(s.AdminNav())
+	_, _ = fmt.Fprintf(w, `
+	<form method="get" action="/admin/audit">
+		From <input name="from" value="%d" />
+		To <input name="to" value="%d" placeholder="0 is the latest" />
+		<input type="submit" value="Audit" />
+		<a href="/admin/audit?from=%d&to=%d&format=json">json</a>
+	</form>
+	`, from, to, from, to)
+
+	w.Write([]byte("<pre>"))
+	defer w.Write([]byte("</pre>"))
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "Error:%s", html.EscapeString(err.Error()))
+		return
+	}
+
+	WriteAuditReport(&htmlEscaper{w}, report)
+}
+
+// WriteAuditReport writes the human readable audit report
+func WriteAuditReport(w io.Writer, r *accounting.AuditReport) {
+	peg := SignedFactoshiToFactoid
+
+	_, _ = fmt.Fprintf(w, "Audited %d jobs from %d to %d\n", r.Jobs, r.FirstJob, r.LastJob)
+	_, _ = fmt.Fprintf(w, "%24s: %s\n", "Rewards", peg(r.TotalRewards))
+	_, _ = fmt.Fprintf(w, "%24s: %s\n", "Graded block rewards", peg(r.ChainRewards))
+	_, _ = fmt.Fprintf(w, "%24s: %s\n", "Dust carried in", peg(r.CarriedIn))
+	_, _ = fmt.Fprintf(w, "%24s: %s\n", "Owed to users", peg(r.TotalOwed))
+	_, _ = fmt.Fprintf(w, "%24s: %s\n", "Pool fees", peg(r.TotalFees))
+	_, _ = fmt.Fprintf(w, "%24s: %s\n", "Referrals", peg(r.TotalReferrals))
+	_, _ = fmt.Fprintf(w, "%24s: %s\n", "Finder bonuses", peg(r.TotalFinderBonuses))
+	_, _ = fmt.Fprintf(w, "%24s: %s\n", "Dust outstanding", peg(r.Outstanding))
+	_, _ = fmt.Fprintf(w, "All time\n")
+	_, _ = fmt.Fprintf(w, "%24s: %s\n", "Owed to users", peg(r.TotalOwedAllTime))
+	_, _ = fmt.Fprintf(w, "%24s: %s\n", "Paid to users", peg(r.TotalPaid))
+	_, _ = fmt.Fprintf(w, "%24s: %s\n", "Outstanding to users", peg(r.UserOutstanding))
+
+	_, _ = fmt.Fprintf(w, "%d users\n", len(r.Users))
+	for _, u := range r.Users {
+		_, _ = fmt.Fprintf(w, "\t%s -> Owed: %s, Paid: %s, Outstanding: %s\n",
+			u.UserID, peg(u.Owed), peg(u.Paid), peg(u.Outstanding))
+	}
+
+	_, _ = fmt.Fprintf(w, "%d discrepancies\n", len(r.Discrepancies))
+	for _, d := range r.Discrepancies {
+		if d.UserID != "" {
+			_, _ = fmt.Fprintf(w, "\tUser %s: %s\n", d.UserID, d.Reason)
+		} else {
+			_, _ = fmt.Fprintf(w, "\tJob %d: %s\n", d.JobID, d.Reason)
+		}
+	}
+
+	if r.Balanced() {
+		_, _ = fmt.Fprintf(w, "The books balance\n")
+	} else {
+		_, _ = fmt.Fprintf(w, "The books do not balance\n")
+	}
+}
+
+// htmlEscaper escapes everything written, as user ids are user input
+type htmlEscaper struct {
+	w io.Writer
+}
+
+func (e *htmlEscaper) Write(p []byte) (int, error) {
+	_, err := io.WriteString(e.w, html.EscapeString(string(p)))
+	return len(p), err
+}
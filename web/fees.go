@@ -0,0 +1,91 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/http"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/shopspring/decimal"
+)
+
+// AdminFees lists all user fee rates, and accepts a form post to add a new
+// fee rate.
+func (s *HttpServices) AdminFees(w http.ResponseWriter, r *http.Request) {
+	w.Write(s.AdminNav())
+
+	if r.Method == http.MethodPost {
+		if err := s.addFeeRate(r); err != nil {
+			_, _ = fmt.Fprintf(w, "<pre>Error:%s</pre>", html.EscapeString(err.Error()))
+		} else {
+			_, _ = fmt.Fprintf(w, "<pre>Fee rate added</pre>")
+		}
+	}
+
+	w.Write([]byte(`
+	<form method="post" action="/admin/fees">
+		User <input name="userid" />
+		Rate <input name="rate" placeholder="0.02" />
+		From <input name="from" placeholder="2006-01-02 or RFC3339" />
+		Until <input name="until" placeholder="2006-01-02 or RFC3339" />
+		Note <input name="note" />
+		<input type="submit" value="Add" />
+	</form>
+	`))
+
+	w.Write([]byte("<pre>"))
+	defer w.Write([]byte("</pre>"))
+
+	var fees []accounting.UserFeeRate
+	dbErr := s.db.Order("user_id asc, effective_from asc").Find(&fees)
+	if dbErr.Error != nil {
+		_, _ = w.Write([]byte(dbErr.Error.Error()))
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("This page displays the %d user fee rates. The pool fee rate applies to all other users.\n", len(fees)))
+	for _, f := range fees {
+		until := "never"
+		if f.EffectiveUntil != nil {
+			until = f.EffectiveUntil.UTC().String()
+		}
+		buf.WriteString(fmt.Sprintf("\t%d -> User: %s, Rate: %s, From: %s, Until: %s, Note: %s\n",
+			f.ID, html.EscapeString(f.UserID), f.FeeRate.String(), f.EffectiveFrom.UTC(), until,
+			html.EscapeString(f.Note)))
+	}
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (s *HttpServices) addFeeRate(r *http.Request) error {
+	rate, err := decimal.NewFromString(r.FormValue("rate"))
+	if err != nil {
+		return err
+	}
+
+	f := accounting.UserFeeRate{
+		UserID:        r.FormValue("userid"),
+		FeeRate:       rate,
+		EffectiveFrom: time.Now(),
+		Note:          r.FormValue("note"),
+	}
+
+	if from := r.FormValue("from"); from != "" {
+		f.EffectiveFrom, err = accounting.ParseDate(from)
+		if err != nil {
+			return err
+		}
+	}
+
+	if until := r.FormValue("until"); until != "" {
+		u, err := accounting.ParseDate(until)
+		if err != nil {
+			return err
+		}
+		f.EffectiveUntil = &u
+	}
+
+	return accounting.AddUserFeeRate(s.db, &f)
+}
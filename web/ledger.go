@@ -0,0 +1,31 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/FactomWyomingEntity/prosper-pool/ledger"
+)
+
+type LedgerProofParams struct {
+	JobID int32 `json:"jobid"`
+}
+
+// LedgerProof returns the proof the logged in user's owed payout for the job
+// is in the ledger published on chain.
+func (s *HttpServices) LedgerProof(r *http.Request, args *LedgerProofParams, reply *ledger.Proof) error {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		return err
+	}
+	if s.Ledger == nil {
+		return fmt.Errorf("the pool does not publish a ledger")
+	}
+
+	proof, err := s.Ledger.Proof(args.JobID, user.UID)
+	if err != nil {
+		return err
+	}
+	*reply = *proof
+	return nil
+}
@@ -0,0 +1,222 @@
+package web
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/jinzhu/gorm"
+)
+
+// UserPayment is a payment made to the user
+type UserPayment struct {
+	Time          time.Time `json:"time"`
+	EntryHash     string    `json:"entryhash"`
+	PayoutAddress string    `json:"payoutaddress"`
+	Amount        int64     `json:"amount"` // In PEG
+}
+
+type PaymentsResponse struct {
+	Data       []UserPayment               `json:"data"`
+	Pagination database.PaginationResponse `json:"info"`
+}
+
+// Payments returns the payment history of the logged in user
+func (s *HttpServices) Payments(r *http.Request, args *database.PaginationParams, reply *PaymentsResponse) error {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	args.Default(50, "desc", "id").Max(MaxLimit)
+	reply.Data, reply.Pagination.TotalRecords, err = s.userPayments(user.UID, *args)
+	reply.Pagination.Records = len(reply.Data)
+	return err
+}
+
+type StatementsParams struct {
+	Period string `json:"period"`
+	database.PaginationParams
+}
+
+type StatementsResponse struct {
+	Data       []accounting.UserStatement  `json:"data"`
+	Pagination database.PaginationResponse `json:"info"`
+}
+
+// Statements returns the statements of the logged in user, newest first. The
+// order and column are not used.
+func (s *HttpServices) Statements(r *http.Request, args *StatementsParams, reply *StatementsResponse) error {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	if args.Period == "" {
+		args.Period = accounting.PeriodMonth
+	}
+	args.Default(50, "", "").Max(MaxLimit)
+	reply.Data, reply.Pagination.TotalRecords, err = s.userStatements(user.UID, args.Period, args.PaginationParams)
+	reply.Pagination.Records = len(reply.Data)
+	return err
+}
+
+// UserPayments displays the payments of the current user. Adding
+// ?format=csv exports all of them.
+func (s *HttpServices) UserPayments(w http.ResponseWriter, r *http.Request) {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		writePage(w, s, fmt.Sprintf("Error:%s", html.EscapeString(err.Error())))
+		return
+	}
+
+	params := pageParams(r).Default(100, "desc", "id")
+	if r.URL.Query().Get("format") == "csv" {
+		params.Limit, params.Offset = 0, 0
+	}
+	payments, total, err := s.userPayments(user.UID, *params)
+	if err != nil {
+		writePage(w, s, fmt.Sprintf("Error:%s", html.EscapeString(err.Error())))
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		records := [][]string{{"time", "entryhash", "payoutaddress", "peg"}}
+		for _, p := range payments {
+			records = append(records, []string{p.Time.UTC().Format(time.RFC3339), p.EntryHash, p.PayoutAddress,
+				SignedFactoshiToFactoid(p.Amount)})
+		}
+		writeCSV(w, "payments.csv", records)
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("Showing %d of %d payments for %s. <a href=\"?format=csv\">Export CSV</a>\n\n",
+		len(payments), total, html.EscapeString(user.UID)))
+	for _, p := range payments {
+		buf.WriteString(fmt.Sprintf("\t%s, PEG: %s, Address: %s, EntryHash: %s\n",
+			p.Time.UTC().Format(time.RFC3339), SignedFactoshiToFactoid(p.Amount),
+			html.EscapeString(p.PayoutAddress), html.EscapeString(p.EntryHash)))
+	}
+	writePage(w, s, buf.String())
+}
+
+// UserStatements displays the statements of the current user by ?period=,
+// which is a month by default. Adding ?format=csv exports all of them.
+func (s *HttpServices) UserStatements(w http.ResponseWriter, r *http.Request) {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		writePage(w, s, fmt.Sprintf("Error:%s", html.EscapeString(err.Error())))
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = accounting.PeriodMonth
+	}
+	params := pageParams(r).Default(100, "", "")
+	if r.URL.Query().Get("format") == "csv" {
+		params.Limit, params.Offset = 0, 0
+	}
+	statements, total, err := s.userStatements(user.UID, period, *params)
+	if err != nil {
+		writePage(w, s, fmt.Sprintf("Error:%s", html.EscapeString(err.Error())))
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		records := [][]string{{"start", "end", "jobs", "owed", "fees", "payments", "paid", "balance"}}
+		for _, st := range statements {
+			records = append(records, []string{st.Start.Format("2006-01-02"), st.End.Format("2006-01-02"),
+				strconv.Itoa(st.Jobs), SignedFactoshiToFactoid(st.Owed), SignedFactoshiToFactoid(st.Fees),
+				strconv.Itoa(st.Payments), SignedFactoshiToFactoid(st.Paid), SignedFactoshiToFactoid(st.Balance)})
+		}
+		writeCSV(w, fmt.Sprintf("statements-%s.csv", period), records)
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("Statements by <a href=\"?period=day\">day</a>, <a href=\"?period=week\">week</a>, "+
+		"<a href=\"?period=month\">month</a>. <a href=\"?period=%s&format=csv\">Export CSV</a>\n", period))
+	buf.WriteString(fmt.Sprintf("Showing %d of %d %s statements for %s. Jobs are dated by when their block was synced.\n\n",
+		len(statements), total, period, html.EscapeString(user.UID)))
+	for _, st := range statements {
+		buf.WriteString(fmt.Sprintf("\t%s to %s, Jobs: %d, Owed: %s PEG, Fees: %s PEG, Paid: %s PEG (%d payments), Balance: %s PEG\n",
+			st.Start.Format("2006-01-02"), st.End.Format("2006-01-02"), st.Jobs,
+			SignedFactoshiToFactoid(st.Owed), SignedFactoshiToFactoid(st.Fees),
+			SignedFactoshiToFactoid(st.Paid), st.Payments, SignedFactoshiToFactoid(st.Balance)))
+	}
+	writePage(w, s, buf.String())
+}
+
+func (s *HttpServices) userPayments(userid string, params database.PaginationParams) ([]UserPayment, int, error) {
+	db, err := database.SimplePagination(s.db, params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var paid []accounting.Paid
+	db = db.Where("user_id = ?", userid)
+	err = db.Find(&paid).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, 0, err
+	}
+
+	payments := make([]UserPayment, len(paid))
+	for i, p := range paid {
+		payments[i] = UserPayment{Time: p.CreatedAt, EntryHash: p.EntryHash, PayoutAddress: p.PayoutAddress, Amount: p.PaymentAmount}
+	}
+	return payments, database.TotalCount(s.db.Model(&accounting.Paid{}).Where("user_id = ?", userid)), nil
+}
+
+func (s *HttpServices) userStatements(userid, period string, params database.PaginationParams) ([]accounting.UserStatement, int, error) {
+	statements, err := accounting.UserStatements(s.db, userid, period)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if params.Offset < 0 {
+		return nil, 0, fmt.Errorf("offset cannot be negative")
+	}
+
+	total := len(statements)
+	if int(params.Offset) >= len(statements) {
+		return nil, total, nil
+	}
+	statements = statements[params.Offset:]
+	if params.Limit > 0 && int(params.Limit) < len(statements) {
+		statements = statements[:params.Limit]
+	}
+	return statements, total, nil
+}
+
+// pageParams reads the ?limit= and ?offset= of a page
+func pageParams(r *http.Request) *database.PaginationParams {
+	var params database.PaginationParams
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+		params.Limit = int32(limit)
+	}
+	if offset, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && offset > 0 {
+		params.Offset = int32(offset)
+	}
+	return &params
+}
+
+func writePage(w http.ResponseWriter, s *HttpServices, body string) {
+	w.Write(s.Nav())
+	w.Write([]byte("<pre>"))
+	w.Write([]byte(body))
+	w.Write([]byte("</pre>"))
+}
+
+func writeCSV(w http.ResponseWriter, filename string, records [][]string) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	_ = csv.NewWriter(w).WriteAll(records)
+}
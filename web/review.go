@@ -0,0 +1,295 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+	"github.com/FactomWyomingEntity/prosper-pool/treasury"
+)
+
+// AdminPayouts shows the next payout for review, and the proposals waiting on
+// a second admin. Form posts propose, approve, reject and execute payouts.
+func (s *HttpServices) AdminPayouts(w http.ResponseWriter, r *http.Request) {
+	if s.Accountant == nil {
+		w.Write(s.AdminNav())
+		_, _ = fmt.Fprintf(w, "<pre>Payouts are not enabled</pre>")
+		return
+	}
+
+	if r.Method == http.MethodPost && r.FormValue("action") == "export" {
+		// The response is the file, so only errors are shown on the page
+		err := s.exportProposal(w, r)
+		if err == nil {
+			return
+		}
+		w.Write(s.AdminNav())
+		_, _ = fmt.Fprintf(w, "<pre>Error:%s</pre>", html.EscapeString(err.Error()))
+	} else {
+		w.Write(s.AdminNav())
+		if r.Method == http.MethodPost {
+			if msg, err := s.payoutAction(r); err != nil {
+				_, _ = fmt.Fprintf(w, "<pre>Error:%s</pre>", html.EscapeString(err.Error()))
+			} else {
+				_, _ = fmt.Fprintf(w, "<pre>%s</pre>", html.EscapeString(msg))
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	defer func() { _, _ = w.Write(buf.Bytes()) }()
+
+	s.writePayoutPreview(r.Context(), &buf)
+
+	var proposals []accounting.PayoutProposal
+	if err := s.db.Order("id desc").Limit(20).Find(&proposals).Error; err != nil {
+		buf.WriteString(fmt.Sprintf("<pre>Error:%s</pre>", html.EscapeString(err.Error())))
+		return
+	}
+
+	buf.WriteString("<pre>Proposals, newest first\n")
+	for _, p := range proposals {
+		buf.WriteString(fmt.Sprintf("\t%d -> %s, Proposed: %s by %s, Approved by: %s, Payments: %d, Total: %d, Run: %d\n\t\tDigest: %s\n",
+			p.ID, p.State, p.CreatedAt.UTC().Format("2006-01-02 15:04:05"), html.EscapeString(p.ProposedBy),
+			html.EscapeString(p.ApprovedBy), p.Count, p.Total/1e8, p.RunID, p.Digest))
+	}
+	buf.WriteString("</pre>")
+
+	for _, p := range proposals {
+		switch p.State {
+		case accounting.ProposalProposed:
+			buf.WriteString(fmt.Sprintf(`
+	<form method="post" action="/admin/payouts">
+		<input type="hidden" name="id" value="%d" />
+		Proposal %d <button name="action" value="approve">Approve</button>
+		Reason <input name="reason" /> <button name="action" value="reject">Reject</button>
+	</form>`, p.ID, p.ID))
+		case accounting.ProposalApproved:
+			submit := ""
+			if s.Payouts != nil && s.Payouts.Signer != nil {
+				submit = `<button name="action" value="submit">Submit</button>`
+			}
+			buf.WriteString(fmt.Sprintf(`
+	<form method="post" action="/admin/payouts">
+		<input type="hidden" name="id" value="%d" />
+		Proposal %d %s <button name="action" value="export">Export to sign</button>
+		Reason <input name="reason" /> <button name="action" value="reject">Reject</button>
+	</form>`, p.ID, p.ID, submit))
+		case accounting.ProposalExecuted:
+			if p.RunID == 0 {
+				buf.WriteString(fmt.Sprintf(`
+	<pre>Proposal %d <a href="/admin/payouts/payments?id=%d">Download payments</a></pre>`, p.ID, p.ID))
+			}
+		}
+	}
+
+	var actions []accounting.PayoutAction
+	if err := s.db.Order("id desc").Limit(50).Find(&actions).Error; err != nil {
+		buf.WriteString(fmt.Sprintf("<pre>Error:%s</pre>", html.EscapeString(err.Error())))
+		return
+	}
+
+	buf.WriteString("<pre>Audit log, newest first\n")
+	for _, a := range actions {
+		buf.WriteString(fmt.Sprintf("\t%s -> Proposal: %d, Admin: %s, Action: %s, Detail: %s\n",
+			a.CreatedAt.UTC().Format("2006-01-02 15:04:05"), a.ProposalID, html.EscapeString(a.Admin),
+			html.EscapeString(a.Action), html.EscapeString(a.Detail)))
+	}
+	buf.WriteString("</pre>")
+}
+
+// writePayoutPreview writes the payments a new proposal would make
+func (s *HttpServices) writePayoutPreview(ctx context.Context, buf *bytes.Buffer) {
+	buf.WriteString("<pre>")
+	defer buf.WriteString("</pre>")
+
+	payments, err := s.Accountant.CalculatePayments()
+	if err != nil {
+		buf.WriteString(fmt.Sprintf("Error:%s\n", html.EscapeString(err.Error())))
+		return
+	}
+	if len(payments) == 0 {
+		buf.WriteString("Nothing to payout\n")
+		return
+	}
+
+	digest, err := accounting.PaymentsDigest(payments)
+	if err != nil {
+		buf.WriteString(fmt.Sprintf("Error:%s\n", html.EscapeString(err.Error())))
+		return
+	}
+
+	var total int64
+	batches := 0
+	for _, p := range payments {
+		total += p.PaymentAmount
+		if p.Batch+1 > batches {
+			batches = p.Batch + 1
+		}
+	}
+	buf.WriteString(fmt.Sprintf("The next payout is %d payments in %d batches, for a total of %d PEG.\nDigest: %s\n",
+		len(payments), batches, total/1e8, digest))
+	if s.Treasury != nil {
+		balance, err := s.Treasury.Check(ctx, total)
+		switch {
+		case err == treasury.ErrInsufficientBalance:
+			buf.WriteString(fmt.Sprintf("WARNING: the treasury %s only holds %s PEG, and cannot cover this payout\n",
+				s.Treasury.Address, FactoshiToFactoid(uint64(balance))))
+		case err != nil:
+			buf.WriteString(fmt.Sprintf("Treasury: %s, unable to get the balance: %s\n",
+				s.Treasury.Address, html.EscapeString(err.Error())))
+		default:
+			buf.WriteString(fmt.Sprintf("Treasury: %s holds %s PEG\n", s.Treasury.Address, FactoshiToFactoid(uint64(balance))))
+		}
+	}
+
+	factor := s.conf.GetInt64(config.ConfigPayoutLargeFactor)
+	anomalies, err := accounting.ReviewPayments(s.db, payments, factor)
+	if err != nil {
+		buf.WriteString(fmt.Sprintf("Error:%s\n", html.EscapeString(err.Error())))
+		return
+	}
+	buf.WriteString(fmt.Sprintf("\n%d payments to look at before approving\n", len(anomalies)))
+	for _, a := range anomalies {
+		buf.WriteString(fmt.Sprintf("\tUser: %s, Address: %s, Amount: %d, Reason: %s\n",
+			html.EscapeString(a.UserID), html.EscapeString(a.PayoutAddress), a.Amount, html.EscapeString(a.Reason)))
+	}
+
+	buf.WriteString("\nPayments\n")
+	for _, p := range payments {
+		buf.WriteString(fmt.Sprintf("\tUser: %s, Address: %s, Amount: %d, Batch: %d\n",
+			html.EscapeString(p.UserID), html.EscapeString(p.PayoutAddress), p.PaymentAmount, p.Batch))
+	}
+
+	// The digest makes sure the proposal is what was reviewed
+	buf.WriteString(fmt.Sprintf(`</pre>
+	<form method="post" action="/admin/payouts">
+		<input type="hidden" name="digest" value="%s" />
+		<button name="action" value="propose">Propose this payout</button>
+	</form><pre>`, digest))
+}
+
+func (s *HttpServices) payoutAction(r *http.Request) (string, error) {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		return "", err
+	}
+
+	action := r.FormValue("action")
+	if action == "propose" {
+		payments, err := s.Accountant.CalculatePayments()
+		if err != nil {
+			return "", err
+		}
+		digest, err := accounting.PaymentsDigest(payments)
+		if err != nil {
+			return "", err
+		}
+		if digest != r.FormValue("digest") {
+			return "", fmt.Errorf("the payments changed since they were shown, review them again")
+		}
+		p, err := accounting.ProposePayout(s.db, user.UID, payments)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Proposal %d is waiting on a second admin", p.ID), nil
+	}
+
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid proposal id")
+	}
+
+	switch action {
+	case "approve":
+		if _, err := accounting.ApprovePayout(s.db, uint(id), user.UID); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Proposal %d approved", id), nil
+	case "reject":
+		if _, err := accounting.RejectPayout(s.db, uint(id), user.UID, r.FormValue("reason")); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Proposal %d rejected", id), nil
+	case "submit":
+		if s.Payouts == nil {
+			return "", fmt.Errorf("the pool has no payout signer")
+		}
+		run, err := s.Payouts.SubmitProposal(r.Context(), uint(id), user.UID)
+		if err != nil {
+			if run != nil {
+				return "", fmt.Errorf("run %d: %s", run.ID, err.Error())
+			}
+			return "", err
+		}
+		return fmt.Sprintf("Proposal %d submitted as run %d", id, run.ID), nil
+	}
+	return "", fmt.Errorf("unknown action %q", action)
+}
+
+// exportProposal executes an approved proposal by returning it's payments to
+// be signed offline with the payout-cli.
+func (s *HttpServices) exportProposal(w http.ResponseWriter, r *http.Request) error {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid proposal id")
+	}
+
+	p, payments, err := accounting.ExecutePayout(s.db, uint(id), user.UID, "exported to sign")
+	if err != nil {
+		return err
+	}
+	return writePayments(w, p, payments)
+}
+
+// AdminPayoutPayments downloads the payments of an executed proposal again
+func (s *HttpServices) AdminPayoutPayments(w http.ResponseWriter, r *http.Request) {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var p accounting.PayoutProposal
+	if err := s.db.First(&p, r.FormValue("id")).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if p.State != accounting.ProposalExecuted {
+		http.Error(w, fmt.Sprintf("proposal %d is %s", p.ID, p.State), http.StatusBadRequest)
+		return
+	}
+
+	payments, err := p.GetPayments()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := accounting.LogPayoutAction(s.db, p.ID, user.UID, "download", ""); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = writePayments(w, &p, payments)
+}
+
+func writePayments(w http.ResponseWriter, p *accounting.PayoutProposal, payments []accounting.Paid) error {
+	data, err := json.MarshalIndent(payments, "", "\t")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=payout-%d.json", p.ID))
+	_, err = w.Write(data)
+	return err
+}
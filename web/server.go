@@ -4,12 +4,16 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/FactomWyomingEntity/prosper-pool/ledger"
 	"github.com/FactomWyomingEntity/prosper-pool/minutekeeper"
 
+	"github.com/FactomWyomingEntity/prosper-pool/sharesubmit"
 	"github.com/FactomWyomingEntity/prosper-pool/stratum"
+	"github.com/FactomWyomingEntity/prosper-pool/treasury"
 
 	"github.com/jinzhu/gorm"
 
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
 	"github.com/FactomWyomingEntity/prosper-pool/authentication"
 	"github.com/FactomWyomingEntity/prosper-pool/config"
 	log "github.com/sirupsen/logrus"
@@ -24,6 +28,11 @@ type HttpServices struct {
 	Auth          *authentication.Authenticator
 	StratumServer *stratum.Server
 	MinuteKeeper  *minutekeeper.MinuteKeeper
+	Accountant    *accounting.Accountant
+	Payouts       *accounting.PayoutScheduler
+	Treasury      *treasury.Treasury
+	Ledger        *ledger.Publisher
+	Submitter     *sharesubmit.Submitter
 	Primary       *http.Server
 	conf          *viper.Viper
 	db            *gorm.DB
@@ -44,6 +53,26 @@ func (s *HttpServices) SetMinuteKeeper(mk *minutekeeper.MinuteKeeper) {
 	s.MinuteKeeper = mk
 }
 
+func (s *HttpServices) SetTreasury(t *treasury.Treasury) {
+	s.Treasury = t
+}
+
+func (s *HttpServices) SetSubmitter(sub *sharesubmit.Submitter) {
+	s.Submitter = sub
+}
+
+// SetLedger enables the ledger proofs, if the pool publishes a ledger
+func (s *HttpServices) SetLedger(l *ledger.Publisher) {
+	s.Ledger = l
+}
+
+// SetPayouts enables the payout review. The scheduler is optional, and
+// submits approved payouts if set.
+func (s *HttpServices) SetPayouts(a *accounting.Accountant, payouts *accounting.PayoutScheduler) {
+	s.Accountant = a
+	s.Payouts = payouts
+}
+
 // MiddleWare acts as a middleware for all requests to the web/api
 func (s *HttpServices) MiddleWare() func(http.Handler) http.Handler {
 	f := func(h http.Handler) http.Handler {
@@ -70,6 +99,9 @@ func (s *HttpServices) InitPrimary(auth *authentication.Authenticator) {
 	// Init a basic "whoami"
 	primaryMux.HandleFunc("/whoami", s.WhoAmI)
 	primaryMux.HandleFunc("/user/owed", s.OwedPayouts)
+	primaryMux.HandleFunc("/user/workers", s.UserWorkers)
+	primaryMux.HandleFunc("/user/payments", s.UserPayments)
+	primaryMux.HandleFunc("/user/statements", s.UserStatements)
 	primaryMux.HandleFunc("/pool/rewards", s.PoolRewards)
 	primaryMux.HandleFunc("/pool/submissions", s.PoolSubmissions)
 	// primaryMux.HandleFunc("/api/v1/submitsync", s.MinuteKeeperInfo)
@@ -82,6 +114,11 @@ func (s *HttpServices) InitPrimary(auth *authentication.Authenticator) {
 	adminMux := http.NewServeMux()
 	adminMux.HandleFunc("/admin/links", s.AdminLinks)
 	adminMux.HandleFunc("/admin/miners", s.PoolMiners)
+	adminMux.HandleFunc("/admin/fees", s.AdminFees)
+	adminMux.HandleFunc("/admin/audit", s.AdminAudit)
+	adminMux.HandleFunc("/admin/withholding", s.AdminWithholding)
+	adminMux.HandleFunc("/admin/payouts", s.AdminPayouts)
+	adminMux.HandleFunc("/admin/payouts/payments", s.AdminPayoutPayments)
 	primaryMux.Handle("/admin/", s.Auth.Authority.Authorize("admin")(adminMux))
 
 	// Add /auth to primary mux
@@ -2,8 +2,10 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"html"
 	"math"
 	"net/http"
 	"regexp"
@@ -21,18 +23,52 @@ func (s *HttpServices) Nav() []byte {
 		`<a href="/admin/links">Admin</a><br />`)
 }
 
+// AdminNav is the nav of the admin pages, with a banner for anything that
+// needs an admin's attention.
+func (s *HttpServices) AdminNav() []byte {
+	nav := s.Nav()
+	if s.Submitter != nil {
+		if balance, low := s.Submitter.ECBalance(); low {
+			nav = append(nav, []byte(fmt.Sprintf(`<pre style="color:red">WARNING: the entry credit balance is low, only %d EC left</pre>`, balance))...)
+		}
+	}
+	return nav
+}
+
 func (s *HttpServices) Index(w http.ResponseWriter, r *http.Request) {
 	w.Write(s.Nav())
 }
 
 func (s *HttpServices) AdminLinks(w http.ResponseWriter, r *http.Request) {
-	w.Write(s.Nav())
+	w.Write(s.AdminNav())
 
 	w.Write([]byte(`
 	<ul>
 		<li><a href="/admin/miners">Miners</a></li>
+		<li><a href="/admin/fees">Fees</a></li>
+		<li><a href="/admin/audit">Audit</a></li>
+		<li><a href="/admin/withholding">Withholding</a></li>
+		<li><a href="/admin/payouts">Payouts</a></li>
 	</ul>
 	`))
+
+	if s.Treasury != nil {
+		_, _ = fmt.Fprintf(w, "<pre>Treasury: %s</pre>", html.EscapeString(s.treasuryBalance(r.Context())))
+	}
+	if s.Submitter != nil {
+		if balance, _ := s.Submitter.ECBalance(); balance >= 0 {
+			_, _ = fmt.Fprintf(w, "<pre>Entry credits: %d EC</pre>", balance)
+		}
+	}
+}
+
+// treasuryBalance describes the balance payouts are paid from
+func (s *HttpServices) treasuryBalance(ctx context.Context) string {
+	balance, err := s.Treasury.Balance(ctx)
+	if err != nil {
+		return fmt.Sprintf("%s, unable to get the balance: %s", s.Treasury.Address, err.Error())
+	}
+	return fmt.Sprintf("%s holds %s PEG", s.Treasury.Address, FactoshiToFactoid(uint64(balance)))
 }
 
 func (s *HttpServices) UserLinks(w http.ResponseWriter, r *http.Request) {
@@ -42,6 +78,9 @@ func (s *HttpServices) UserLinks(w http.ResponseWriter, r *http.Request) {
 	<ul>
 		<li><a href="/whoami">WhoAmI?</a></li>
 		<li><a href="/user/owed">Owed</a></li>
+		<li><a href="/user/payments">Payments</a></li>
+		<li><a href="/user/statements">Statements</a></li>
+		<li><a href="/user/workers">Workers</a></li>
 		<li><a href="/auth/login">Login</a></li>
 		<li><a href="/auth/logout">Logout</a></li>
 	</ul>
@@ -97,7 +136,16 @@ func (s *HttpServices) OwedPayouts(w http.ResponseWriter, r *http.Request) {
 	var ious []accounting.UserOwedPayouts
 	s.db.Order("job_id desc").Where("user_id = ?", user.UID).Limit(100).Find(&ious)
 
+	balance, err := accounting.GetUserBalance(s.db, user.UID)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "Error:%s", err.Error())
+		return
+	}
+
 	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("Owed: %s PEG, Paid: %s PEG, Outstanding: %s PEG\n\n",
+		FactoshiToFactoid(uint64(balance.Owed)), FactoshiToFactoid(uint64(balance.Paid)),
+		SignedFactoshiToFactoid(balance.Outstanding)))
 	buf.WriteString(fmt.Sprintf("This page displays the last 100 owed payouts for %s\n", user.UID))
 	for _, iou := range ious {
 		buf.WriteString(fmt.Sprintf("\tHeight: %d, PEG: %s, Proportion: %s, Shares: %.2f, HashRate: %.2f h\\s\n",
@@ -105,6 +153,26 @@ func (s *HttpServices) OwedPayouts(w http.ResponseWriter, r *http.Request) {
 			iou.Proportion.Truncate(3).String(), iou.UserDifficuty,
 			iou.HashRate))
 	}
+
+	var referrals []accounting.ReferralOwedPayouts
+	s.db.Order("job_id desc").Where("user_id = ?", user.UID).Limit(100).Find(&referrals)
+	if len(referrals) > 0 {
+		buf.WriteString(fmt.Sprintf("\nThe last 100 referral payouts, paid from the pool fee\n"))
+		for _, ref := range referrals {
+			buf.WriteString(fmt.Sprintf("\tHeight: %d, PEG: %s, Referred: %s\n",
+				ref.JobID, FactoshiToFactoid(uint64(ref.Payout)), html.EscapeString(ref.ReferredID)))
+		}
+	}
+
+	var found []accounting.FinderOwedPayouts
+	s.db.Order("job_id desc").Where("user_id = ?", user.UID).Limit(100).Find(&found)
+	if len(found) > 0 {
+		buf.WriteString(fmt.Sprintf("\nThe last 100 finder bonuses, paid from the pool fee\n"))
+		for _, f := range found {
+			buf.WriteString(fmt.Sprintf("\tHeight: %d, PEG: %s, Position: %d, Miner: %s\n",
+				f.JobID, FactoshiToFactoid(uint64(f.Payout)), f.Position, html.EscapeString(f.MinerID)))
+		}
+	}
 	_, _ = w.Write(buf.Bytes())
 }
 
@@ -168,7 +236,7 @@ func (s *HttpServices) PoolSubmissions(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *HttpServices) PoolMiners(w http.ResponseWriter, r *http.Request) {
-	w.Write(s.Nav())
+	w.Write(s.AdminNav())
 	w.Write([]byte("<pre>"))
 	defer w.Write([]byte("</pre>"))
 	// TODO: Add auth protection
@@ -220,6 +288,15 @@ func FactoshiToFactoid(i uint64) string {
 	return fmt.Sprintf("%s%s", ds, rs)
 }
 
+// SignedFactoshiToFactoid is FactoshiToFactoid for amounts that can be
+// negative, like an overpaid balance.
+func SignedFactoshiToFactoid(i int64) string {
+	if i < 0 {
+		return "-" + FactoshiToFactoid(uint64(-i))
+	}
+	return FactoshiToFactoid(uint64(i))
+}
+
 // FactoidToFactoshi takes a Factoid amount as a string and returns the value in
 // factoids
 func FactoidToFactoshi(amt string) uint64 {
@@ -0,0 +1,49 @@
+package web
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/config"
+)
+
+// AdminWithholding displays how every user's best shares compare to what is
+// expected over the withholding window. Flagged users may be withholding
+// their best shares.
+func (s *HttpServices) AdminWithholding(w http.ResponseWriter, r *http.Request) {
+	w.Write(s.AdminNav())
+	w.Write([]byte("<pre>"))
+	defer w.Write([]byte("</pre>"))
+
+	var latest sql.NullInt64
+	err := s.db.Model(&accounting.WithholdingStats{}).Select("max(job_id)").Row().Scan(&latest)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "Error:%s", html.EscapeString(err.Error()))
+		return
+	}
+
+	window := s.conf.GetInt32(config.ConfigWithholdingWindow)
+	minJobs := s.conf.GetInt(config.ConfigWithholdingMinJobs)
+	threshold := s.conf.GetFloat64(config.ConfigWithholdingThreshold)
+	from := int32(latest.Int64) - window + 1
+
+	reports, err := accounting.DetectWithholding(s.db, from, minJobs, threshold)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "Error:%s", html.EscapeString(err.Error()))
+		return
+	}
+
+	_, _ = fmt.Fprintf(w, "Best share spread of every user from job %d to %d. Users with at least %d jobs\n", from, latest.Int64, minJobs)
+	_, _ = fmt.Fprintf(w, "and a score below -%.2f are flagged, and may be withholding their best shares.\n\n", threshold)
+	for _, rep := range reports {
+		flag := ""
+		if rep.Flagged {
+			flag = "FLAGGED"
+		}
+		_, _ = fmt.Fprintf(w, "%8.2f\t%s\tJobs: %d, Expected: %.2f, Observed: %.2f\t%s\n",
+			rep.Score, html.EscapeString(rep.UserID), rep.Jobs, rep.Expected, rep.Observed, flag)
+	}
+}
@@ -0,0 +1,98 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/FactomWyomingEntity/prosper-pool/accounting"
+	"github.com/FactomWyomingEntity/prosper-pool/database"
+	"github.com/jinzhu/gorm"
+)
+
+// UserWorkers displays the per miner stats of the current user, so an
+// underperforming rig can be found.
+func (s *HttpServices) UserWorkers(w http.ResponseWriter, r *http.Request) {
+	w.Write(s.Nav())
+	w.Write([]byte("<pre>"))
+	defer w.Write([]byte("</pre>"))
+
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "Error:%s", err.Error())
+		return
+	}
+
+	// Only grab last 100 rows
+	var stats []accounting.WorkerJobStats
+	s.db.Order("job_id desc, miner_id asc").Where("user_id = ?", user.UID).Limit(100).Find(&stats)
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("This page displays the last 100 worker stats for %s\n", html.EscapeString(user.UID)))
+	for _, st := range stats {
+		buf.WriteString(fmt.Sprintf("\tHeight: %d, Miner: %s, Proportion: %s, Shares: %.2f, Submissions: %d, HashRate: %.2f h\\s\n",
+			st.JobID, html.EscapeString(st.MinerID), st.Proportion.Truncate(3).String(),
+			st.WorkerDifficulty, st.TotalSubmissions, st.HashRate))
+	}
+	_, _ = w.Write(buf.Bytes())
+}
+
+type WorkerStatsParams struct {
+	MinerID string `json:"minerid"`
+	JobID   int32  `json:"jobid"`
+	database.PaginationParams
+}
+
+type WorkerStatsResponse struct {
+	Data       []accounting.WorkerJobStats `json:"data"`
+	Pagination database.PaginationResponse `json:"info"`
+}
+
+// WorkerStats returns the per miner stats of the logged in user
+func (s *HttpServices) WorkerStats(r *http.Request, args *WorkerStatsParams, reply *WorkerStatsResponse) error {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	args.Default(50, "desc", "job_id").Max(MaxLimit)
+	db, err := database.SimplePagination(s.db, args.PaginationParams)
+	if err != nil {
+		return err
+	}
+
+	// Filter
+	db = db.Where("user_id = ?", user.UID)
+	if args.JobID != 0 {
+		db = db.Where("job_id = ?", args.JobID)
+	}
+	if args.MinerID != "" {
+		db = db.Where("miner_id = ?", args.MinerID)
+	}
+
+	err = db.Find(&reply.Data).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil // No records
+	}
+	if err != nil {
+		return err
+	}
+
+	total := database.TotalCount(db.Model(&accounting.WorkerJobStats{}))
+	reply.Pagination.TotalRecords = total
+	reply.Pagination.Records = len(reply.Data)
+	return nil
+}
+
+// Balance returns what the current user is owed and paid for all time
+func (s *HttpServices) Balance(r *http.Request, _ *json.RawMessage, reply *accounting.UserBalance) error {
+	user, err := s.GetCurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	*reply, err = accounting.GetUserBalance(s.db, user.UID)
+	return err
+}